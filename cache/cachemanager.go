@@ -1,11 +1,13 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"time"
 
 	"github.com/go-redis/redis"
+	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/sdclogger"
 )
 
@@ -14,21 +16,40 @@ type ICacheManager interface {
 	Connect() error
 	AddToSet(key string, value string) error
 	GetFromSet(key string) (string, error)
-	PopFromSet(key string) (string, error)
+	PopFromSet(ctx context.Context, key string) (string, error)
 	GetAllFromSet(key string) ([]string, error)
 	DeleteFromSet(key string, value string) error
 	GetLength(key string) (int64, error)
 	DeleteSet(key string) error
 	MoveSet(fromKey string, toKey string) error
 	CopySet(fromKey string, toKey string) error
+	IncrHashField(key string, field string) (int64, error)
+	SetHashField(key string, field string, value string) error
+	GetAllFromHash(key string) (map[string]string, error)
+	DeleteFromHash(key string, field string) error
+	Enqueue(queue string, value string) (bool, error)
+	EnqueueScored(queue string, value string, score float64) (bool, error)
+	QueueScore(queue string, value string) (float64, error)
+	EnqueueSet(fromKey string, queue string) (int64, error)
+	Lease(ctx context.Context, queue string, ttl time.Duration) (string, error)
+	Heartbeat(queue string, value string, ttl time.Duration) error
+	Ack(queue string, value string) error
+	Nack(queue string, value string) error
+	ReapExpired(queue string) (int64, error)
+	QueueLength(queue string) (int64, error)
+	GetAllFromQueue(queue string) ([]string, error)
+	DeleteQueue(queue string) error
+	PushToList(key string, value string) error
+	PopFromList(key string) (string, error)
 }
 
 type CacheManager struct {
 	clientHandle *redis.Client
+	redisConfig  config.RedisConfig
 }
 
-func NewCacheManager() *CacheManager {
-	return &CacheManager{clientHandle: nil}
+func NewCacheManager(cfg *config.Config) *CacheManager {
+	return &CacheManager{clientHandle: nil, redisConfig: cfg.Redis}
 }
 
 func (m *CacheManager) Disconnect() error {
@@ -46,11 +67,11 @@ func (m *CacheManager) Disconnect() error {
 }
 
 func (m *CacheManager) Connect() error {
-	redisAddr := os.Getenv("REDISHOST") + ":" + os.Getenv("REDISPORT")
+	redisAddr := m.redisConfig.Host + ":" + m.redisConfig.Port
 	m.clientHandle = redis.NewClient(&redis.Options{
 		Addr:     redisAddr,
-		Password: "",
-		DB:       0})
+		Password: m.redisConfig.Password,
+		DB:       m.redisConfig.DB})
 
 	res, err := m.clientHandle.Ping().Result()
 	if err != nil {
@@ -86,7 +107,11 @@ func (m *CacheManager) GetFromSet(key string) (string, error) {
 	return value, nil
 }
 
-func (m *CacheManager) PopFromSet(key string) (string, error) {
+func (m *CacheManager) PopFromSet(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", errors.New("Failed to pop a value from cache key " + key + ". Error: " + err.Error())
+	}
+
 	length, err := m.GetLength(key)
 	if err != nil {
 		return "", errors.New("Failed to pop the length of set key " + key + " from cache. Error: " + err.Error())
@@ -95,7 +120,7 @@ func (m *CacheManager) PopFromSet(key string) (string, error) {
 		return "", nil
 	}
 
-	value, err := m.clientHandle.SPop(key).Result()
+	value, err := m.clientHandle.WithContext(ctx).SPop(key).Result()
 	if err != nil {
 		return "", errors.New("Failed to pop a value from cache key " + key + ". Error: " + err.Error())
 	}
@@ -137,16 +162,19 @@ func (m *CacheManager) DeleteSet(key string) error {
 	sdclogger.SDCLoggerInstance.Printf("Delete %s from cache", key)
 	return nil
 }
-func (m *CacheManager) MoveSet(fromKey string, toKey string) error {
-	val, err := m.PopFromSet(fromKey)
-	for err == nil && len(val) > 0 {
-		if err2 := m.AddToSet(toKey, val); err != nil {
-			return fmt.Errorf("failed to add value %s to set %s. Error: %s", val, toKey, err2.Error())
-		}
 
-		val, err = m.PopFromSet(fromKey)
+// Move all members of the set to another set atomically
+func (m *CacheManager) MoveSet(fromKey string, toKey string) error {
+	_, err := m.clientHandle.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.SUnionStore(toKey, toKey, fromKey)
+		pipe.Del(fromKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move set %s to set %s. Error: %s", fromKey, toKey, err.Error())
 	}
-	return err
+	sdclogger.SDCLoggerInstance.Printf("Moved set %s to set %s", fromKey, toKey)
+	return nil
 }
 
 func (m *CacheManager) CopySet(fromKey string, toKey string) error {
@@ -162,3 +190,52 @@ func (m *CacheManager) CopySet(fromKey string, toKey string) error {
 	}
 	return err
 }
+
+func (m *CacheManager) IncrHashField(key string, field string) (int64, error) {
+	value, err := m.clientHandle.HIncrBy(key, field, 1).Result()
+	if err != nil {
+		return 0, errors.New("Failed to increase field " + field + " of cache key " + key + ". Error: " + err.Error())
+	}
+	return value, nil
+}
+
+func (m *CacheManager) SetHashField(key string, field string, value string) error {
+	if err := m.clientHandle.HSet(key, field, value).Err(); err != nil {
+		return errors.New("Failed to set field " + field + " of cache key " + key + ". Error: " + err.Error())
+	}
+	return nil
+}
+
+func (m *CacheManager) GetAllFromHash(key string) (map[string]string, error) {
+	all, err := m.clientHandle.HGetAll(key).Result()
+	if err != nil {
+		return nil, errors.New("Failed to get all fields of cache key " + key + ". Error: " + err.Error())
+	}
+	return all, nil
+}
+
+func (m *CacheManager) DeleteFromHash(key string, field string) error {
+	if err := m.clientHandle.HDel(key, field).Err(); err != nil {
+		return errors.New("Failed to remove field " + field + " from cache key " + key + ". Error: " + err.Error())
+	}
+	return nil
+}
+
+func (m *CacheManager) PushToList(key string, value string) error {
+	if err := m.clientHandle.RPush(key, value).Err(); err != nil {
+		return errors.New("Failed to push to list " + key + ". Error: " + err.Error())
+	}
+	return nil
+}
+
+// Pop the first value of the list. Returns an empty string if the list is empty.
+func (m *CacheManager) PopFromList(key string) (string, error) {
+	value, err := m.clientHandle.LPop(key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.New("Failed to pop from list " + key + ". Error: " + err.Error())
+	}
+	return value, nil
+}
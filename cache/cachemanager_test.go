@@ -1,33 +1,28 @@
 package cache
 
 import (
-	"os"
+	"context"
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/go-redis/redis"
-	"github.com/wayming/sdc/sdclogger"
-	testcommon "github.com/wayming/sdc/testcommon"
+	"github.com/wayming/sdc/config"
 )
 
 const CACHE_KEY_PROXY_TEST = "PROXIESTEST"
+const CACHE_KEY_QUEUE_TEST = "QUEUETEST"
 
-func SetupCacheManagerTest(testName string) {
-	testcommon.SetupTest(testName)
-}
-
-func TeardownCacheManagerTest() {
-	redisAddr := os.Getenv("REDISHOST") + ":" + os.Getenv("REDISPORT")
-	redisHandle := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: "",
-		DB:       0})
-	defer redisHandle.Close()
-
-	if err := redisHandle.Del(CACHE_KEY_PROXY_TEST).Err(); err != nil {
-		sdclogger.SDCLoggerInstance.Printf("Failed to drop cache set %s. Error: %s", CACHE_KEY_PROXY_TEST, err.Error())
-	}
-
-	testcommon.TeardownTest()
+// Config of an in-memory redis server stopped at the end of the test. The
+// scripts of the queues run on it as on redis.
+func testRedisConfig(t *testing.T) *config.Config {
+	server := miniredis.RunT(t)
+	cfg := config.NewConfig()
+	cfg.Redis.Host = server.Host()
+	cfg.Redis.Port = server.Port()
+	cfg.Redis.Password = ""
+	cfg.Redis.DB = 0
+	return cfg
 }
 
 func TestCacheManager_Connect(t *testing.T) {
@@ -48,12 +43,11 @@ func TestCacheManager_Connect(t *testing.T) {
 		},
 	}
 
-	SetupCacheManagerTest(t.Name())
-	defer TeardownCacheManagerTest()
+	cfg := testRedisConfig(t)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m := NewCacheManager()
+			m := NewCacheManager(cfg)
 			if err := m.Connect(); (err != nil) != tt.wantErr {
 				t.Errorf("CacheManager.Connect() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -98,13 +92,13 @@ func TestCacheManager_ProxyCache(t *testing.T) {
 		},
 	}
 
-	SetupCacheManagerTest(t.Name())
-	defer TeardownCacheManagerTest()
+	cfg := testRedisConfig(t)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m := &CacheManager{
 				clientHandle: tt.fields.clientHandle,
+				redisConfig:  cfg.Redis,
 			}
 
 			if err := m.Connect(); (err != nil) != tt.wantErr {
@@ -137,3 +131,135 @@ func TestCacheManager_ProxyCache(t *testing.T) {
 		})
 	}
 }
+
+func TestCacheManager_Queue(t *testing.T) {
+	cfg := testRedisConfig(t)
+
+	m := NewCacheManager(cfg)
+	if err := m.Connect(); err != nil {
+		t.Fatalf("CacheManager.Connect() error = %v", err)
+	}
+	defer m.Disconnect()
+
+	ctx := context.Background()
+	for _, symbol := range []string{"msft", "aapl", "msft"} {
+		if _, err := m.Enqueue(CACHE_KEY_QUEUE_TEST, symbol); err != nil {
+			t.Fatalf("CacheManager.Enqueue() error = %v", err)
+		}
+	}
+	if length, _ := m.QueueLength(CACHE_KEY_QUEUE_TEST); length != 2 {
+		t.Errorf("Expecting duplicated value queued once, got %d values", length)
+	}
+
+	// Acknowledged value leaves the queue
+	first, err := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute)
+	if err != nil || first != "msft" {
+		t.Fatalf("CacheManager.Lease() = %s, %v, want msft", first, err)
+	}
+	if err := m.Heartbeat(CACHE_KEY_QUEUE_TEST, first, time.Minute); err != nil {
+		t.Errorf("CacheManager.Heartbeat() error = %v", err)
+	}
+	if err := m.Ack(CACHE_KEY_QUEUE_TEST, first); err != nil {
+		t.Errorf("CacheManager.Ack() error = %v", err)
+	}
+	if length, _ := m.QueueLength(CACHE_KEY_QUEUE_TEST); length != 1 {
+		t.Errorf("Expecting 1 value left after ack, got %d", length)
+	}
+
+	// Expired lease is returned by the reaper
+	second, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Millisecond)
+	if empty, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute); empty != "" {
+		t.Errorf("Expecting no pending value, got %s", empty)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if reaped, err := m.ReapExpired(CACHE_KEY_QUEUE_TEST); err != nil || reaped != 1 {
+		t.Errorf("CacheManager.ReapExpired() = %d, %v, want 1", reaped, err)
+	}
+	if err := m.Heartbeat(CACHE_KEY_QUEUE_TEST, second, time.Minute); err == nil {
+		t.Errorf("Expecting heartbeat of an expired lease to fail")
+	}
+
+	// Nacked value is leased again
+	third, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute)
+	if err := m.Nack(CACHE_KEY_QUEUE_TEST, third); err != nil {
+		t.Errorf("CacheManager.Nack() error = %v", err)
+	}
+	if again, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute); again != "aapl" {
+		t.Errorf("Expecting aapl leased again, got %s", again)
+	}
+}
+
+func TestCacheManager_QueuePriority(t *testing.T) {
+	cfg := testRedisConfig(t)
+
+	m := NewCacheManager(cfg)
+	if err := m.Connect(); err != nil {
+		t.Fatalf("CacheManager.Connect() error = %v", err)
+	}
+	defer m.Disconnect()
+
+	ctx := context.Background()
+	m.Enqueue(CACHE_KEY_QUEUE_TEST, "zm")
+	m.EnqueueScored(CACHE_KEY_QUEUE_TEST, "msft", 3e12)
+	m.EnqueueScored(CACHE_KEY_QUEUE_TEST, "aapl", 2e12)
+	// Queued value is raised to the higher score
+	m.EnqueueScored(CACHE_KEY_QUEUE_TEST, "zm", 1e15)
+	m.EnqueueScored(CACHE_KEY_QUEUE_TEST, "msft", 1)
+	if score, err := m.QueueScore(CACHE_KEY_QUEUE_TEST, "msft"); err != nil || score != 3e12 {
+		t.Errorf("CacheManager.QueueScore() = %g, %v, want 3e12", score, err)
+	}
+
+	for _, want := range []string{"zm", "msft", "aapl"} {
+		if got, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute); got != want {
+			t.Errorf("Expecting %s leased, got %s", want, got)
+		}
+	}
+
+	// Nacked value keeps its priority
+	m.Enqueue(CACHE_KEY_QUEUE_TEST, "ibm")
+	if err := m.Nack(CACHE_KEY_QUEUE_TEST, "aapl"); err != nil {
+		t.Errorf("CacheManager.Nack() error = %v", err)
+	}
+	if again, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute); again != "aapl" {
+		t.Errorf("Expecting aapl leased before ibm, got %s", again)
+	}
+	m.Ack(CACHE_KEY_QUEUE_TEST, "aapl")
+	if score, _ := m.QueueScore(CACHE_KEY_QUEUE_TEST, "aapl"); score != 0 {
+		t.Errorf("Expecting no score after ack, got %g", score)
+	}
+}
+
+func TestCacheManager_EnqueueSet(t *testing.T) {
+	cfg := testRedisConfig(t)
+
+	m := NewCacheManager(cfg)
+	if err := m.Connect(); err != nil {
+		t.Fatalf("CacheManager.Connect() error = %v", err)
+	}
+	defer m.Disconnect()
+
+	ctx := context.Background()
+	m.EnqueueScored(CACHE_KEY_QUEUE_TEST, "zm", 1e15)
+	m.Enqueue(CACHE_KEY_QUEUE_TEST, "ibm")
+	for _, symbol := range []string{"msft", "ibm", "zm"} {
+		m.AddToSet(CACHE_KEY_PROXY_TEST, symbol)
+	}
+
+	// Values queued already are left in place
+	if added, err := m.EnqueueSet(CACHE_KEY_PROXY_TEST, CACHE_KEY_QUEUE_TEST); err != nil || added != 1 {
+		t.Fatalf("CacheManager.EnqueueSet() = %d, %v, want 1", added, err)
+	}
+	if length, _ := m.GetLength(CACHE_KEY_PROXY_TEST); length != 0 {
+		t.Errorf("Expecting the set removed, got %d values", length)
+	}
+	if length, _ := m.QueueLength(CACHE_KEY_QUEUE_TEST); length != 3 {
+		t.Errorf("Expecting 3 values queued, got %d", length)
+	}
+
+	// The priority of the values queued is kept
+	for _, want := range []string{"zm", "ibm", "msft"} {
+		if got, _ := m.Lease(ctx, CACHE_KEY_QUEUE_TEST, time.Minute); got != want {
+			t.Errorf("Expecting %s leased, got %s", want, got)
+		}
+	}
+}
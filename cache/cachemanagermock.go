@@ -5,7 +5,9 @@
 package cache
 
 import (
+	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -33,6 +35,20 @@ func (m *MockICacheManager) EXPECT() *MockICacheManagerMockRecorder {
 	return m.recorder
 }
 
+// Ack mocks base method.
+func (m *MockICacheManager) Ack(queue, value string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ack", queue, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ack indicates an expected call of Ack.
+func (mr *MockICacheManagerMockRecorder) Ack(queue, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ack", reflect.TypeOf((*MockICacheManager)(nil).Ack), queue, value)
+}
+
 // AddToSet mocks base method.
 func (m *MockICacheManager) AddToSet(key, value string) error {
 	m.ctrl.T.Helper()
@@ -75,6 +91,20 @@ func (mr *MockICacheManagerMockRecorder) CopySet(fromKey, toKey interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopySet", reflect.TypeOf((*MockICacheManager)(nil).CopySet), fromKey, toKey)
 }
 
+// DeleteFromHash mocks base method.
+func (m *MockICacheManager) DeleteFromHash(key, field string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFromHash", key, field)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteFromHash indicates an expected call of DeleteFromHash.
+func (mr *MockICacheManagerMockRecorder) DeleteFromHash(key, field interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFromHash", reflect.TypeOf((*MockICacheManager)(nil).DeleteFromHash), key, field)
+}
+
 // DeleteFromSet mocks base method.
 func (m *MockICacheManager) DeleteFromSet(key, value string) error {
 	m.ctrl.T.Helper()
@@ -89,6 +119,20 @@ func (mr *MockICacheManagerMockRecorder) DeleteFromSet(key, value interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFromSet", reflect.TypeOf((*MockICacheManager)(nil).DeleteFromSet), key, value)
 }
 
+// DeleteQueue mocks base method.
+func (m *MockICacheManager) DeleteQueue(queue string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteQueue", queue)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteQueue indicates an expected call of DeleteQueue.
+func (mr *MockICacheManagerMockRecorder) DeleteQueue(queue interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteQueue", reflect.TypeOf((*MockICacheManager)(nil).DeleteQueue), queue)
+}
+
 // DeleteSet mocks base method.
 func (m *MockICacheManager) DeleteSet(key string) error {
 	m.ctrl.T.Helper()
@@ -117,6 +161,81 @@ func (mr *MockICacheManagerMockRecorder) Disconnect() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Disconnect", reflect.TypeOf((*MockICacheManager)(nil).Disconnect))
 }
 
+// Enqueue mocks base method.
+func (m *MockICacheManager) Enqueue(queue, value string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Enqueue", queue, value)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Enqueue indicates an expected call of Enqueue.
+func (mr *MockICacheManagerMockRecorder) Enqueue(queue, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enqueue", reflect.TypeOf((*MockICacheManager)(nil).Enqueue), queue, value)
+}
+
+// EnqueueScored mocks base method.
+func (m *MockICacheManager) EnqueueScored(queue, value string, score float64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueScored", queue, value, score)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnqueueScored indicates an expected call of EnqueueScored.
+func (mr *MockICacheManagerMockRecorder) EnqueueScored(queue, value, score interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueScored", reflect.TypeOf((*MockICacheManager)(nil).EnqueueScored), queue, value, score)
+}
+
+// EnqueueSet mocks base method.
+func (m *MockICacheManager) EnqueueSet(fromKey, queue string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnqueueSet", fromKey, queue)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// EnqueueSet indicates an expected call of EnqueueSet.
+func (mr *MockICacheManagerMockRecorder) EnqueueSet(fromKey, queue interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnqueueSet", reflect.TypeOf((*MockICacheManager)(nil).EnqueueSet), fromKey, queue)
+}
+
+// GetAllFromHash mocks base method.
+func (m *MockICacheManager) GetAllFromHash(key string) (map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllFromHash", key)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllFromHash indicates an expected call of GetAllFromHash.
+func (mr *MockICacheManagerMockRecorder) GetAllFromHash(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllFromHash", reflect.TypeOf((*MockICacheManager)(nil).GetAllFromHash), key)
+}
+
+// GetAllFromQueue mocks base method.
+func (m *MockICacheManager) GetAllFromQueue(queue string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllFromQueue", queue)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllFromQueue indicates an expected call of GetAllFromQueue.
+func (mr *MockICacheManagerMockRecorder) GetAllFromQueue(queue interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllFromQueue", reflect.TypeOf((*MockICacheManager)(nil).GetAllFromQueue), queue)
+}
+
 // GetAllFromSet mocks base method.
 func (m *MockICacheManager) GetAllFromSet(key string) ([]string, error) {
 	m.ctrl.T.Helper()
@@ -162,6 +281,50 @@ func (mr *MockICacheManagerMockRecorder) GetLength(key interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLength", reflect.TypeOf((*MockICacheManager)(nil).GetLength), key)
 }
 
+// Heartbeat mocks base method.
+func (m *MockICacheManager) Heartbeat(queue, value string, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Heartbeat", queue, value, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Heartbeat indicates an expected call of Heartbeat.
+func (mr *MockICacheManagerMockRecorder) Heartbeat(queue, value, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Heartbeat", reflect.TypeOf((*MockICacheManager)(nil).Heartbeat), queue, value, ttl)
+}
+
+// IncrHashField mocks base method.
+func (m *MockICacheManager) IncrHashField(key, field string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IncrHashField", key, field)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// IncrHashField indicates an expected call of IncrHashField.
+func (mr *MockICacheManagerMockRecorder) IncrHashField(key, field interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IncrHashField", reflect.TypeOf((*MockICacheManager)(nil).IncrHashField), key, field)
+}
+
+// Lease mocks base method.
+func (m *MockICacheManager) Lease(ctx context.Context, queue string, ttl time.Duration) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Lease", ctx, queue, ttl)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Lease indicates an expected call of Lease.
+func (mr *MockICacheManagerMockRecorder) Lease(ctx, queue, ttl interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lease", reflect.TypeOf((*MockICacheManager)(nil).Lease), ctx, queue, ttl)
+}
+
 // MoveSet mocks base method.
 func (m *MockICacheManager) MoveSet(fromKey, toKey string) error {
 	m.ctrl.T.Helper()
@@ -176,17 +339,119 @@ func (mr *MockICacheManagerMockRecorder) MoveSet(fromKey, toKey interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MoveSet", reflect.TypeOf((*MockICacheManager)(nil).MoveSet), fromKey, toKey)
 }
 
+// Nack mocks base method.
+func (m *MockICacheManager) Nack(queue, value string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Nack", queue, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Nack indicates an expected call of Nack.
+func (mr *MockICacheManagerMockRecorder) Nack(queue, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Nack", reflect.TypeOf((*MockICacheManager)(nil).Nack), queue, value)
+}
+
+// PopFromList mocks base method.
+func (m *MockICacheManager) PopFromList(key string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PopFromList", key)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PopFromList indicates an expected call of PopFromList.
+func (mr *MockICacheManagerMockRecorder) PopFromList(key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PopFromList", reflect.TypeOf((*MockICacheManager)(nil).PopFromList), key)
+}
+
 // PopFromSet mocks base method.
-func (m *MockICacheManager) PopFromSet(key string) (string, error) {
+func (m *MockICacheManager) PopFromSet(ctx context.Context, key string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "PopFromSet", key)
+	ret := m.ctrl.Call(m, "PopFromSet", ctx, key)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // PopFromSet indicates an expected call of PopFromSet.
-func (mr *MockICacheManagerMockRecorder) PopFromSet(key interface{}) *gomock.Call {
+func (mr *MockICacheManagerMockRecorder) PopFromSet(ctx, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PopFromSet", reflect.TypeOf((*MockICacheManager)(nil).PopFromSet), ctx, key)
+}
+
+// PushToList mocks base method.
+func (m *MockICacheManager) PushToList(key, value string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PushToList", key, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PushToList indicates an expected call of PushToList.
+func (mr *MockICacheManagerMockRecorder) PushToList(key, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PushToList", reflect.TypeOf((*MockICacheManager)(nil).PushToList), key, value)
+}
+
+// QueueLength mocks base method.
+func (m *MockICacheManager) QueueLength(queue string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueueLength", queue)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueueLength indicates an expected call of QueueLength.
+func (mr *MockICacheManagerMockRecorder) QueueLength(queue interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueLength", reflect.TypeOf((*MockICacheManager)(nil).QueueLength), queue)
+}
+
+// QueueScore mocks base method.
+func (m *MockICacheManager) QueueScore(queue, value string) (float64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueueScore", queue, value)
+	ret0, _ := ret[0].(float64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueueScore indicates an expected call of QueueScore.
+func (mr *MockICacheManagerMockRecorder) QueueScore(queue, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueueScore", reflect.TypeOf((*MockICacheManager)(nil).QueueScore), queue, value)
+}
+
+// ReapExpired mocks base method.
+func (m *MockICacheManager) ReapExpired(queue string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReapExpired", queue)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReapExpired indicates an expected call of ReapExpired.
+func (mr *MockICacheManagerMockRecorder) ReapExpired(queue interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReapExpired", reflect.TypeOf((*MockICacheManager)(nil).ReapExpired), queue)
+}
+
+// SetHashField mocks base method.
+func (m *MockICacheManager) SetHashField(key, field, value string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetHashField", key, field, value)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetHashField indicates an expected call of SetHashField.
+func (mr *MockICacheManagerMockRecorder) SetHashField(key, field, value interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PopFromSet", reflect.TypeOf((*MockICacheManager)(nil).PopFromSet), key)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetHashField", reflect.TypeOf((*MockICacheManager)(nil).SetHashField), key, field, value)
 }
@@ -1,25 +1,40 @@
 package cache
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
-	"os/exec"
+	"sort"
 	"strings"
 
+	"github.com/wayming/sdc/proxy"
 	"github.com/wayming/sdc/sdclogger"
 )
 
-func LoadProxies(cm ICacheManager, key string, proxyFile string) (int, error) {
-	content, err := os.ReadFile(proxyFile)
+// Load the proxies of the file into the set of the key, one proxy record per
+// line in any of the forms parsed by proxy.Parse. Invalid records and proxies
+// failing the check are left out. Records are stored in the URL form, and the
+// results of the checks in the hash of the health key. Cancelling the context
+// stops the checks and fails the load.
+func LoadProxies(ctx context.Context, cm ICacheManager, key string, healthKey string, proxyFile string, checker *proxy.Checker) (int, error) {
+	proxies, err := ReadProxies(proxyFile)
 	if err != nil {
-		sdclogger.SDCLoggerInstance.Println("Failed to get proxies from file " + proxyFile + ". Error: " + err.Error())
-		return 0, errors.New(err.Error())
+		return 0, err
 	}
-	validProxies := testProxies(strings.Split(string(content), "\n"))
-	added := 0
 
-	for _, proxy := range validProxies {
-		if err := cm.AddToSet(key, proxy); err != nil {
+	results := CheckProxies(ctx, cm, healthKey, proxies, checker)
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to check proxies of %s: %v", proxyFile, err)
+	}
+	added := 0
+	for i, p := range proxies {
+		if !results[i].Healthy() {
+			sdclogger.SDCLoggerInstance.Printf("Ignore proxy %s, check %s. Error: %s", results[i].Proxy, results[i].Status, results[i].Error)
+			continue
+		}
+		if err := cm.AddToSet(key, p.String()); err != nil {
 			sdclogger.SDCLoggerInstance.Println(err.Error())
 		} else {
 			added++
@@ -30,72 +45,66 @@ func LoadProxies(cm ICacheManager, key string, proxyFile string) (int, error) {
 	return added, nil
 }
 
-func isProxyValid(proxy string) bool {
-	parts := strings.Split(proxy, ":")
-	proxyURL := parts[0] + ":" + parts[1]
-	proxyUser := parts[2]
-	proxyPassword := parts[3]
-	cmd := exec.Command("nc", "-w", "5", "-zv", parts[0], parts[1])
-	if err := cmd.Run(); err != nil {
-		sdclogger.SDCLoggerInstance.Println("Failed to ping "+proxyURL+". Error: ", err.Error())
-		return false
-	}
-	if cmd.ProcessState.ExitCode() != 0 {
-		return false
+// Proxies of the file. Invalid records are logged and left out.
+func ReadProxies(proxyFile string) ([]proxy.Proxy, error) {
+	content, err := os.ReadFile(proxyFile)
+	if err != nil {
+		sdclogger.SDCLoggerInstance.Println("Failed to get proxies from file " + proxyFile + ". Error: " + err.Error())
+		return nil, errors.New(err.Error())
 	}
 
-	cmd = exec.Command("wget",
-		"--timeout", "2",
-		"--tries", "1",
-		"-e", "use_proxy=yes",
-		"-e", "http_proxy="+proxyURL,
-		"--proxy-user", proxyUser,
-		"--proxy-password", proxyPassword,
-		"-O", "logs/proxy_test.html",
-		"-a", "logs/proxy_test.log",
-		"http://example.com")
-	if err := cmd.Run(); err != nil {
-		sdclogger.SDCLoggerInstance.Println("Failed to ping "+proxyURL+". Error: ", err.Error())
-		return false
-	}
-	if cmd.ProcessState.ExitCode() != 0 {
-		return false
+	var proxies []proxy.Proxy
+	for n, line := range strings.Split(string(content), "\n") {
+		p, err := proxy.Parse(line)
+		if errors.Is(err, proxy.ErrNoProxy) {
+			continue
+		}
+		if err != nil {
+			sdclogger.SDCLoggerInstance.Printf("Ignore line %d of %s. Error: %v", n+1, proxyFile, err)
+			continue
+		}
+		proxies = append(proxies, p)
 	}
-	return true
+	return proxies, nil
 }
 
-func testProxies(proxies []string) []string {
-	inChan := make(chan string, len(proxies))
-	ouChan := make(chan string, len(proxies))
-	defer close(ouChan)
-
-	numWorkers := 20
-	for i := 0; i < numWorkers; i++ {
-		go func(inChan chan string, ouChan chan string) {
-			for proxy := range inChan {
-				if isProxyValid(proxy) {
-					ouChan <- proxy
-				} else {
-					ouChan <- ""
-				}
-			}
-		}(inChan, ouChan)
+// Check the proxies and store the results in the hash of the health key, by
+// proxy record. Results are in the order of the proxies. Results of the checks
+// cut short by the context are not stored.
+func CheckProxies(ctx context.Context, cm ICacheManager, healthKey string, proxies []proxy.Proxy, checker *proxy.Checker) []proxy.CheckResult {
+	results := checker.CheckAll(ctx, proxies)
+	if ctx.Err() != nil {
+		return results
+	}
+	for i, result := range results {
+		text, err := json.Marshal(result)
+		if err != nil {
+			sdclogger.SDCLoggerInstance.Printf("Failed to marshal check result of proxy %s. Error: %v", result.Proxy, err)
+			continue
+		}
+		if err := cm.SetHashField(healthKey, proxies[i].String(), string(text)); err != nil {
+			sdclogger.SDCLoggerInstance.Printf("Failed to store check result of proxy %s. Error: %v", result.Proxy, err)
+		}
 	}
+	return results
+}
 
-	// Dispatch tasks
-	for _, proxy := range proxies {
-		inChan <- proxy
+// Results of the last checks stored in the hash of the health key, by proxy
+func ProxyHealth(cm ICacheManager, healthKey string) ([]proxy.CheckResult, error) {
+	fields, err := cm.GetAllFromHash(healthKey)
+	if err != nil {
+		return nil, errors.New("Failed to get proxy health from " + healthKey + ". Error: " + err.Error())
 	}
-	close(inChan)
 
-	// Harvest results
-	var validProxies []string
-	for i := 0; i < len(proxies); i++ {
-		validProxy, ok := <-ouChan
-		if ok && len(validProxy) > 0 {
-			validProxies = append(validProxies, validProxy)
+	results := make([]proxy.CheckResult, 0, len(fields))
+	for record, text := range fields {
+		var result proxy.CheckResult
+		if err := json.Unmarshal([]byte(text), &result); err != nil {
+			sdclogger.SDCLoggerInstance.Printf("Ignore check result of proxy %s. Error: %v", proxy.Label(record), err)
+			continue
 		}
+		results = append(results, result)
 	}
-
-	return validProxies
+	sort.Slice(results, func(i, j int) bool { return results[i].Proxy < results[j].Proxy })
+	return results, nil
 }
@@ -1,15 +1,23 @@
 package cache_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/golang/mock/gomock"
+
 	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/proxy"
 	"github.com/wayming/sdc/sdclogger"
-	testcommon "github.com/wayming/sdc/utils"
+	"github.com/wayming/sdc/testcommon"
 )
 
 const CACHE_KEY_PROXY_TEST = "PROXIESTEST"
+const CACHE_KEY_PROXY_HEALTH_TEST = "PROXIESTEST_HEALTH"
 
 func TestLoadProxies(t *testing.T) {
 	type args struct {
@@ -31,11 +39,12 @@ func TestLoadProxies(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			proxyCache := cache.NewCacheManager()
+			proxyCache := cache.NewCacheManager(testcommon.TestConfig())
 			proxyCache.Connect()
 			defer proxyCache.Disconnect()
 
-			added, err := cache.LoadProxies(proxyCache, CACHE_KEY_PROXY_TEST, tt.args.proxyFile)
+			added, err := cache.LoadProxies(context.Background(), proxyCache, CACHE_KEY_PROXY_TEST, CACHE_KEY_PROXY_HEALTH_TEST,
+				tt.args.proxyFile, proxy.NewCheckerByConfig(config.NewConfig()))
 			if added == 0 && err != nil {
 				t.Errorf("Failed to load proxies. Error: %s", err.Error())
 			}
@@ -57,3 +66,24 @@ func TestLoadProxies(t *testing.T) {
 	}
 
 }
+
+func TestLoadProxies_Cancelled(t *testing.T) {
+	proxyFile := filepath.Join(t.TempDir(), "proxies.txt")
+	if err := os.WriteFile(proxyFile, []byte("127.0.0.1:3128\nsocks5://10.0.0.1:1080\n"), 0644); err != nil {
+		t.Fatalf("Failed to write proxy file %s. Error: %v", proxyFile, err)
+	}
+
+	// Nothing is stored for the checks cut short
+	cm := cache.NewMockICacheManager(gomock.NewController(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	added, err := cache.LoadProxies(ctx, cm, CACHE_KEY_PROXY_TEST, CACHE_KEY_PROXY_HEALTH_TEST,
+		proxyFile, proxy.NewCheckerByConfig(config.NewConfig()))
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("Expecting the load cancelled, got %v", err)
+	}
+	if added != 0 {
+		t.Errorf("Expecting no proxy loaded, got %d", added)
+	}
+}
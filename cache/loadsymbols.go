@@ -2,21 +2,20 @@ package cache
 
 import (
 	"errors"
-	"os"
 	"reflect"
 	"strings"
 
+	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/dbloader"
 	"github.com/wayming/sdc/sdclogger"
 )
 
-func LoadSymbols(cm ICacheManager, key string, fromSchema string) (int64, error) {
-	dbLoader := dbloader.NewPGLoader(fromSchema, sdclogger.SDCLoggerInstance.Logger)
-	dbLoader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
+// Enqueue the symbols of ms_tickers to the queue
+func LoadSymbols(cm ICacheManager, queue string, cfg *config.Config) (int64, error) {
+	dbLoader, err := dbloader.NewPGLoaderByConfig(cfg, sdclogger.SDCLoggerInstance.Logger)
+	if err != nil {
+		return 0, err
+	}
 	defer dbLoader.Disconnect()
 
 	type queryResult struct {
@@ -34,7 +33,7 @@ func LoadSymbols(cm ICacheManager, key string, fromSchema string) (int64, error)
 	}
 
 	for _, row := range queryResults {
-		cm.AddToSet(key, strings.ToLower(row.Symbol))
+		cm.Enqueue(queue, strings.ToLower(row.Symbol))
 	}
 
 	return int64(len(queryResults)), nil
@@ -1,6 +1,7 @@
 package cache_test
 
 import (
+	"context"
 	"log"
 	"os"
 	"reflect"
@@ -8,7 +9,7 @@ import (
 
 	"github.com/wayming/sdc/cache"
 	"github.com/wayming/sdc/dbloader"
-	testcommon "github.com/wayming/sdc/utils"
+	"github.com/wayming/sdc/testcommon"
 )
 
 const CACHE_KEY_SYMBOL_TEST = "PROXIESTEST"
@@ -19,7 +20,7 @@ const SYMBOL_JSON_TEXT = `[
 ]`
 
 type SymbolJsonEntityStruct struct {
-	Symbol string `json:"symbol`
+	Symbol string `json:"symbol"`
 }
 
 var logger *log.Logger
@@ -38,7 +39,7 @@ func SetupCacheManagerTest(testName string) {
 	dbLoader.CreateSchema(SCHEMA_TEST)
 
 	// Add rows
-	dbLoader.LoadByJsonText(SYMBOL_JSON_TEXT, "ms_tickers", reflect.TypeFor[SymbolJsonEntityStruct]())
+	dbLoader.LoadByJsonText(context.Background(), SYMBOL_JSON_TEXT, "ms_tickers", reflect.TypeFor[SymbolJsonEntityStruct]())
 }
 
 func TeardownCacheManagerTest() {
@@ -64,13 +65,13 @@ func TestLoadSymbols(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    int
+		want    int64
 		wantErr bool
 	}{
 		{
 			name: "TestLoadSymbols",
 			args: args{
-				cache:      cache.NewCacheManager(),
+				cache:      cache.NewCacheManager(testcommon.TestConfig()),
 				key:        CACHE_KEY_SYMBOL_TEST,
 				fromSchema: SCHEMA_TEST,
 			},
@@ -84,7 +85,9 @@ func TestLoadSymbols(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := cache.LoadSymbols(tt.args.cache, tt.args.key, tt.args.fromSchema)
+			cfg := testcommon.TestConfig()
+			cfg.SchemaName = tt.args.fromSchema
+			got, err := cache.LoadSymbols(tt.args.cache, tt.args.key, cfg)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("LoadSymbols() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+// A reliable queue is kept in five keys. Values wait in the pending list, or
+// in the priority sorted set if queued with a positive score, until leased.
+// Values of the priority set are leased first, highest score first. Leased
+// values are kept in the in-progress sorted set, scored by the expiry time of
+// the lease, until they are acknowledged. The members set holds every value
+// not acknowledged yet, so that a value is queued once. The scores hash keeps
+// the score of the values until acknowledged, so that values returned to the
+// queue keep their priority.
+//
+// Leases are extended by heartbeats. Values with expired leases, e.g. leased
+// by a crashed process, are returned to the queue by ReapExpired.
+const (
+	QUEUE_SUFFIX_PENDING     = ":PENDING"
+	QUEUE_SUFFIX_IN_PROGRESS = ":IN_PROGRESS"
+	QUEUE_SUFFIX_MEMBERS     = ":MEMBERS"
+	QUEUE_SUFFIX_PRIORITY    = ":PRIORITY"
+	QUEUE_SUFFIX_SCORES      = ":SCORES"
+)
+
+// Current time of the redis server in milliseconds. Scripts replicate their
+// effects, so that the time can be used before writes.
+const luaNow = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// Return the value to the priority set if it has a score, otherwise to the
+// pending list.
+const luaRequeue = `
+local function requeue(v)
+	local score = redis.call('HGET', KEYS[5], v)
+	if score then
+		redis.call('ZADD', KEYS[4], score, v)
+	else
+		redis.call('RPUSH', KEYS[1], v)
+	end
+end
+`
+
+// The score of a value queued already is raised to the higher score
+var enqueueScript = redis.NewScript(`
+local score = tonumber(ARGV[2])
+if redis.call('SADD', KEYS[3], ARGV[1]) == 1 then
+	if score > 0 then
+		redis.call('HSET', KEYS[5], ARGV[1], ARGV[2])
+		redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+	else
+		redis.call('RPUSH', KEYS[1], ARGV[1])
+	end
+	return 1
+end
+if score > tonumber(redis.call('HGET', KEYS[5], ARGV[1]) or '0') then
+	redis.call('HSET', KEYS[5], ARGV[1], ARGV[2])
+	if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 1 or redis.call('ZSCORE', KEYS[4], ARGV[1]) then
+		redis.call('ZADD', KEYS[4], ARGV[2], ARGV[1])
+	end
+end
+return 0`)
+
+// The set is the key after the keys of the queue
+var enqueueSetScript = redis.NewScript(`
+local added = 0
+for _, v in ipairs(redis.call('SMEMBERS', KEYS[6])) do
+	if redis.call('SADD', KEYS[3], v) == 1 then
+		redis.call('RPUSH', KEYS[1], v)
+		added = added + 1
+	end
+end
+redis.call('DEL', KEYS[6])
+return added`)
+
+var leaseScript = redis.NewScript(luaNow + `
+local v = redis.call('ZREVRANGE', KEYS[4], 0, 0)[1]
+if v then
+	redis.call('ZREM', KEYS[4], v)
+else
+	v = redis.call('LPOP', KEYS[1])
+end
+if v then
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), v)
+end
+return v`)
+
+var heartbeatScript = redis.NewScript(luaNow + `
+if redis.call('ZSCORE', KEYS[2], ARGV[2]) then
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), ARGV[2])
+	return 1
+end
+return 0`)
+
+var ackScript = redis.NewScript(`
+local removed = redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('SREM', KEYS[3], ARGV[1])
+redis.call('HDEL', KEYS[5], ARGV[1])
+return removed`)
+
+var nackScript = redis.NewScript(luaRequeue + `
+if redis.call('ZREM', KEYS[2], ARGV[1]) == 1 then
+	requeue(ARGV[1])
+	return 1
+end
+return 0`)
+
+var reapScript = redis.NewScript(luaNow + luaRequeue + `
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)
+for _, v in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], v)
+	requeue(v)
+end
+return #expired`)
+
+func queueKeys(queue string) []string {
+	return []string{
+		queue + QUEUE_SUFFIX_PENDING,
+		queue + QUEUE_SUFFIX_IN_PROGRESS,
+		queue + QUEUE_SUFFIX_MEMBERS,
+		queue + QUEUE_SUFFIX_PRIORITY,
+		queue + QUEUE_SUFFIX_SCORES,
+	}
+}
+
+// Add the value to the pending list unless it is already in the queue.
+// Returns true if the value is added.
+func (m *CacheManager) Enqueue(queue string, value string) (bool, error) {
+	return m.EnqueueScored(queue, value, 0)
+}
+
+// Add the value to the queue with the given score. Values of higher scores
+// are leased first, and values without positive score are leased last in
+// the order queued. The score of a value already in the queue is raised if
+// the given score is higher. Returns true if the value is added.
+func (m *CacheManager) EnqueueScored(queue string, value string, score float64) (bool, error) {
+	added, err := enqueueScript.Run(m.clientHandle, queueKeys(queue), value, score).Int64()
+	if err != nil {
+		return false, errors.New("Failed to enqueue " + value + " to queue " + queue + ". Error: " + err.Error())
+	}
+	if added > 0 {
+		sdclogger.SDCLoggerInstance.Printf("Enqueue %s to queue %s with score %g", value, queue, score)
+	}
+	return added > 0, nil
+}
+
+// Score of the value in the queue. Zero if the value has no score.
+func (m *CacheManager) QueueScore(queue string, value string) (float64, error) {
+	score, err := m.clientHandle.HGet(queue+QUEUE_SUFFIX_SCORES, value).Float64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.New("Failed to get the score of " + value + " in queue " + queue + ". Error: " + err.Error())
+	}
+	return score, nil
+}
+
+// Move all members of the set to the queue atomically. Returns the number of
+// values added to the queue.
+func (m *CacheManager) EnqueueSet(fromKey string, queue string) (int64, error) {
+	keys := append(queueKeys(queue), fromKey)
+	added, err := enqueueSetScript.Run(m.clientHandle, keys).Int64()
+	if err != nil {
+		return 0, errors.New("Failed to move set " + fromKey + " to queue " + queue + ". Error: " + err.Error())
+	}
+	sdclogger.SDCLoggerInstance.Printf("Moved %d values from set %s to queue %s", added, fromKey, queue)
+	return added, nil
+}
+
+// Lease the value of the highest score, or the first pending value if no
+// value has a score, for the given time. Returns an empty string
+// if no value is pending.
+func (m *CacheManager) Lease(ctx context.Context, queue string, ttl time.Duration) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", errors.New("Failed to lease a value from queue " + queue + ". Error: " + err.Error())
+	}
+	value, err := leaseScript.Run(m.clientHandle.WithContext(ctx), queueKeys(queue), ttl.Milliseconds()).String()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.New("Failed to lease a value from queue " + queue + ". Error: " + err.Error())
+	}
+	sdclogger.SDCLoggerInstance.Printf("Lease %s from queue %s", value, queue)
+	return value, nil
+}
+
+// Extend the lease of the value. Fails if the value is not leased any more.
+func (m *CacheManager) Heartbeat(queue string, value string, ttl time.Duration) error {
+	extended, err := heartbeatScript.Run(m.clientHandle, queueKeys(queue), ttl.Milliseconds(), value).Int64()
+	if err != nil {
+		return errors.New("Failed to extend the lease of " + value + " in queue " + queue + ". Error: " + err.Error())
+	}
+	if extended == 0 {
+		return errors.New("Failed to extend the lease of " + value + " in queue " + queue + ". Error: lease expired")
+	}
+	return nil
+}
+
+// Remove the leased value from the queue once it is processed
+func (m *CacheManager) Ack(queue string, value string) error {
+	if err := ackScript.Run(m.clientHandle, queueKeys(queue), value).Err(); err != nil {
+		return errors.New("Failed to acknowledge " + value + " in queue " + queue + ". Error: " + err.Error())
+	}
+	sdclogger.SDCLoggerInstance.Printf("Acknowledge %s in queue %s", value, queue)
+	return nil
+}
+
+// Return the leased value to the queue
+func (m *CacheManager) Nack(queue string, value string) error {
+	if err := nackScript.Run(m.clientHandle, queueKeys(queue), value).Err(); err != nil {
+		return errors.New("Failed to return " + value + " to queue " + queue + ". Error: " + err.Error())
+	}
+	sdclogger.SDCLoggerInstance.Printf("Return %s to queue %s", value, queue)
+	return nil
+}
+
+// Return the values with expired leases to the queue. Returns the
+// number of values returned.
+func (m *CacheManager) ReapExpired(queue string) (int64, error) {
+	reaped, err := reapScript.Run(m.clientHandle, queueKeys(queue)).Int64()
+	if err != nil {
+		return 0, errors.New("Failed to reap expired leases of queue " + queue + ". Error: " + err.Error())
+	}
+	if reaped > 0 {
+		sdclogger.SDCLoggerInstance.Printf("Returned %d expired leases to queue %s", reaped, queue)
+	}
+	return reaped, nil
+}
+
+// Number of values not acknowledged, pending or leased
+func (m *CacheManager) QueueLength(queue string) (int64, error) {
+	length, err := m.clientHandle.SCard(queue + QUEUE_SUFFIX_MEMBERS).Result()
+	if err != nil {
+		return 0, errors.New("Failed to get the length of queue " + queue + ". Error: " + err.Error())
+	}
+	return length, nil
+}
+
+// Values not acknowledged, pending or leased
+func (m *CacheManager) GetAllFromQueue(queue string) ([]string, error) {
+	all, err := m.clientHandle.SMembers(queue + QUEUE_SUFFIX_MEMBERS).Result()
+	if err != nil {
+		return nil, errors.New("Failed to get the values of queue " + queue + ". Error: " + err.Error())
+	}
+	return all, nil
+}
+
+func (m *CacheManager) DeleteQueue(queue string) error {
+	if err := m.clientHandle.Del(queueKeys(queue)...).Err(); err != nil {
+		return errors.New("Failed to delete queue " + queue + " from cache. Error: " + err.Error())
+	}
+	sdclogger.SDCLoggerInstance.Printf("Delete queue %s from cache", queue)
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+)
+
+var cacheCommand = &command{
+	name:    "cache",
+	summary: "Manage the symbol and proxy caches.",
+	subcommands: []*command{
+		cacheClearCommand,
+	},
+}
+
+var cacheClearCommand = &command{
+	name:    "clear",
+	summary: "Remove all symbols and proxies from the cache.",
+	configFlags: func(f *config.Flags) {
+		f.RegisterCacheFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		return func(ctx context.Context, cfg *config.Config) error {
+			if err := collector.ClearCache(cfg); err != nil {
+				return err
+			}
+			fmt.Println("Reset cache done.")
+			return nil
+		}
+	},
+}
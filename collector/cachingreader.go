@@ -0,0 +1,159 @@
+package collector
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+// Kinds of the requests cached
+const (
+	HTTP_CACHE_READ     = "read"
+	HTTP_CACHE_REDIRECT = "redirect"
+)
+
+// Returned by offline readers for the requests not cached
+var ErrNotCached = errors.New("response not cached")
+
+// Response cached on disk
+type cacheEntry struct {
+	Kind      string            `json:"kind"`
+	URL       string            `json:"url"`
+	Params    map[string]string `json:"params,omitempty"`
+	FetchedAt time.Time         `json:"fetched_at"`
+	Body      string            `json:"body"` // Redirected url of redirect requests
+}
+
+// IHttpReader caching the responses of the reader on disk, keyed by the url
+// and the parameters of the requests. Responses older than the TTL are
+// requested again. Offline readers read the cache only, whatever the age of
+// the responses, and fail the requests not cached with ErrNotCached.
+//
+// Failed requests and empty responses are not cached.
+type CachingReader struct {
+	reader  IHttpReader // Not called offline
+	dir     string
+	ttl     time.Duration
+	offline bool
+}
+
+func NewCachingReader(reader IHttpReader, cfg config.HTTPCacheConfig) *CachingReader {
+	return &CachingReader{reader: reader, dir: cfg.Dir, ttl: cfg.TTL, offline: cfg.Offline}
+}
+
+// The reader wrapped in a caching reader if the configuration has an http
+// cache, otherwise the reader itself
+func WithHttpCache(reader IHttpReader, cfg *config.Config) IHttpReader {
+	if cfg == nil || len(cfg.HTTPCache.Dir) == 0 {
+		return reader
+	}
+	return NewCachingReader(reader, cfg.HTTPCache)
+}
+
+func (r *CachingReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	return r.get(HTTP_CACHE_READ, url, params, func() (string, error) {
+		return r.reader.Read(ctx, url, params)
+	})
+}
+
+func (r *CachingReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	return r.get(HTTP_CACHE_REDIRECT, url, nil, func() (string, error) {
+		return r.reader.RedirectedUrl(ctx, url)
+	})
+}
+
+func (r *CachingReader) get(kind string, url string, params map[string]string, fetch func() (string, error)) (string, error) {
+	file := r.path(kind, url, params)
+	entry, err := r.load(file)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if entry != nil && len(entry.Body) == 0 {
+		entry = nil // Cached by earlier versions
+	}
+	if entry != nil && (r.offline || r.ttl == 0 || time.Since(entry.FetchedAt) < r.ttl) {
+		return entry.Body, nil
+	}
+	if r.offline {
+		return "", fmt.Errorf("failed to %s %s offline: %w", kind, url, ErrNotCached)
+	}
+
+	body, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	if len(body) == 0 {
+		sdclogger.SDCLoggerInstance.Printf("Empty response of %s %s not cached.", kind, url)
+		return body, nil
+	}
+	entry = &cacheEntry{kind, url, params, time.Now().UTC(), body}
+	if err := r.save(file, entry); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+	return body, nil
+}
+
+// Responses are spread across directories by the first byte of the key
+func (r *CachingReader) path(kind string, url string, params map[string]string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", kind, url)
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(h, "%s=%s\n", key, params[key])
+	}
+	key := hex.EncodeToString(h.Sum(nil))
+	return filepath.Join(r.dir, key[:2], key+".json")
+}
+
+func (r *CachingReader) load(file string) (*cacheEntry, error) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(text, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse cached response %s: %v", file, err)
+	}
+	return &entry, nil
+}
+
+// Written to a temporary file first, so that readers never see partial files
+func (r *CachingReader) save(file string, entry *cacheEntry) error {
+	text, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal response of %s: %v", entry.URL, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create http cache directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to cache response of %s: %v", entry.URL, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(text); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to cache response of %s: %v", entry.URL, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to cache response of %s: %v", entry.URL, err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to cache response of %s: %v", entry.URL, err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package collector_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+)
+
+// Page reader counting the requests
+type countingReader struct {
+	pages pageReader
+	reads int
+}
+
+func (r *countingReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	r.reads++
+	return r.pages.Read(ctx, url, params)
+}
+
+func (r *countingReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	r.reads++
+	return url + "/redirected", nil
+}
+
+func TestCachingReader_Read(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	upstream := &countingReader{pages: pageReader{"https://sa/stocks/msft": "<html>msft</html>", "https://sa/stocks/empty": ""}}
+	r := NewCachingReader(upstream, config.HTTPCacheConfig{Dir: dir, TTL: time.Hour})
+
+	params := map[string]string{"p": "quarterly", "type": "ratios"}
+	for i := 0; i < 2; i++ {
+		got, err := r.Read(ctx, "https://sa/stocks/msft", params)
+		if err != nil || got != "<html>msft</html>" {
+			t.Fatalf("CachingReader.Read() = %s, %v", got, err)
+		}
+	}
+	r.RedirectedUrl(ctx, "https://sa/stocks/fb")
+	r.RedirectedUrl(ctx, "https://sa/stocks/fb")
+	if upstream.reads != 2 {
+		t.Errorf("Expecting one request of the page and one of the redirect, got %d", upstream.reads)
+	}
+
+	// Failures are not cached
+	if _, err := r.Read(ctx, "https://sa/stocks/missing", nil); err == nil {
+		t.Errorf("Expecting error reading a missing page")
+	}
+	r.Read(ctx, "https://sa/stocks/missing", nil)
+	if upstream.reads != 4 {
+		t.Errorf("Expecting failed requests sent again, got %d requests", upstream.reads)
+	}
+
+	// Neither are empty responses
+	r.Read(ctx, "https://sa/stocks/empty", nil)
+	r.Read(ctx, "https://sa/stocks/empty", nil)
+	if upstream.reads != 6 {
+		t.Errorf("Expecting empty responses requested again, got %d requests", upstream.reads)
+	}
+
+	// Offline readers read the pages cached whatever their age, in any order
+	// of the parameters
+	offline := NewCachingReader(&countingReader{}, config.HTTPCacheConfig{Dir: dir, TTL: time.Nanosecond, Offline: true})
+	got, err := offline.Read(ctx, "https://sa/stocks/msft", map[string]string{"type": "ratios", "p": "quarterly"})
+	if err != nil || got != "<html>msft</html>" {
+		t.Errorf("Offline CachingReader.Read() = %s, %v", got, err)
+	}
+	if got, _ := offline.RedirectedUrl(ctx, "https://sa/stocks/fb"); got != "https://sa/stocks/fb/redirected" {
+		t.Errorf("Offline CachingReader.RedirectedUrl() = %s", got)
+	}
+	if _, err := offline.Read(ctx, "https://sa/stocks/msft", nil); !errors.Is(err, ErrNotCached) {
+		t.Errorf("Expecting ErrNotCached for a page not cached, got %v", err)
+	}
+	if _, err := offline.Read(ctx, "https://sa/stocks/empty", nil); !errors.Is(err, ErrNotCached) {
+		t.Errorf("Expecting ErrNotCached for an empty page, got %v", err)
+	}
+
+	// Pages older than the TTL are requested again
+	expired := NewCachingReader(upstream, config.HTTPCacheConfig{Dir: dir, TTL: time.Nanosecond})
+	expired.Read(ctx, "https://sa/stocks/msft", params)
+	if upstream.reads != 7 {
+		t.Errorf("Expecting expired page requested again, got %d requests", upstream.reads)
+	}
+}
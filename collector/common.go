@@ -3,11 +3,11 @@ package collector
 import (
 	"errors"
 	"fmt"
-	"os"
 	"reflect"
 	"regexp"
 
 	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/dbloader"
 	"github.com/wayming/sdc/sdclogger"
 )
@@ -15,11 +15,17 @@ import (
 const LOG_FILE = "logs/sdc.log"
 const PROXY_FILE = "data/proxies.txt"
 const CACHE_KEY_PROXY = "PROXIES"
-const CACHE_KEY_SYMBOL = "SYMBOLS"
+const CACHE_KEY_PROXY_BANNED = "PROXIES_BANNED"
+const CACHE_KEY_PROXY_STATS = "PROXIES_STATS"
+const CACHE_KEY_PROXY_HEALTH = "PROXIES_HEALTH"
+const CACHE_KEY_SYMBOL = "SYMBOLS" // Queue of the symbols to be processed
 const CACHE_KEY_SYMBOL_ERROR = "SYMBOLS_ERROR"
 const CACHE_KEY_SYMBOL_INVALID = "SYMBOLS_INVALID"
 const CACHE_KEY_SYMBOL_NODATA = "SYMBOLS_NODATA"
 const CACHE_KEY_SYMBOL_REDIRECTED = "SYMBOLS_REDIRECTED"
+const CACHE_KEY_SYMBOL_DEAD = "SYMBOLS_DEAD"
+const CACHE_KEY_SYMBOL_ATTEMPTS = "SYMBOLS_ATTEMPTS"
+const CACHE_KEY_SYMBOL_LAST_ERROR = "SYMBOLS_LAST_ERROR"
 
 const TABLE_MS_TICKERS = "ms_tickers"
 
@@ -40,8 +46,8 @@ func concatMaps(maps ...map[string]interface{}) (map[string]interface{}, error)
 	return results, nil
 }
 
-func ClearCache() error {
-	cm := cache.NewCacheManager()
+func ClearCache(cfg *config.Config) error {
+	cm := cache.NewCacheManager(cfg)
 	if err := cm.Connect(); err != nil {
 		return err
 	}
@@ -50,7 +56,16 @@ func ClearCache() error {
 	if err := cm.DeleteSet(CACHE_KEY_PROXY); err != nil {
 		return err
 	}
-	if err := cm.DeleteSet(CACHE_KEY_SYMBOL); err != nil {
+	if err := cm.DeleteSet(CACHE_KEY_PROXY_BANNED); err != nil {
+		return err
+	}
+	if err := cm.DeleteSet(CACHE_KEY_PROXY_STATS); err != nil {
+		return err
+	}
+	if err := cm.DeleteSet(CACHE_KEY_PROXY_HEALTH); err != nil {
+		return err
+	}
+	if err := cm.DeleteQueue(CACHE_KEY_SYMBOL); err != nil {
 		return err
 	}
 	if err := cm.DeleteSet(CACHE_KEY_SYMBOL_ERROR); err != nil {
@@ -62,16 +77,58 @@ func ClearCache() error {
 	if err := cm.DeleteSet(CACHE_KEY_SYMBOL_INVALID); err != nil {
 		return err
 	}
+	if err := cm.DeleteSet(CACHE_KEY_SYMBOL_DEAD); err != nil {
+		return err
+	}
+	if err := cm.DeleteSet(CACHE_KEY_SYMBOL_ATTEMPTS); err != nil {
+		return err
+	}
+	if err := cm.DeleteSet(CACHE_KEY_SYMBOL_LAST_ERROR); err != nil {
+		return err
+	}
 	return nil
 }
-func DropSchema(schema string) error {
-	dbLoader := dbloader.NewPGLoader(schema, sdclogger.SDCLoggerInstance.Logger)
-	dbLoader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
-	return dbLoader.DropSchema(schema)
+
+// Number of entries in each of the cache sets used by the collectors
+func CacheStatus(cfg *config.Config) (map[string]int64, error) {
+	cm := cache.NewCacheManager(cfg)
+	if err := cm.Connect(); err != nil {
+		return nil, err
+	}
+	defer cm.Disconnect()
+
+	status := make(map[string]int64)
+	for _, key := range []string{
+		CACHE_KEY_PROXY,
+		CACHE_KEY_PROXY_BANNED,
+		CACHE_KEY_SYMBOL_ERROR,
+		CACHE_KEY_SYMBOL_INVALID,
+		CACHE_KEY_SYMBOL_REDIRECTED,
+		CACHE_KEY_SYMBOL_DEAD,
+	} {
+		length, err := cm.GetLength(key)
+		if err != nil {
+			return nil, err
+		}
+		status[key] = length
+	}
+
+	// Symbols pending or in progress
+	length, err := cm.QueueLength(CACHE_KEY_SYMBOL)
+	if err != nil {
+		return nil, err
+	}
+	status[CACHE_KEY_SYMBOL] = length
+	return status, nil
+}
+
+func DropSchema(cfg *config.Config) error {
+	dbLoader, err := dbloader.NewPGLoaderByConfig(cfg, sdclogger.SDCLoggerInstance.Logger)
+	if err != nil {
+		return err
+	}
+	defer dbLoader.Disconnect()
+	return dbLoader.DropSchema(cfg.SchemaName)
 }
 
 type JsonFieldMetadata struct {
@@ -137,11 +194,11 @@ func IsKeyField(fieldsMetadata map[string]JsonFieldMetadata, jsonTagValue string
 	return false
 }
 
-func CacheCleanup() {
-	cm := cache.NewCacheManager()
+func CacheCleanup(cfg *config.Config) {
+	cm := cache.NewCacheManager(cfg)
 	cm.Connect()
 	cm.DeleteSet(CACHE_KEY_PROXY)
-	cm.DeleteSet(CACHE_KEY_SYMBOL)
+	cm.DeleteQueue(CACHE_KEY_SYMBOL)
 	cm.DeleteSet(CACHE_KEY_SYMBOL_ERROR)
 	cm.DeleteSet(CACHE_KEY_SYMBOL_REDIRECTED)
 	cm.DeleteSet(CACHE_KEY_SYMBOL_INVALID)
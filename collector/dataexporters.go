@@ -1,16 +1,18 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 
 	"github.com/wayming/sdc/dbloader"
 	"github.com/wayming/sdc/sdclogger"
 )
 
 type IDataExporter interface {
-	Export(entityType reflect.Type, table string, data string, symbol string) error
+	Export(ctx context.Context, entityType reflect.Type, table string, data string, symbol string) error
 }
 
 type FileExporter struct {
@@ -41,7 +43,7 @@ func NewSAFileExporter() *FileExporter {
 	return &FileExporter{path: dir}
 }
 
-func (e FileExporter) Export(entityType reflect.Type, table string, data string, symbol string) error {
+func (e FileExporter) Export(ctx context.Context, entityType reflect.Type, table string, data string, symbol string) error {
 	dir := e.path + "/"
 	if len(symbol) > 0 {
 		dir += symbol
@@ -70,8 +72,8 @@ func NewDBExporter(db dbloader.DBLoader, schema string) *DBExporter {
 		schema: schema}
 }
 
-func (e DBExporter) Export(entityType reflect.Type, table string, data string, symbol string) error {
-	numOfRows, err := e.db.LoadByJsonText(data, table, entityType)
+func (e DBExporter) Export(ctx context.Context, entityType reflect.Type, table string, data string, symbol string) error {
+	numOfRows, err := loadByJsonText(ctx, e.db, data, table, entityType)
 	if err != nil {
 		return fmt.Errorf("failed to load json text to table %s: %v", table, err)
 	}
@@ -79,6 +81,27 @@ func (e DBExporter) Export(entityType reflect.Type, table string, data string, s
 	return nil
 }
 
+// Load the JSON text into the table, counting the rows loaded in the metrics
+func loadByJsonText(ctx context.Context, db dbloader.DBLoader, data string, table string, entityType reflect.Type) (int64, error) {
+	numOfRows, err := db.LoadByJsonText(ctx, data, table, entityType)
+	if err != nil {
+		return numOfRows, err
+	}
+	if numOfRows > 0 {
+		rowsLoaded.WithLabelValues(metricsTable(table)).Add(float64(numOfRows))
+	}
+	return numOfRows, nil
+}
+
+// Table the rows are counted by in the metrics. The EOD tables of the symbols
+// are counted as one, so that the series do not grow with the symbols.
+func metricsTable(table string) string {
+	if strings.HasPrefix(table, YFDataTables[YF_EOD]+"_") {
+		return YFDataTables[YF_EOD]
+	}
+	return table
+}
+
 type DataExporters struct {
 	exporters []IDataExporter
 }
@@ -86,9 +109,9 @@ type DataExporters struct {
 func (e *DataExporters) AddExporter(exp IDataExporter) {
 	e.exporters = append(e.exporters, exp)
 }
-func (e *DataExporters) Export(entityType reflect.Type, table string, data string, symbol string) error {
+func (e *DataExporters) Export(ctx context.Context, entityType reflect.Type, table string, data string, symbol string) error {
 	for _, exporter := range e.exporters {
-		if err := exporter.Export(entityType, table, data, symbol); err != nil {
+		if err := exporter.Export(ctx, entityType, table, data, symbol); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// A page read by the reader
+type RecordedPage struct {
+	URL    string
+	Params map[string]string
+	Body   string
+}
+
+// PageRecorder keeps the pages read since the last reset, so that the pages
+// of a symbol failing in a parser can be saved to reproduce the failure.
+type PageRecorder struct {
+	reader IHttpReader
+	mu     sync.Mutex
+	pages  []RecordedPage
+}
+
+func NewPageRecorder(reader IHttpReader) *PageRecorder {
+	return &PageRecorder{reader: reader}
+}
+
+func (r *PageRecorder) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	body, err := r.reader.Read(ctx, url, params)
+	if err == nil {
+		r.mu.Lock()
+		r.pages = append(r.pages, RecordedPage{url, params, body})
+		r.mu.Unlock()
+	}
+	return body, err
+}
+
+func (r *PageRecorder) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	return r.reader.RedirectedUrl(ctx, url)
+}
+
+// Forget the pages read so far
+func (r *PageRecorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pages = nil
+}
+
+func (r *PageRecorder) Pages() []RecordedPage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedPage{}, r.pages...)
+}
+
+var reUnsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Save the pages read and the panic of the symbol in a new directory under
+// dir, e.g. diagnostics/msft_ratios-20240730T125609Z. The page files are
+// numbered in the order read, and panic.txt has the stack trace and the URLs
+// of the pages. Returns the directory created.
+func (r *PageRecorder) Save(dir string, symbol string, panicErr PanicError) (string, error) {
+	name := reUnsafeFileChars.ReplaceAllString(symbol, "_") + "-" + time.Now().UTC().Format("20060102T150405.000Z")
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", errors.New("Failed to create diagnostics directory " + path + ". Error: " + err.Error())
+	}
+
+	text := fmt.Sprintf("symbol: %s\n%s\n\n%s\n", symbol, panicErr.Error(), panicErr.Stack())
+	for i, page := range r.Pages() {
+		file := fmt.Sprintf("page-%d.html", i+1)
+		if err := os.WriteFile(filepath.Join(path, file), []byte(page.Body), 0644); err != nil {
+			return "", errors.New("Failed to save page " + page.URL + ". Error: " + err.Error())
+		}
+		text += fmt.Sprintf("%s %s %v\n", file, page.URL, page.Params)
+	}
+	if err := os.WriteFile(filepath.Join(path, "panic.txt"), []byte(text), 0644); err != nil {
+		return "", errors.New("Failed to save the panic in " + path + ". Error: " + err.Error())
+	}
+	return path, nil
+}
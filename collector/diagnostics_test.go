@@ -0,0 +1,61 @@
+package collector_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/wayming/sdc/collector"
+)
+
+type pageReader map[string]string
+
+func (r pageReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	if body, ok := r[url]; ok {
+		return body, nil
+	}
+	return "", errors.New("not found")
+}
+
+func (r pageReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	return url, nil
+}
+
+func TestPageRecorder_Save(t *testing.T) {
+	r := NewPageRecorder(pageReader{
+		"https://sa/stocks/fb":        "<html>fb</html>",
+		"https://sa/stocks/msft":      "<html>msft</html>",
+		"https://sa/stocks/msft/rate": "<html>ratings</html>",
+	})
+
+	ctx := context.Background()
+	r.Read(ctx, "https://sa/stocks/fb", nil)
+	r.Reset()
+	r.Read(ctx, "https://sa/stocks/msft", nil)
+	r.Read(ctx, "https://sa/stocks/missing", nil)
+	r.Read(ctx, "https://sa/stocks/msft/rate", map[string]string{"p": "quarterly"})
+	if len(r.Pages()) != 2 {
+		t.Fatalf("Expecting 2 pages read since reset, got %d", len(r.Pages()))
+	}
+
+	path, err := r.Save(t.TempDir(), WorkUnit("msft", DATASET_RATINGS), NewPanicError("index out of range", []byte("goroutine 1")))
+	if err != nil {
+		t.Fatalf("PageRecorder.Save() error = %v", err)
+	}
+	if !strings.HasPrefix(filepath.Base(path), "msft_ratings-") {
+		t.Errorf("Unexpected diagnostics directory %s", path)
+	}
+	page, _ := os.ReadFile(filepath.Join(path, "page-2.html"))
+	if string(page) != "<html>ratings</html>" {
+		t.Errorf("Expecting the second page saved, got %s", page)
+	}
+	text, _ := os.ReadFile(filepath.Join(path, "panic.txt"))
+	for _, want := range []string{"panic: index out of range", "goroutine 1", "page-1.html https://sa/stocks/msft"} {
+		if !strings.Contains(string(text), want) {
+			t.Errorf("Expecting %q in panic.txt, got %s", want, text)
+		}
+	}
+}
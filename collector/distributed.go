@@ -0,0 +1,423 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+// A distributed run is coordinated through the cache. The coordinator seeds
+// the symbol queue and registers the run. Workers join by run ID, lease
+// symbols from the shared queue, refresh their heartbeat in the workers hash
+// of the run and push the outcome of each symbol to the results list, which
+// the coordinator drains into the run report.
+const CACHE_KEY_RUNS = "RUNS" // Set of the IDs of the active runs
+const CACHE_KEY_RUN_PREFIX = "RUN:"
+const RUN_SUFFIX_RESULTS = ":RESULTS"
+const RUN_SUFFIX_WORKERS = ":WORKERS"
+
+// Fields of the run hash
+const (
+	RUN_FIELD_KIND       = "kind"
+	RUN_FIELD_STATUS     = "status"
+	RUN_FIELD_STARTED_AT = "started_at"
+	RUN_FIELD_FINANCIALS = "financials" // JSON of the financials configuration
+	RUN_FIELD_EOD        = "eod"        // JSON of the EOD configuration
+)
+
+// Status of a distributed run
+const (
+	RUN_STATUS_RUNNING   = "running"
+	RUN_STATUS_DONE      = "done"
+	RUN_STATUS_CANCELLED = "cancelled"
+)
+
+// Kind of the symbols collected by a run, deciding the workers to build
+const (
+	RUN_KIND_EOD        = "eod"
+	RUN_KIND_FINANCIALS = "financials"
+)
+
+// Interval of the coordinator checking the results and the workers
+const RUN_MONITOR_INTERVAL = time.Second
+
+// Outcome of a symbol, or of a worker for an empty symbol, reported by a
+// worker of a distributed run
+type RunResult struct {
+	Response PCResponse `json:"response"`
+	Status   string     `json:"status"`
+	Attempts int64      `json:"attempts"`
+}
+
+func runKey(runID string) string {
+	return CACHE_KEY_RUN_PREFIX + runID
+}
+
+func runResultsKey(runID string) string {
+	return runKey(runID) + RUN_SUFFIX_RESULTS
+}
+
+func runWorkersKey(runID string) string {
+	return runKey(runID) + RUN_SUFFIX_WORKERS
+}
+
+// Host name and process ID, unique among the workers of a run
+func processID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// Push the outcomes to the results list of the run. The worker IDs are
+// prefixed with the process ID to tell the workers of the hosts apart. The
+// symbols started are shown by the progress of the worker process only.
+func newResultPublisher(cm cache.ICacheManager, runID string, process string) recordFunc {
+	return func(resp PCResponse, status string, attempts int64) {
+		if status == SYMBOL_STATUS_STARTED {
+			return
+		}
+		if len(resp.WorkerID) > 0 {
+			resp.WorkerID = process + "/" + resp.WorkerID
+		}
+		text, err := json.Marshal(RunResult{resp, status, attempts})
+		if err != nil {
+			sdclogger.SDCLoggerInstance.Printf("Failed to marshal the result of symbol %s. Error: %s", resp.Symbol, err.Error())
+			return
+		}
+		if err := cm.PushToList(runResultsKey(runID), string(text)); err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
+		}
+	}
+}
+
+// Record the outcomes in both recorders
+func teeRecorder(a recordFunc, b recordFunc) recordFunc {
+	return func(resp PCResponse, status string, attempts int64) {
+		a(resp, status, attempts)
+		b(resp, status, attempts)
+	}
+}
+
+// Register the run, process the symbols with the local workers if any, and
+// collect the results of all workers into the report until the queue is
+// drained and the workers are gone. On cancellation the workers are told to
+// stop through the run status, and their results are collected until they
+// leave or the lease TTL passes.
+//
+// Symbols left in the queue by the workers gone, e.g. crashed, are processed
+// by the local workers again. Without local workers the run fails once no
+// worker joined for the lease TTL.
+func (pc *ParallelCollector) coordinate(ctx context.Context, parallel int, policy *RetryPolicy, report *RunReport, progress *Progress) error {
+	runID := report.RunID
+	leaseTTL := pc.leaseTTL()
+	if err := pc.setRunStatus(runID, RUN_STATUS_RUNNING); err != nil {
+		return err
+	}
+	if err := pc.Cache.SetHashField(runKey(runID), RUN_FIELD_KIND, pc.Params.Kind); err != nil {
+		return err
+	}
+	if err := pc.Cache.SetHashField(runKey(runID), RUN_FIELD_STARTED_AT, report.StartTime.Format(time.RFC3339)); err != nil {
+		return err
+	}
+	for field, value := range map[string]any{RUN_FIELD_FINANCIALS: pc.Config.Financials, RUN_FIELD_EOD: pc.Config.EOD} {
+		text, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to marshal the %s configuration of run %s: %v", field, runID, err)
+		}
+		if err := pc.Cache.SetHashField(runKey(runID), field, string(text)); err != nil {
+			return err
+		}
+	}
+	if err := pc.Cache.AddToSet(CACHE_KEY_RUNS, runID); err != nil {
+		return err
+	}
+	progress.Printf("Run %s started. Join with: sdc worker -run %s\n", runID, runID)
+
+	// Local workers report through the cache like the remote ones. They run
+	// one at a time, so the first error is kept without locking.
+	var localWG sync.WaitGroup
+	var localErr error
+	startLocal := func() chan struct{} {
+		localDone := make(chan struct{})
+		localWG.Add(1)
+		go func() {
+			defer localWG.Done()
+			defer close(localDone)
+			if parallel > 0 {
+				if err := pc.process(ctx, parallel, policy, newResultPublisher(pc.Cache, runID, processID())); err != nil && localErr == nil {
+					localErr = err
+				}
+			}
+		}()
+		return localDone
+	}
+
+	record := newReportRecorder(report, progress)
+	ticker := time.NewTicker(RUN_MONITOR_INTERVAL)
+	defer ticker.Stop()
+	cancelled, localWait := ctx.Done(), startLocal()
+	var cancelledAt, orphanedAt time.Time
+	var runErr error
+	status := RUN_STATUS_DONE
+	workers := -1
+	joined := false
+	for {
+		pc.drainResults(runID, record)
+
+		active := pc.activeWorkers(runID, leaseTTL)
+		if len(active) != workers {
+			workers = len(active)
+			progress.Printf("Workers: %d active %v\n", workers, active)
+		}
+		if len(active) > 0 {
+			joined = true
+			orphanedAt = time.Time{}
+		}
+
+		if cancelledAt.IsZero() && ctx.Err() != nil {
+			cancelledAt = time.Now()
+			status = RUN_STATUS_CANCELLED
+			if err := pc.setRunStatus(runID, RUN_STATUS_CANCELLED); err != nil {
+				sdclogger.SDCLoggerInstance.Println(err.Error())
+			}
+		}
+		if localWait == nil {
+			if !cancelledAt.IsZero() {
+				if len(active) == 0 || time.Since(cancelledAt) > leaseTTL {
+					break
+				}
+			} else if len(active) == 0 && pc.queueDrained() {
+				break
+			} else if len(active) == 0 && parallel > 0 {
+				progress.Printf("Process the symbols left by the workers gone\n")
+				localWait = startLocal()
+			} else if len(active) == 0 && joined {
+				if orphanedAt.IsZero() {
+					orphanedAt = time.Now()
+				} else if time.Since(orphanedAt) > leaseTTL {
+					left, _ := pc.Cache.QueueLength(CACHE_KEY_SYMBOL)
+					runErr = fmt.Errorf("run %s stalled: %d symbols left by the workers gone, and no worker joined for %s", runID, left, leaseTTL)
+					status = RUN_STATUS_CANCELLED
+					break
+				}
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-cancelled:
+			cancelled = nil
+		case <-localWait:
+			localWait = nil
+		}
+	}
+	localWG.Wait()
+
+	if err := pc.setRunStatus(runID, status); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+	pc.drainResults(runID, record)
+	if err := pc.Cache.DeleteFromSet(CACHE_KEY_RUNS, runID); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+	if err := pc.Cache.DeleteSet(runResultsKey(runID)); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+	if err := pc.Cache.DeleteSet(runWorkersKey(runID)); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+	if runErr != nil {
+		return runErr
+	}
+	return localErr
+}
+
+// Whether all the symbols are acknowledged. The leases expired are reaped
+// back to the queue first.
+func (pc *ParallelCollector) queueDrained() bool {
+	if _, err := pc.Cache.ReapExpired(CACHE_KEY_SYMBOL); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+	left, err := pc.Cache.QueueLength(CACHE_KEY_SYMBOL)
+	if err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+		return false
+	}
+	return left == 0
+}
+
+func (pc *ParallelCollector) setRunStatus(runID string, status string) error {
+	return pc.Cache.SetHashField(runKey(runID), RUN_FIELD_STATUS, status)
+}
+
+// Record the results pushed by the workers so far
+func (pc *ParallelCollector) drainResults(runID string, record recordFunc) {
+	for {
+		text, err := pc.Cache.PopFromList(runResultsKey(runID))
+		if err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
+			return
+		}
+		if len(text) == 0 {
+			return
+		}
+		var result RunResult
+		if err := json.Unmarshal([]byte(text), &result); err != nil {
+			sdclogger.SDCLoggerInstance.Printf("Ignore the malformed result %s. Error: %s", text, err.Error())
+			continue
+		}
+		record(result.Response, result.Status, result.Attempts)
+	}
+}
+
+// Workers with a heartbeat within the TTL. Workers of crashed processes
+// expire, and the symbols they leased are reaped back to the queue.
+func (pc *ParallelCollector) activeWorkers(runID string, ttl time.Duration) []string {
+	heartbeats, err := pc.Cache.GetAllFromHash(runWorkersKey(runID))
+	if err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+		return nil
+	}
+	active := []string{}
+	for _, worker := range common.Keys(heartbeats) {
+		millis, err := strconv.ParseInt(heartbeats[worker], 10, 64)
+		if err != nil {
+			continue
+		}
+		if time.Since(time.UnixMilli(millis)) <= ttl {
+			active = append(active, worker)
+		}
+	}
+	return active
+}
+
+// Refresh the heartbeat of the worker until done is closed. The worker is
+// cancelled once the run is not running any more.
+func (pc *ParallelCollector) keepWorkerAlive(done chan struct{}, cancel context.CancelFunc, runID string, worker string, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		if err := pc.Cache.SetHashField(runWorkersKey(runID), worker, strconv.FormatInt(time.Now().UnixMilli(), 10)); err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
+		}
+		if run, err := pc.Cache.GetAllFromHash(runKey(runID)); err == nil && run[RUN_FIELD_STATUS] != RUN_STATUS_RUNNING {
+			sdclogger.SDCLoggerInstance.Printf("Run %s is %s. Stop the worker.", runID, run[RUN_FIELD_STATUS])
+			cancel()
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Join the distributed run with the given number of workers, processing the
+// symbols of the run until its queue is drained, the run stops or the
+// context is cancelled. The results go to the coordinator of the run, and
+// the returned report covers the symbols processed by this process only.
+func JoinRun(ctx context.Context, cfg *config.Config, runID string, parallel int, p PCParams) (*RunReport, error) {
+	cm := cache.NewCacheManager(cfg)
+	if err := cm.Connect(); err != nil {
+		return nil, err
+	}
+	run, err := cm.GetAllFromHash(runKey(runID))
+	cm.Disconnect()
+	if err != nil {
+		return nil, err
+	}
+	if len(run) == 0 {
+		return nil, fmt.Errorf("run %s not found", runID)
+	}
+	if run[RUN_FIELD_STATUS] != RUN_STATUS_RUNNING {
+		return nil, fmt.Errorf("run %s is %s", runID, run[RUN_FIELD_STATUS])
+	}
+	// Workers collect the data as configured by the coordinator, e.g. the
+	// datasets selected and the freshness policy
+	runCfg := *cfg
+	runCfg.Financials = config.FinancialsConfig{}
+	runCfg.EOD = config.EODConfig{}
+	for field, value := range map[string]any{RUN_FIELD_FINANCIALS: &runCfg.Financials, RUN_FIELD_EOD: &runCfg.EOD} {
+		text, ok := run[field]
+		if !ok {
+			return nil, fmt.Errorf("%s configuration of run %s not found", field, runID)
+		}
+		if err := json.Unmarshal([]byte(text), value); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the %s configuration of run %s: %v", field, runID, err)
+		}
+	}
+	cfg = &runCfg
+	pc, err := NewParallelCollectorByKind(run[RUN_FIELD_KIND], cfg, p)
+	if err != nil {
+		return nil, err
+	}
+	return pc.Join(ctx, runID, parallel)
+}
+
+// Join the run as a worker of the collector. The kind of the run is not
+// checked, see JoinRun.
+func (pc *ParallelCollector) Join(ctx context.Context, runID string, parallel int) (*RunReport, error) {
+	if err := pc.Cache.Connect(); err != nil {
+		return nil, err
+	}
+	defer pc.Cache.Disconnect()
+	policy, err := pc.retryPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	worker := processID()
+	report := NewRunReport()
+	report.RunID = runID
+	out := pc.output()
+	fmt.Fprintf(out, "Worker %s joined run %s\n", worker, runID)
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	var keeperWG sync.WaitGroup
+	keeperWG.Add(1)
+	go func() {
+		defer keeperWG.Done()
+		pc.keepWorkerAlive(done, cancel, runID, worker, pc.leaseTTL())
+	}()
+
+	progress := NewProgress(out, 0)
+	progress.Start()
+	record := teeRecorder(newReportRecorder(report, progress), newResultPublisher(pc.Cache, runID, worker))
+	err = pc.process(runCtx, parallel, policy, record)
+	progress.Stop()
+	close(done)
+	keeperWG.Wait()
+	if derr := pc.Cache.DeleteFromHash(runWorkersKey(runID), worker); derr != nil {
+		sdclogger.SDCLoggerInstance.Println(derr.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Finish(runCtx.Err() != nil)
+	fmt.Fprintf(out, "Worker %s left run %s. Processed %d, succeeded %d\n", worker, runID, report.Totals.Processed, report.Totals.Succeeded)
+	return report, nil
+}
+
+// Parallel collector of the given run kind
+func NewParallelCollectorByKind(kind string, cfg *config.Config, p PCParams) (ParallelCollector, error) {
+	switch kind {
+	case RUN_KIND_EOD:
+		return NewEODParallelCollector(cfg, p), nil
+	case RUN_KIND_FINANCIALS:
+		return NewFinancialParallelCollector(cfg, p), nil
+	}
+	return ParallelCollector{}, fmt.Errorf("unknown run kind %s, expecting %s or %s", kind, RUN_KIND_EOD, RUN_KIND_FINANCIALS)
+}
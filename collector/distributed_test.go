@@ -0,0 +1,329 @@
+package collector_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/wayming/sdc/collector"
+	testcommon "github.com/wayming/sdc/testcommon"
+)
+
+func TestParallelCollector_Execute_Distributed(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// Parallel Collector Begin
+	fixture.CacheExpect().QueueLength(CACHE_KEY_SYMBOL).
+		Return(int64(2), nil).Times(1)
+
+	// Run registration
+	fixture.CacheExpect().
+		SetHashField(testcommon.NewStringPatternMatcher("^RUN:[^:]+$"), gomock.Any(), gomock.Any()).
+		AnyTimes()
+	fixture.CacheExpect().AddToSet(CACHE_KEY_RUNS, gomock.Any()).Times(1)
+
+	// A remote worker reports one symbol succeeded and one failed, then leaves
+	results := []RunResult{
+		{Response: PCResponse{Symbol: "msft", ErrorID: SUCCESS, WorkerID: "host1-100/0"}, Status: SYMBOL_STATUS_SUCCEEDED},
+		{Response: PCResponse{Symbol: "aapl", ErrorID: WORKER_PROCESS_FAILURE, ErrorText: "connection reset", WorkerID: "host1-100/1"}, Status: SYMBOL_STATUS_FAILED, Attempts: 1},
+	}
+	var calls []*gomock.Call
+	for _, result := range results {
+		text, _ := json.Marshal(result)
+		calls = append(calls, fixture.CacheExpect().
+			PopFromList(testcommon.NewStringPatternMatcher("^RUN:.*:RESULTS$")).
+			Return(string(text), nil))
+	}
+	gomock.InOrder(calls...)
+	fixture.CacheExpect().
+		PopFromList(testcommon.NewStringPatternMatcher("^RUN:.*:RESULTS$")).
+		Return("", nil).
+		AnyTimes()
+	gomock.InOrder(
+		fixture.CacheExpect().
+			GetAllFromHash(testcommon.NewStringPatternMatcher("^RUN:.*:WORKERS$")).
+			Return(map[string]string{"host1-100": strconv.FormatInt(time.Now().UnixMilli(), 10)}, nil),
+		fixture.CacheExpect().
+			GetAllFromHash(testcommon.NewStringPatternMatcher("^RUN:.*:WORKERS$")).
+			Return(map[string]string{}, nil).
+			AnyTimes(),
+	)
+
+	// Parallel Collector End
+	fixture.CacheExpect().QueueLength(CACHE_KEY_SYMBOL).
+		Return(int64(0), nil).AnyTimes()
+	fixture.CacheExpect().DeleteFromSet(CACHE_KEY_RUNS, gomock.Any()).Times(1)
+	fixture.CacheExpect().DeleteSet(testcommon.NewStringPatternMatcher("^RUN:")).Times(2)
+	fixture.CacheExpect().GetLength(CACHE_KEY_SYMBOL_ERROR).Return(int64(1), nil)
+	fixture.CacheExpect().GetAllFromSet(CACHE_KEY_SYMBOL_ERROR).Return([]string{"aapl"}, nil)
+	fixture.CacheExpect().GetLength(CACHE_KEY_SYMBOL_INVALID).Return(int64(0), nil)
+	fixture.CacheExpect().GetLength(CACHE_KEY_SYMBOL_DEAD).Return(int64(0), nil)
+
+	var output strings.Builder
+	pc := ParallelCollector{
+		func() IWorkerBuilder {
+			return &fakeWorkerBuilder{do: func(ctx context.Context, symbol string) error {
+				t.Errorf("Unexpected local processing of symbol %s", symbol)
+				return nil
+			}}
+		},
+		fixture.CacheMock(),
+		PCParams{Output: &output, Kind: RUN_KIND_FINANCIALS, Distributed: true},
+		fixture.Config(),
+		nil,
+	}
+
+	// No local workers. All the symbols are processed by the remote worker.
+	report, err := pc.Execute(context.Background(), 0)
+	if _, ok := err.(RunIncompleteError); !ok {
+		t.Errorf("ParallelCollector.Execute() expecting RunIncompleteError, got %v", err)
+	}
+	want := RunTotals{Symbols: 2, Units: 2, Processed: 2, Succeeded: 1, Failed: 1}
+	if report.Totals != want {
+		t.Errorf("Report totals = %+v, want %+v", report.Totals, want)
+	}
+	if len(report.Workers) != 2 || report.Workers[0].Worker != "host1-100/0" {
+		t.Errorf("Expecting the remote workers in the report, got %+v", report.Workers)
+	}
+	if !strings.Contains(output.String(), "sdc worker -run "+report.RunID) {
+		t.Errorf("Expecting the command to join the run, got %s", output.String())
+	}
+}
+
+func TestParallelCollector_Join(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	runID := "20240730T125609Z-1a2b3c4d"
+	fixture.CacheExpect().GetLength(CACHE_KEY_PROXY).Return(int64(0), nil).AnyTimes()
+
+	// Heartbeat while the run is running
+	fixture.CacheExpect().SetHashField("RUN:"+runID+":WORKERS", gomock.Any(), gomock.Any()).MinTimes(1)
+	fixture.CacheExpect().
+		GetAllFromHash("RUN:"+runID).
+		Return(map[string]string{"kind": RUN_KIND_EOD, "status": RUN_STATUS_RUNNING}, nil).
+		MinTimes(1)
+
+	fixture.CacheExpect().
+		Lease(gomock.Any(), CACHE_KEY_SYMBOL, gomock.Any()).
+		Return("msft", nil).
+		Times(1)
+	fixture.CacheExpect().
+		Lease(gomock.Any(), CACHE_KEY_SYMBOL, gomock.Any()).
+		Return("fb", nil).
+		Times(1)
+	fixture.CacheExpect().
+		Lease(gomock.Any(), CACHE_KEY_SYMBOL, gomock.Any()).
+		Return("", nil).
+		AnyTimes() // No symbol left
+	fixture.CacheExpect().IncrHashField(CACHE_KEY_SYMBOL_ATTEMPTS, "fb").Return(int64(1), nil)
+	fixture.CacheExpect().SetHashField(CACHE_KEY_SYMBOL_LAST_ERROR, "fb", "parse error")
+	fixture.CacheExpect().AddToSet(CACHE_KEY_SYMBOL_ERROR, "fb")
+
+	// Results are reported to the coordinator
+	published := make(map[string]RunResult)
+	fixture.CacheExpect().
+		PushToList("RUN:"+runID+":RESULTS", gomock.Any()).
+		DoAndReturn(func(key string, text string) error {
+			var result RunResult
+			if err := json.Unmarshal([]byte(text), &result); err != nil {
+				t.Errorf("Failed to unmarshal result %s. Error: %v", text, err)
+			}
+			published[result.Response.Symbol] = result
+			return nil
+		}).
+		Times(2)
+
+	cfg := *fixture.Config()
+	cfg.Retry.RetryOn = []string{}
+	var output strings.Builder
+	pc := ParallelCollector{
+		func() IWorkerBuilder {
+			return &fakeWorkerBuilder{do: func(ctx context.Context, symbol string) error {
+				if symbol == "fb" {
+					return errors.New("parse error")
+				}
+				return nil
+			}}
+		},
+		fixture.CacheMock(),
+		PCParams{Output: &output, Kind: RUN_KIND_EOD},
+		&cfg,
+		nil,
+	}
+
+	report, err := pc.Join(context.Background(), runID, 1)
+	if err != nil {
+		t.Fatalf("ParallelCollector.Join() error = %v", err)
+	}
+	want := RunTotals{Processed: 2, Succeeded: 1, Failed: 1}
+	if report.RunID != runID || report.Totals != want {
+		t.Errorf("Report of run %s totals = %+v, want run %s totals %+v", report.RunID, report.Totals, runID, want)
+	}
+	if r := published["msft"]; r.Status != SYMBOL_STATUS_SUCCEEDED || !strings.HasSuffix(r.Response.WorkerID, "/0") {
+		t.Errorf("Unexpected result published for msft: %+v", r)
+	}
+	if r := published["fb"]; r.Status != SYMBOL_STATUS_FAILED || r.Attempts != 1 || r.Response.ErrorText != "parse error" {
+		t.Errorf("Unexpected result published for fb: %+v", r)
+	}
+}
+
+// Results list of a run shared by the local workers and the coordinator
+type resultList struct {
+	mu      sync.Mutex
+	results []string
+}
+
+func (l *resultList) push(key string, text string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.results = append(l.results, text)
+	return nil
+}
+
+func (l *resultList) pop(key string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.results) == 0 {
+		return "", nil
+	}
+	text := l.results[0]
+	l.results = l.results[1:]
+	return text, nil
+}
+
+// Expectations of a distributed run of one symbol, left in the queue by a
+// remote worker gone after its first heartbeat
+func expectWorkerGone(fixture *testcommon.MockTestFixture, left func() int64) {
+	fixture.CacheExpect().QueueLength(CACHE_KEY_SYMBOL).
+		Return(int64(1), nil).Times(1)
+	fixture.CacheExpect().QueueLength(CACHE_KEY_SYMBOL).
+		DoAndReturn(func(queue string) (int64, error) { return left(), nil }).
+		AnyTimes()
+	fixture.CacheExpect().
+		SetHashField(testcommon.NewStringPatternMatcher("^RUN:[^:]+$"), gomock.Any(), gomock.Any()).
+		AnyTimes()
+	fixture.CacheExpect().AddToSet(CACHE_KEY_RUNS, gomock.Any()).Times(1)
+	gomock.InOrder(
+		fixture.CacheExpect().
+			GetAllFromHash(testcommon.NewStringPatternMatcher("^RUN:.*:WORKERS$")).
+			Return(map[string]string{"host1-100": strconv.FormatInt(time.Now().UnixMilli(), 10)}, nil),
+		fixture.CacheExpect().
+			GetAllFromHash(testcommon.NewStringPatternMatcher("^RUN:.*:WORKERS$")).
+			Return(map[string]string{}, nil).
+			AnyTimes(),
+	)
+	fixture.CacheExpect().DeleteFromSet(CACHE_KEY_RUNS, gomock.Any()).Times(1)
+	fixture.CacheExpect().DeleteSet(testcommon.NewStringPatternMatcher("^RUN:")).Times(2)
+}
+
+func TestParallelCollector_Execute_Distributed_WorkerGone(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	list := &resultList{}
+	var processed atomic.Bool
+	expectWorkerGone(fixture, func() int64 {
+		if processed.Load() {
+			return 0
+		}
+		return 1
+	})
+	fixture.CacheExpect().PushToList(testcommon.NewStringPatternMatcher("^RUN:.*:RESULTS$"), gomock.Any()).DoAndReturn(list.push).AnyTimes()
+	fixture.CacheExpect().PopFromList(testcommon.NewStringPatternMatcher("^RUN:.*:RESULTS$")).DoAndReturn(list.pop).AnyTimes()
+	fixture.CacheExpect().GetLength(CACHE_KEY_PROXY).Return(int64(0), nil).AnyTimes()
+
+	// The symbol is leased by the remote worker while the local workers run
+	// first, and reaped back to the queue once the remote worker is gone
+	var leases atomic.Int32
+	fixture.CacheExpect().
+		Lease(gomock.Any(), CACHE_KEY_SYMBOL, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, key string, ttl time.Duration) (string, error) {
+			if leases.Add(1) == 2 {
+				return "aapl", nil
+			}
+			return "", nil
+		}).
+		AnyTimes()
+	fixture.CacheExpect().GetLength(CACHE_KEY_SYMBOL_ERROR).Return(int64(0), nil)
+	fixture.CacheExpect().GetLength(CACHE_KEY_SYMBOL_INVALID).Return(int64(0), nil)
+	fixture.CacheExpect().GetLength(CACHE_KEY_SYMBOL_DEAD).Return(int64(0), nil)
+
+	var output strings.Builder
+	pc := ParallelCollector{
+		func() IWorkerBuilder {
+			return &fakeWorkerBuilder{do: func(ctx context.Context, symbol string) error {
+				processed.Store(true)
+				return nil
+			}}
+		},
+		fixture.CacheMock(),
+		PCParams{Output: &output, Kind: RUN_KIND_FINANCIALS, Distributed: true},
+		fixture.Config(),
+		nil,
+	}
+
+	report, err := executeWithin(t, &pc, 1, 10*time.Second)
+	if err != nil {
+		t.Fatalf("ParallelCollector.Execute() error = %v", err)
+	}
+	if report.Totals.Succeeded != 1 || len(report.Symbols) != 1 || report.Symbols[0].Symbol != "aapl" {
+		t.Errorf("Expecting the symbol left by the worker gone processed locally, got %+v", report.Totals)
+	}
+	if !strings.Contains(output.String(), "Process the symbols left by the workers gone") {
+		t.Errorf("Expecting the symbols left processed again, got %s", output.String())
+	}
+}
+
+func TestParallelCollector_Execute_Distributed_Stalled(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	expectWorkerGone(fixture, func() int64 { return 1 })
+	fixture.CacheExpect().PopFromList(testcommon.NewStringPatternMatcher("^RUN:.*:RESULTS$")).Return("", nil).AnyTimes()
+
+	cfg := *fixture.Config()
+	cfg.Queue.LeaseTTL = time.Second
+	pc := ParallelCollector{
+		func() IWorkerBuilder { return &fakeWorkerBuilder{} },
+		fixture.CacheMock(),
+		PCParams{Output: io.Discard, Kind: RUN_KIND_FINANCIALS, Distributed: true},
+		&cfg,
+		nil,
+	}
+
+	// No local workers to process the symbol left
+	if _, err := executeWithin(t, &pc, 0, 10*time.Second); err == nil || !strings.Contains(err.Error(), "stalled") {
+		t.Errorf("ParallelCollector.Execute() expecting the run stalled, got %v", err)
+	}
+}
+
+// Execute the collector, failing the test if the run is still going after
+// the timeout
+func executeWithin(t *testing.T, pc *ParallelCollector, parallel int, timeout time.Duration) (*RunReport, error) {
+	type outcome struct {
+		report *RunReport
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		report, err := pc.Execute(context.Background(), parallel)
+		done <- outcome{report, err}
+	}()
+	select {
+	case o := <-done:
+		return o.report, o.err
+	case <-time.After(timeout):
+		t.Fatalf("ParallelCollector.Execute() still running after %s", timeout)
+		return nil, nil
+	}
+}
@@ -1,5 +1,7 @@
 package collector
 
+import "fmt"
+
 const WGET_ERROR_CODE_NETWORK = int(4)
 const WGET_ERROR_CODE_SERVER_ERROR = int(8)
 
@@ -69,3 +71,68 @@ func (e HttpServerError) Error() string {
 // 		return errors.New(fullMessage)
 // 	}
 // }
+
+// RunIncompleteError is returned by ParallelCollector.Execute when some symbols
+// failed, were parked in the dead-letter set or were pushed back to the cache.
+type RunIncompleteError struct {
+	Report *RunReport
+}
+
+// NewRunIncompleteError creates a new RunIncompleteError instance for the given report.
+func NewRunIncompleteError(report *RunReport) RunIncompleteError {
+	return RunIncompleteError{Report: report}
+}
+
+// Error returns the totals of the incomplete run.
+func (e RunIncompleteError) Error() string {
+	t := e.Report.Totals
+	text := fmt.Sprintf("run %s incomplete: %d of %d units processed, %d failed, %d dead, %d pushed back to the cache",
+		e.Report.RunID, t.Processed, t.Units, t.Failed, t.Dead, t.Requeued)
+	if e.Report.Cancelled {
+		text += ", cancelled"
+	}
+	return text
+}
+
+// PanicError is returned for a symbol whose processing panicked.
+type PanicError struct {
+	value any
+	stack string
+}
+
+// NewPanicError creates a new PanicError instance with the recovered value and the stack of the panic.
+func NewPanicError(value any, stack []byte) PanicError {
+	return PanicError{value: value, stack: string(stack)}
+}
+
+// Error returns the recovered value of the panic.
+func (e PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// Stack returns the stack trace of the goroutine at the panic.
+func (e PanicError) Stack() string {
+	return e.stack
+}
+
+// TransportError is returned for a request that failed on the way to the
+// server, e.g. on dial, proxy CONNECT, TLS handshake or timeout. Through a
+// proxy, the proxy is to blame rather than the symbol.
+type TransportError struct {
+	err error
+}
+
+// NewTransportError creates a new TransportError instance wrapping the error of the request.
+func NewTransportError(err error) TransportError {
+	return TransportError{err: err}
+}
+
+// Error returns the message of the failed request.
+func (e TransportError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error of the failed request.
+func (e TransportError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,170 @@
+package collector
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/wayming/sdc/config"
+)
+
+// Keep-alive probes of the connections to the upstream sources
+const HTTP_TCP_KEEP_ALIVE = 30 * time.Second
+
+// Profile of the HTTP clients of a run. Each client created from the profile
+// has its own cookie jar and connection pool, and the next user agent of the
+// profile, so that a worker keeps one identity across its requests.
+type ClientProfile struct {
+	cfg       config.HTTPClientConfig
+	nextAgent atomic.Uint64
+}
+
+func NewClientProfile(cfg config.HTTPClientConfig) *ClientProfile {
+	return &ClientProfile{cfg: cfg}
+}
+
+// Profile of the configuration, or of the defaults if the configuration is nil
+func NewClientProfileByConfig(cfg *config.Config) *ClientProfile {
+	if cfg == nil {
+		cfg = config.NewConfig()
+	}
+	return NewClientProfile(cfg.HTTPClient)
+}
+
+// Next user agent of the pool. Empty for Go's user agent.
+func (p *ClientProfile) userAgent() string {
+	if len(p.cfg.UserAgents) == 0 {
+		return ""
+	}
+	n := p.nextAgent.Add(1) - 1
+	return p.cfg.UserAgents[n%uint64(len(p.cfg.UserAgents))]
+}
+
+// Client of the profile sending the requests through the proxy function, or
+// directly if nil
+func (p *ClientProfile) newClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	dialer := &net.Dialer{Timeout: p.cfg.ConnectTimeout, KeepAlive: HTTP_TCP_KEEP_ALIVE}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   p.cfg.ConnectTimeout,
+		ResponseHeaderTimeout: p.cfg.ReadTimeout,
+		DisableKeepAlives:     p.cfg.DisableKeepAlives,
+		MaxIdleConns:          p.cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   p.cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       p.cfg.MaxConnsPerHost,
+		IdleConnTimeout:       p.cfg.IdleConnTimeout,
+		ForceAttemptHTTP2:     true,
+		// Content encodings are negotiated and decoded by profileTransport
+		DisableCompression: true,
+	}
+
+	client := &http.Client{
+		Transport: &profileTransport{
+			base:           transport,
+			userAgent:      p.userAgent(),
+			headers:        p.cfg.Headers,
+			acceptEncoding: strings.Join(p.cfg.Compression, ", "),
+		},
+		Timeout: p.cfg.Timeout,
+	}
+	if p.cfg.Cookies {
+		client.Jar, _ = cookiejar.New(nil) // Never fails without options
+	}
+	return client
+}
+
+// Transport adding the headers of the profile to the requests and decoding
+// the compressed responses
+type profileTransport struct {
+	base           http.RoundTripper
+	userAgent      string
+	headers        map[string]string
+	acceptEncoding string
+}
+
+func (t *profileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests must not be modified by round trippers
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		if len(req.Header.Get(name)) == 0 {
+			req.Header.Set(name, value)
+		}
+	}
+	if len(t.userAgent) > 0 && len(req.Header.Get("User-Agent")) == 0 {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if len(t.acceptEncoding) > 0 {
+		req.Header.Set("Accept-Encoding", t.acceptEncoding)
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return res, nil
+	case "gzip", "deflate", "br":
+		res.Body = &decodedBody{body: res.Body, encoding: encoding}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+		return res, nil
+	}
+	res.Body.Close()
+	return nil, fmt.Errorf("unsupported content encoding %s of %s", encoding, req.URL.String())
+}
+
+// Body decoded on the first read, so that empty bodies are not decoded
+type decodedBody struct {
+	body     io.ReadCloser
+	encoding string
+	reader   io.ReadCloser
+	err      error
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		switch b.encoding {
+		case "gzip":
+			b.reader, b.err = gzip.NewReader(b.body)
+		case "br":
+			b.reader = io.NopCloser(brotli.NewReader(b.body))
+		default:
+			b.reader, b.err = zlib.NewReader(b.body)
+		}
+		if b.err != nil && b.err != io.EOF { // Empty bodies read as EOF
+			b.err = fmt.Errorf("failed to decode %s body: %v", b.encoding, b.err)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("failed to decode %s body: %v", b.encoding, err)
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	if b.reader != nil {
+		b.reader.Close()
+	}
+	return b.body.Close()
+}
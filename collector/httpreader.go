@@ -1,18 +1,24 @@
 package collector
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
-	"net/url"
-	"strings"
+	"strconv"
+	"time"
 
+	"github.com/wayming/sdc/proxy"
 	"github.com/wayming/sdc/sdclogger"
 )
 
 type IHttpReader interface {
-	Read(url string, params map[string]string) (string, error)
-	RedirectedUrl(url string) (string, error)
+	Read(ctx context.Context, url string, params map[string]string) (string, error)
+	RedirectedUrl(ctx context.Context, url string) (string, error)
 }
 
 type HttpReader struct {
@@ -24,12 +30,18 @@ func NewHttpReader(c *http.Client) *HttpReader {
 }
 
 // Get redirected url. Return empty string if the specified url is not redirected.
-func (r *HttpReader) RedirectedUrl(url string) (string, error) {
-	resp, err := r.client.Get(url)
-	sdclogger.SDCLoggerInstance.Logger.Printf("resp.Request: %v, resp.StatusCode: %v", resp.Request.URL.String(), resp.StatusCode)
+func (r *HttpReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to perform request for %s: %v", url, err)
+		return "", fmt.Errorf("failed to create request for %s: %v", url, err)
+	}
+
+	resp, err := r.do(req)
+	if err != nil {
+		return "", requestError(req, fmt.Errorf("failed to perform request for %s: %w", url, err))
 	} else {
+		defer resp.Body.Close()
+		sdclogger.SDCLoggerInstance.Logger.Printf("resp.Request: %v, resp.StatusCode: %v", resp.Request.URL.String(), resp.StatusCode)
 		if resp.StatusCode == http.StatusOK {
 			return resp.Request.URL.String(), nil
 		} else {
@@ -38,8 +50,8 @@ func (r *HttpReader) RedirectedUrl(url string) (string, error) {
 	}
 }
 
-func (r *HttpReader) Read(baseURL string, params map[string]string) (string, error) {
-	req, err := http.NewRequest("GET", baseURL, nil)
+func (r *HttpReader) Read(ctx context.Context, baseURL string, params map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request for %s: %v", baseURL, err)
 	}
@@ -50,10 +62,11 @@ func (r *HttpReader) Read(baseURL string, params map[string]string) (string, err
 	}
 	req.URL.RawQuery = q.Encode()
 
-	res, err := r.client.Do(req)
+	res, err := r.do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to perform request for %s: %v", req.URL.String(), err)
+		return "", requestError(req, fmt.Errorf("failed to perform request for %s: %w", req.URL.String(), err))
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		return "",
@@ -61,14 +74,53 @@ func (r *HttpReader) Read(baseURL string, params map[string]string) (string, err
 				res.StatusCode, res.Header,
 				fmt.Sprintf("Received non-succes status %s when requesting %s", res.Status, req.URL.String()))
 	}
-	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
-	if err == nil {
-		return string(body), nil
+	if err != nil {
+		return "", requestError(req, fmt.Errorf("failed to read response of %s: %w", req.URL.String(), err))
+	}
+	return string(body), nil
+}
+
+// Error of the request, as TransportError if the request failed on the way to
+// the server. Requests cancelled or timed out by their context are not.
+func requestError(req *http.Request, err error) error {
+	if req.Context().Err() != nil {
+		return err
+	}
+	var opError *net.OpError
+	var netError net.Error
+	var tlsError tls.RecordHeaderError
+	var alertError tls.AlertError
+	var certError *tls.CertificateVerificationError
+	var authorityError x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	switch {
+	case errors.As(err, &opError), // Dial, proxy CONNECT or SOCKS, connection reset
+		errors.As(err, &netError) && netError.Timeout(),
+		errors.As(err, &tlsError),
+		errors.As(err, &alertError),
+		errors.As(err, &certError),
+		errors.As(err, &authorityError),
+		errors.As(err, &hostnameError),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF): // Connection closed
+		return NewTransportError(err)
+	}
+	return err
+}
+
+// Send the request, recording it in the request metrics
+func (r *HttpReader) do(req *http.Request) (*http.Response, error) {
+	begin := time.Now()
+	res, err := r.client.Do(req)
+	host := req.URL.Hostname()
+	httpLatency.WithLabelValues(host).Observe(time.Since(begin).Seconds())
+	if err != nil {
+		httpRequests.WithLabelValues(host, "error").Inc()
 	} else {
-		return "", nil
+		httpRequests.WithLabelValues(host, strconv.Itoa(res.StatusCode)).Inc()
 	}
+	return res, err
 }
 
 type LocalClient struct {
@@ -79,24 +131,17 @@ type ProxyClient struct {
 	http.Client
 }
 
-func NewLocalClient() *http.Client {
-	return &http.Client{}
+// Client of the profile connecting to the upstream sources directly
+func NewLocalClient(profile *ClientProfile) *http.Client {
+	return profile.newClient(nil)
 }
 
-func NewProxyClient(proxyRecord string) (*http.Client, error) {
-	proxyParts := strings.Split(proxyRecord, ":")
-
-	// Define the proxy URL
-	proxyURL, err := url.Parse("http://" + proxyParts[2] + ":" + proxyParts[3] + "@" + proxyParts[0] + ":" + proxyParts[1])
+// Client of the profile connecting to the upstream sources through the proxy
+// of the record, in any of the forms parsed by proxy.Parse
+func NewProxyClient(proxyRecord string, profile *ClientProfile) (*http.Client, error) {
+	p, err := proxy.Parse(proxyRecord)
 	if err != nil {
-		sdclogger.SDCLoggerInstance.Printf("Failed to parse proxy URL: %v", err)
-		return nil, err
+		return nil, fmt.Errorf("failed to parse proxy record: %v", err)
 	}
-
-	// Create a new HTTP transport with the proxy settings
-	proxyTransport := &http.Transport{
-		Proxy: http.ProxyURL(proxyURL),
-	}
-
-	return &http.Client{Transport: proxyTransport}, nil
+	return profile.newClient(http.ProxyURL(p.URL())), nil
 }
@@ -1,22 +1,49 @@
 package collector_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/andybalholm/brotli"
 	. "github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/metrics"
 	"github.com/wayming/sdc/sdclogger"
 	testcommon "github.com/wayming/sdc/testcommon"
 )
 
 func TestHttpReader_Read_Proxy(t *testing.T) {
-	oneProxy, _ := testcommon.GetProxy()
-	c, _ := NewProxyClient(oneProxy)
+	// Proxy answering the requests itself, so that the target is never
+	// connected
+	var proxyAuth, requestedHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxyAuth = r.Header.Get("Proxy-Authorization")
+		requestedHost = r.URL.Host
+		w.Write([]byte("<p>This domain is for use in illustrative examples in documents.</p>"))
+	}))
+	defer proxy.Close()
+
+	oneProxy := strings.TrimPrefix(proxy.URL, "http://") + ":user:password"
+	c, _ := NewProxyClient(oneProxy, NewClientProfileByConfig(nil))
 	r := NewHttpReader(c)
 
 	var params map[string]string
 	want := "This domain is for use in illustrative examples in documents"
-	got, err := r.Read("http://example.com", params)
+	got, err := r.Read(context.Background(), "http://example.com", params)
 	if err != nil {
 		t.Errorf("HttpReader.Read() error = %v", err)
 	}
@@ -24,6 +51,98 @@ func TestHttpReader_Read_Proxy(t *testing.T) {
 	if !match {
 		t.Errorf("HttpReader.Read() = %v, want %v", got, want)
 	}
+	if requestedHost != "example.com" {
+		t.Errorf("Expecting example.com requested through the proxy, got %s", requestedHost)
+	}
+	if wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:password")); proxyAuth != wantAuth {
+		t.Errorf("Proxy-Authorization = %s, want %s", proxyAuth, wantAuth)
+	}
+
+	// Records of fewer fields fail instead of panicking
+	if _, err := NewProxyClient("1.2.3.4:8080:user", NewClientProfileByConfig(nil)); err == nil {
+		t.Errorf("Expecting error creating client of an invalid proxy record")
+	}
+}
+
+func TestHttpReader_Read_Truncated(t *testing.T) {
+	// Connection closed before the body announced is sent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("<html>"))
+	}))
+	defer srv.Close()
+
+	r := NewHttpReader(NewLocalClient(NewClientProfileByConfig(nil)))
+	got, err := r.Read(context.Background(), srv.URL, nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to read response") {
+		t.Errorf("HttpReader.Read() expecting read error, got %q, %v", got, err)
+	}
+}
+
+func TestHttpReader_Read_TransportError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	// Port of a proxy no longer listening
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen. Error: %v", err)
+	}
+	deadProxy := "http://" + ln.Addr().String()
+	ln.Close()
+	c, _ := NewProxyClient(deadProxy, NewClientProfileByConfig(nil))
+	proxyReader := NewHttpReader(c)
+	localReader := NewHttpReader(NewLocalClient(NewClientProfileByConfig(nil)))
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		reader *HttpReader
+		ctx    context.Context
+		want   bool
+	}{
+		{"ProxyUnreachable", proxyReader, context.Background(), true},
+		{"ServerError", localReader, context.Background(), false},
+		{"Cancelled", proxyReader, cancelled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.reader.Read(tt.ctx, srv.URL, nil)
+			if err == nil {
+				t.Fatalf("HttpReader.Read() expecting error")
+			}
+			var transportError TransportError
+			if got := errors.As(err, &transportError); got != tt.want {
+				t.Errorf("Expecting TransportError %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestHttpReader_Read_Timeout(t *testing.T) {
+	// Body sent slower than the timeout of the profile
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	cfg := config.NewConfig().HTTPClient
+	cfg.Timeout = 100 * time.Millisecond
+	r := NewHttpReader(NewLocalClient(NewClientProfile(cfg)))
+	begin := time.Now()
+	_, err := r.Read(context.Background(), srv.URL, nil)
+	var transportError TransportError
+	if !errors.As(err, &transportError) || time.Since(begin) > 2*time.Second {
+		t.Errorf("HttpReader.Read() expecting timeout of the body, got %v after %s", err, time.Since(begin))
+	}
 }
 
 func TestHttpReader_RedirectedUrl(t *testing.T) {
@@ -31,10 +150,20 @@ func TestHttpReader_RedirectedUrl(t *testing.T) {
 	defer fixture.Teardown(t)
 	sdclogger.SDCLoggerInstance.Logger = fixture.Logger()
 
-	r := NewHttpReader(NewLocalClient())
+	// Redirects of the renamed symbols, as served by stockanalysis.com
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stocks/fb/" {
+			http.Redirect(w, r, "/stocks/meta/", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	r := NewHttpReader(NewLocalClient(NewClientProfileByConfig(nil)))
 
-	want := "https://stockanalysis.com/stocks/meta/"
-	got, err := r.RedirectedUrl("https://stockanalysis.com/stocks/fb/")
+	want := srv.URL + "/stocks/meta/"
+	got, err := r.RedirectedUrl(context.Background(), srv.URL+"/stocks/fb/")
 	if err != nil {
 		t.Errorf("HttpReader.Read() error = %v", err)
 	}
@@ -42,3 +171,124 @@ func TestHttpReader_RedirectedUrl(t *testing.T) {
 		t.Errorf("HttpReader.Read() = %v, want %v", got, want)
 	}
 }
+
+func TestHttpReader_Read_Metrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/throttled" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	series := []string{
+		`sdc_http_requests_total{code="200",host="127.0.0.1"}`,
+		`sdc_http_requests_total{code="429",host="127.0.0.1"}`,
+		`sdc_http_request_duration_seconds_count{host="127.0.0.1"}`,
+	}
+	before := metricValues(series)
+	r := NewHttpReader(NewLocalClient(NewClientProfileByConfig(nil)))
+	r.Read(context.Background(), srv.URL+"/ok", nil)
+	r.Read(context.Background(), srv.URL+"/throttled", nil)
+	after := metricValues(series)
+
+	for i, want := range []float64{1, 1, 2} {
+		if got := after[i] - before[i]; got != want {
+			t.Errorf("Expecting %s increased by %g, got %g", series[i], want, got)
+		}
+	}
+}
+
+func TestHttpReader_Read_Profile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			return
+		}
+		cookie, _ := r.Cookie("session")
+		if r.URL.Path == "/corrupt" {
+			w.Header().Set("Content-Encoding", "br")
+			w.Write([]byte("not brotli"))
+			return
+		}
+		var b bytes.Buffer
+		var zw io.WriteCloser
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			zw = gzip.NewWriter(&b)
+		} else if strings.Contains(r.Header.Get("Accept-Encoding"), "br") {
+			w.Header().Set("Content-Encoding", "br")
+			zw = brotli.NewWriter(&b)
+		} else {
+			w.Header().Set("Content-Encoding", "deflate")
+			zw = zlib.NewWriter(&b)
+		}
+		fmt.Fprintf(zw, "%s|%s|%v", r.Header.Get("User-Agent"), r.Header.Get("Accept-Language"), cookie)
+		zw.Close()
+		w.Write(b.Bytes())
+	}))
+	defer srv.Close()
+
+	cfg := config.NewConfig().HTTPClient
+	cfg.UserAgents = []string{"agent-a", "agent-b"}
+	cfg.Headers = map[string]string{"Accept-Language": "en-AU"}
+	profile := NewClientProfile(cfg)
+	first := NewHttpReader(NewLocalClient(profile))
+	second := NewHttpReader(NewLocalClient(profile))
+
+	if _, err := first.Read(context.Background(), srv.URL+"/login", nil); err != nil {
+		t.Fatalf("HttpReader.Read() error = %v", err)
+	}
+	got, err := first.Read(context.Background(), srv.URL+"/page", nil)
+	if err != nil {
+		t.Fatalf("HttpReader.Read() error = %v", err)
+	}
+	if want := "agent-a|en-AU|session=abc"; got != want {
+		t.Errorf("HttpReader.Read() = %s, want %s", got, want)
+	}
+	got, err = second.Read(context.Background(), srv.URL+"/page", nil)
+	if err != nil {
+		t.Fatalf("HttpReader.Read() error = %v", err)
+	}
+	if want := "agent-b|en-AU|"; got != want {
+		t.Errorf("HttpReader.Read() of the second client = %s, want %s", got, want)
+	}
+
+	cfg.Compression = []string{"deflate"}
+	got, err = NewHttpReader(NewLocalClient(NewClientProfile(cfg))).Read(context.Background(), srv.URL+"/page", nil)
+	if err != nil {
+		t.Fatalf("HttpReader.Read() error = %v", err)
+	}
+	if want := "agent-a|en-AU|"; got != want {
+		t.Errorf("HttpReader.Read() of deflate body = %s, want %s", got, want)
+	}
+
+	cfg.Compression = []string{"br"}
+	brReader := NewHttpReader(NewLocalClient(NewClientProfile(cfg)))
+	got, err = brReader.Read(context.Background(), srv.URL+"/page", nil)
+	if err != nil {
+		t.Fatalf("HttpReader.Read() error = %v", err)
+	}
+	if want := "agent-a|en-AU|"; got != want {
+		t.Errorf("HttpReader.Read() of br body = %s, want %s", got, want)
+	}
+	if got, err := brReader.Read(context.Background(), srv.URL+"/corrupt", nil); err == nil || !strings.Contains(err.Error(), "failed to decode br body") {
+		t.Errorf("HttpReader.Read() expecting error decoding corrupt br body, got %q, %v", got, err)
+	}
+}
+
+// Values of the series in the default metrics registry, 0 if not found
+func metricValues(series []string) []float64 {
+	text := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(text, httptest.NewRequest("GET", metrics.METRICS_PATH, nil))
+	values := make([]float64, len(series))
+	for _, line := range strings.Split(text.Body.String(), "\n") {
+		for i, s := range series {
+			if v, ok := strings.CutPrefix(line, s+" "); ok {
+				values[i], _ = strconv.ParseFloat(v, 64)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/wayming/sdc/metrics"
+)
+
+// Interval of sampling the depth of the symbol queues
+const METRICS_SAMPLE_INTERVAL = 15 * time.Second
+
+// Upper bounds in seconds of the request latency buckets, up to the default
+// timeout of the requests
+var HTTP_LATENCY_BUCKETS = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics of the collectors served by `-metrics_addr`
+var (
+	httpRequests = promauto.With(metrics.Default).NewCounterVec(prometheus.CounterOpts{
+		Name: "sdc_http_requests_total",
+		Help: "Requests by upstream host and status code. The code is error if no response was received.",
+	}, []string{"host", "code"})
+	httpLatency = promauto.With(metrics.Default).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sdc_http_request_duration_seconds",
+		Help:    "Latency of the requests by upstream host.",
+		Buckets: HTTP_LATENCY_BUCKETS,
+	}, []string{"host"})
+	rowsLoaded = promauto.With(metrics.Default).NewCounterVec(prometheus.CounterOpts{
+		Name: "sdc_rows_loaded_total",
+		Help: "Rows loaded into the database by table.",
+	}, []string{"table"})
+	queueDepth = promauto.With(metrics.Default).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sdc_queue_depth",
+		Help: "Symbols in the queue or set, sampled while a run is active.",
+	}, []string{"queue"})
+	activeWorkers = promauto.With(metrics.Default).NewGauge(prometheus.GaugeOpts{
+		Name: "sdc_active_workers",
+		Help: "Workers of the process processing symbols.",
+	})
+	symbolsProcessed = promauto.With(metrics.Default).NewCounterVec(prometheus.CounterOpts{
+		Name: "sdc_symbols_total",
+		Help: "Symbols processed by the status decided.",
+	}, []string{"status"})
+	proxyRequests = promauto.With(metrics.Default).NewCounterVec(prometheus.CounterOpts{
+		Name: "sdc_proxy_results_total",
+		Help: "Results of the symbols processed through each proxy: succeeded, failed or throttled.",
+	}, []string{"proxy", "result"})
+)
+
+func proxyResultLabel(result int) string {
+	switch result {
+	case PROXY_SUCCEEDED:
+		return "succeeded"
+	case PROXY_THROTTLED:
+		return "throttled"
+	}
+	return "failed"
+}
+
+// Sample the depth of the symbol queue and the error set
+func (pc *ParallelCollector) sampleQueues() {
+	if n, err := pc.Cache.QueueLength(CACHE_KEY_SYMBOL); err == nil {
+		queueDepth.WithLabelValues(CACHE_KEY_SYMBOL).Set(float64(n))
+	}
+	if n, err := pc.Cache.GetLength(CACHE_KEY_SYMBOL_ERROR); err == nil {
+		queueDepth.WithLabelValues(CACHE_KEY_SYMBOL_ERROR).Set(float64(n))
+	}
+}
+
+// Sample the queues every METRICS_SAMPLE_INTERVAL until done is closed
+func (pc *ParallelCollector) sampleQueuesUntil(done chan struct{}) {
+	ticker := time.NewTicker(METRICS_SAMPLE_INTERVAL)
+	defer ticker.Stop()
+	for {
+		pc.sampleQueues()
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -8,6 +9,7 @@ import (
 	"os"
 	"reflect"
 
+	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/dbloader"
 	"github.com/wayming/sdc/sdclogger"
 )
@@ -18,23 +20,26 @@ type MSCollector struct {
 	logger      *log.Logger
 	dbSchema    string
 	msAccessKey string
+	baseURL     string
 }
 
-func NewMSCollector(loader dbloader.DBLoader, httpReader IHttpReader, logger *log.Logger, schema string) *MSCollector {
-	loader.CreateSchema(schema)
-	loader.Exec("SET search_path TO " + schema)
+func NewMSCollector(loader dbloader.DBLoader, httpReader IHttpReader, logger *log.Logger, cfg *config.Config) *MSCollector {
+	loader.CreateSchema(cfg.SchemaName)
+	loader.Exec("SET search_path TO " + cfg.SchemaName)
 	collector := MSCollector{
 		dbLoader:    loader,
+		reader:      httpReader,
 		logger:      logger,
-		dbSchema:    schema,
-		msAccessKey: os.Getenv("MSACCESSKEY"),
+		dbSchema:    cfg.SchemaName,
+		msAccessKey: cfg.Endpoints.MarketStackKey,
+		baseURL:     cfg.Endpoints.MarketStack,
 	}
 	return &collector
 }
 
-func (collector *MSCollector) CollectTickers() (int64, error) {
-	apiURL := "http://api.marketstack.com/v1/tickers"
-	jsonText, err := collector.reader.Read(apiURL, nil)
+func (collector *MSCollector) CollectTickers(ctx context.Context) (int64, error) {
+	apiURL := collector.baseURL + "/v1/tickers"
+	jsonText, err := collector.reader.Read(ctx, apiURL, nil)
 	if err != nil {
 		return 0, errors.New("Failed to load data from url " + apiURL + ", Error: " + err.Error())
 	}
@@ -42,10 +47,10 @@ func (collector *MSCollector) CollectTickers() (int64, error) {
 	if err := collector.dbLoader.CreateTableByJsonStruct(TABLE_MS_TICKERS, reflect.TypeFor[Tickers]()); err != nil {
 		return 0, err
 	}
-	return collector.LoadToDB(string(jsonText))
+	return collector.LoadToDB(ctx, string(jsonText))
 }
 
-func (collector *MSCollector) LoadToDB(jsonText string) (int64, error) {
+func (collector *MSCollector) LoadToDB(ctx context.Context, jsonText string) (int64, error) {
 	var data TickersBody
 	if err := json.Unmarshal([]byte(jsonText), &data); err != nil {
 		return 0, errors.New("Failed to unmarshal json text, Error: " + err.Error())
@@ -55,7 +60,7 @@ func (collector *MSCollector) LoadToDB(jsonText string) (int64, error) {
 		return 0, errors.New("Failed to marshal json struct, Error: " + err.Error())
 	}
 
-	numOfRows, err := collector.dbLoader.LoadByJsonText(string(dataJSONText), TABLE_MS_TICKERS, reflect.TypeFor[Tickers]())
+	numOfRows, err := loadByJsonText(ctx, collector.dbLoader, string(dataJSONText), TABLE_MS_TICKERS, reflect.TypeFor[Tickers]())
 	if err != nil {
 		return 0, errors.New("Failed to load json text to table " + TABLE_MS_TICKERS + ". Error: " + err.Error())
 	}
@@ -64,12 +69,12 @@ func (collector *MSCollector) LoadToDB(jsonText string) (int64, error) {
 
 }
 
-func (collector *MSCollector) CollectEOD() error {
+func (collector *MSCollector) CollectEOD(ctx context.Context) error {
 	type queryResult struct {
 		Symbol string
 	}
 
-	apiURL := "http://api.marketstack.com/v1/eod"
+	apiURL := collector.baseURL + "/v1/eod"
 	eodTable := "ms_eod"
 
 	sqlQuerySymbol := "select symbol from " + collector.dbSchema + "." + "ms_tickers limit 20"
@@ -85,7 +90,7 @@ func (collector *MSCollector) CollectEOD() error {
 
 	for _, row := range queryResults {
 		collector.logger.Println("Load EDO for symbool", row.Symbol)
-		jsonText, err := collector.reader.Read(apiURL, map[string]string{"symbols": row.Symbol})
+		jsonText, err := collector.reader.Read(ctx, apiURL, map[string]string{"symbols": row.Symbol})
 		if err != nil {
 			return errors.New("Failed to load data from url " + apiURL + ", Error: " + err.Error())
 		}
@@ -102,7 +107,7 @@ func (collector *MSCollector) CollectEOD() error {
 			}
 
 			var eod EOD
-			numOfRows, err := collector.dbLoader.LoadByJsonText(string(dataJSONText), eodTable, reflect.TypeOf(eod))
+			numOfRows, err := loadByJsonText(ctx, collector.dbLoader, string(dataJSONText), eodTable, reflect.TypeOf(eod))
 			if err != nil {
 				return errors.New("Failed to load json text to table " + eodTable + ". Error: " + err.Error())
 			}
@@ -117,15 +122,13 @@ func (collector *MSCollector) CollectEOD() error {
 }
 
 // Entry Function
-func CollectTickers(schemaName string, fileJSON string) (int64, error) {
-	dbLoader := dbloader.NewPGLoader(schemaName, sdclogger.SDCLoggerInstance.Logger)
-	dbLoader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
-	reader := NewHttpReader(NewLocalClient())
-	collector := NewMSCollector(dbLoader, reader, sdclogger.SDCLoggerInstance.Logger, schemaName)
+func CollectTickers(ctx context.Context, cfg *config.Config, fileJSON string) (int64, error) {
+	dbLoader, err := dbloader.NewPGLoaderByConfig(cfg, sdclogger.SDCLoggerInstance.Logger)
+	if err != nil {
+		return 0, err
+	}
+	reader := WithHttpCache(NewHttpReader(NewLocalClient(NewClientProfileByConfig(cfg))), cfg)
+	collector := NewMSCollector(dbLoader, reader, sdclogger.SDCLoggerInstance.Logger, cfg)
 	if len(fileJSON) > 0 {
 		reader, err := os.OpenFile(fileJSON, os.O_RDONLY, 0666)
 		if err != nil {
@@ -141,8 +144,8 @@ func CollectTickers(schemaName string, fileJSON string) (int64, error) {
 			return 0, err
 		}
 
-		return collector.dbLoader.LoadByJsonText(string(textJSON), TABLE_MS_TICKERS, reflect.TypeFor[Tickers]())
+		return loadByJsonText(ctx, collector.dbLoader, string(textJSON), TABLE_MS_TICKERS, reflect.TypeFor[Tickers]())
 	} else {
-		return collector.CollectTickers()
+		return collector.CollectTickers(ctx)
 	}
 }
@@ -1,17 +1,23 @@
 package collector
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"runtime/debug"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/common"
 	"github.com/wayming/sdc/config"
-	"github.com/wayming/sdc/dbloader"
+	"github.com/wayming/sdc/proxy"
 	"github.com/wayming/sdc/sdclogger"
 )
 
@@ -19,6 +25,8 @@ type ParallelCollector struct {
 	NewBuilderFunc func() IWorkerBuilder
 	Cache          cache.ICacheManager
 	Params         PCParams
+	Config         *config.Config
+	History        *RunHistory // Records the finished runs. Nil for no history.
 }
 
 const (
@@ -27,29 +35,55 @@ const (
 	WORKER_DONE_FAILURE
 	WORKER_PROCESS_FAILURE
 	SERVER_SYMBOL_NOT_VALID
+	WORKER_CANCELLED
+	SERVER_TOO_MANY_REQUESTS
+	WORKER_PANIC
+	WORKER_STARTED // Not an outcome. The worker started processing the symbol.
 )
 
+// Interval of polling the queue while the symbols in progress may queue more
+const FEEDER_POLL_INTERVAL = 100 * time.Millisecond
+
 type PCResponse struct {
-	Symbol    string
-	ErrorID   int
-	ErrorText string
+	Symbol     string
+	ErrorID    int
+	ErrorText  string
+	WorkerID   string
+	Proxy      string
+	Duration   time.Duration
+	HTTPStatus int    // Status of the failed request, or 200 on success. 0 if unknown.
+	Stack      string // Stack trace of WORKER_PANIC
+	Queued     int    // Units queued by the symbol, e.g. the datasets depending on it
 }
 
 type PCParams struct {
 	IsContinue  bool
 	TickersJSON string
 	ProxyFile   string
+	Output      io.Writer // Progress and summary. Defaults to stdout.
+	Kind        string    // RUN_KIND_EOD or RUN_KIND_FINANCIALS
+	Distributed bool      // Coordinate the workers joining the run through the cache
+	RunID       string    // ID of the run. Generated if empty.
+	Progress    *Progress // Progress of the run. Created on the output if nil.
+	// Reader of the workers sending the requests with the client of the
+	// proxy. Defaults to NewHttpReader.
+	NewReader func(client *http.Client) IHttpReader
 }
 
 func (pc *ParallelCollector) workerRoutine(
+	ctx context.Context,
 	goID string,
 	inChan chan string,
 	outChan chan PCResponse,
-	proxyChan chan string,
+	pool *ProxyPool,
+	limiter *RateLimiter,
+	profile *ClientProfile,
 	wg *sync.WaitGroup,
 ) {
 
 	defer wg.Done()
+	activeWorkers.Inc()
+	defer activeWorkers.Dec()
 
 	// Logger
 	file, _ := os.OpenFile(LOG_FILE+"."+goID, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
@@ -62,200 +96,352 @@ func (pc *ParallelCollector) workerRoutine(
 
 	logMessage("Begin")
 
+	// Proxy of the current reader, the symbol in process, its start time and
+	// the units it queued
+	currentProxy := ""
+	current := ""
+	begin := time.Now()
+	queued := 0
+	respond := func(symbol string, errorID int, errorText string, httpStatus int) {
+		if errorID == WORKER_STARTED {
+			current = symbol
+		} else {
+			current = ""
+		}
+		outChan <- PCResponse{
+			symbol, errorID, errorText, goID, currentProxy, time.Since(begin), httpStatus, "", queued,
+		}
+	}
+
+	// Panics of the worker outside of the symbols, e.g. in Init or Done, stop
+	// this routine only. The symbol in process, if any, fails with the panic.
+	defer func() {
+		if r := recover(); r != nil {
+			e := NewPanicError(r, debug.Stack())
+			logMessage(e.Error())
+			logMessage(e.Stack())
+			outChan <- PCResponse{
+				current, WORKER_PANIC, e.Error(), goID, currentProxy, time.Since(begin), 0, e.Stack(), queued,
+			}
+		}
+	}()
+
+	// Proxy leased by the worker. The lease is returned on panics of the
+	// worker too.
+	leased, held := "", false
+	release := func() {
+		if held {
+			pool.Release(leased)
+			held = false
+		}
+	}
+	defer release()
+
+	// Pages read for the current symbol, saved if the symbol panics
+	var pages *PageRecorder
+
+	newReader := pc.Params.NewReader
+	if newReader == nil {
+		newReader = func(client *http.Client) IHttpReader { return NewHttpReader(client) }
+	}
+
 	builder := pc.NewBuilderFunc()
-	loop := 1
-	for loop > 0 {
-		// Get proxy if there is on the channel
-		proxy, ok := <-proxyChan
-		if ok {
-			client, err := NewProxyClient(proxy)
+	for ctx.Err() == nil {
+
+		// Lease a proxy from the pool. Use the native reader if the pool has
+		// no proxy left.
+		record, err := pool.Lease(ctx)
+		if err != nil {
+			logMessage("Cancelled")
+			break
+		}
+		leased, held = record, true
+		if len(record) > 0 {
+			client, err := NewProxyClient(record, profile)
 			if err != nil {
 				logMessage(err.Error())
+				pool.Record(record, PROXY_FAILED, 0)
+				release()
 				continue // Retry another proxy
 			}
-			builder.WithReader(NewHttpReader(client))
-			logMessage("Established proxy reader with proxy url " + proxy)
+			pages = NewPageRecorder(WithHttpCache(NewRateLimitedReader(newReader(client), limiter, record), pc.Config))
+			logMessage("Established proxy reader with proxy " + proxy.Label(record))
 		} else {
-			builder.WithReader(NewHttpReader(NewLocalClient()))
+			pages = NewPageRecorder(WithHttpCache(NewRateLimitedReader(newReader(NewLocalClient(profile)), limiter, ""), pc.Config))
 			logMessage("Established native reader")
-			loop = 0
 		}
+		builder.WithReader(pages)
+		currentProxy = record
 
 		// Build worker
+		builder.WithConfig(pc.Config)
 		builder.WithLogger(logger)
 		builder.Default()
 		worker := builder.Build()
 
 		if err := worker.Init(); err != nil {
 			logMessage(err.Error())
-			outChan <- PCResponse{
-				"", WORKER_INIT_FAILURE, err.Error(),
-			}
+			respond("", WORKER_INIT_FAILURE, err.Error(), 0)
 			return
 		}
 
 		complete := false
 		for {
 
+			// Stop taking symbols once cancelled. The symbols left on the
+			// channel are pushed back to the cache by Execute.
+			if ctx.Err() != nil {
+				logMessage("Cancelled")
+				complete = true
+				break
+			}
+
 			var symbol string
 			select {
-			case symbol = <-inChan:
-				logMessage("Begin processing [" + symbol + "]")
-			default:
-				logMessage("All symbols are processed")
+			case <-ctx.Done():
+				logMessage("Cancelled")
 				complete = true
+			case s, ok := <-inChan:
+				if ok {
+					symbol = s
+					begin = time.Now()
+					queued = 0
+					logMessage("Begin processing [" + symbol + "]")
+					respond(symbol, WORKER_STARTED, "", 0)
+				} else {
+					logMessage("All symbols are processed")
+					complete = true
+				}
 			}
 
 			if complete {
 				break
 			}
 
-			if err := worker.Do(symbol); err != nil {
+			pages.Reset()
+			err := pc.doSymbol(ctx, worker, symbol)
+			if q, ok := worker.(IQueuingWorker); ok {
+				queued = q.Queued()
+			}
+			if err != nil {
 				logMessage(err.Error())
 
+				// Parser bugs fail the symbol only. The proxy works fine.
+				if e, ok := err.(PanicError); ok {
+					logMessage(e.Stack())
+					pool.Record(record, PROXY_SUCCEEDED, time.Since(begin))
+					text := e.Error()
+					if dir := pc.diagnosticsDir(); len(dir) > 0 {
+						if path, err := pages.Save(dir, symbol, e); err != nil {
+							logMessage(err.Error())
+						} else {
+							text += ". Pages saved to " + path
+						}
+					}
+					current = ""
+					outChan <- PCResponse{
+						symbol, WORKER_PANIC, text, goID, currentProxy, time.Since(begin), 0, e.Stack(), queued,
+					}
+					logMessage("End processing [" + symbol + "]. Panic.")
+					continue
+				}
+
+				if ctx.Err() != nil {
+					// Abandoned symbol goes back to the cache
+					respond(symbol, WORKER_CANCELLED, err.Error(), 0)
+					logMessage("End processing [" + symbol + "]. Cancelled.")
+					continue
+				}
+
 				e, ok := err.(HttpServerError)
 				if ok {
 					if e.StatusCode() == http.StatusNotFound {
-						// Symbol does not exist
-						outChan <- PCResponse{
-							symbol, SERVER_SYMBOL_NOT_VALID, e.Error(),
-						}
+						// Symbol does not exist. The proxy works fine.
+						pool.Record(record, PROXY_SUCCEEDED, time.Since(begin))
+						respond(symbol, SERVER_SYMBOL_NOT_VALID, e.Error(), e.StatusCode())
 						logMessage("End processing [" + symbol + "]. Symbol Not Valid.")
 						continue
 					}
 
 					if e.StatusCode() == http.StatusTooManyRequests {
+						pool.Record(record, PROXY_THROTTLED, time.Since(begin))
+						respond(symbol, SERVER_TOO_MANY_REQUESTS, e.Error(), e.StatusCode())
 						logMessage("End processing [" + symbol + "]. Too many request.")
-						complete = false // Continue processing with another proxy
-						break
+						break // Continue processing with another proxy
 					}
 				}
-				outChan <- PCResponse{
-					symbol, WORKER_PROCESS_FAILURE, err.Error(),
+
+				// Errors of the data, e.g. parsing or loading it, fail the
+				// symbol only. The proxy is to blame for the requests failed
+				// on the way to the server.
+				usable := true
+				if proxyFailure(err) {
+					usable = pool.Record(record, PROXY_FAILED, time.Since(begin))
 				}
+				respond(symbol, WORKER_PROCESS_FAILURE, err.Error(), httpStatus(err))
 				logMessage("End processing [" + symbol + "]. Process Error: " + err.Error())
-			} else {
-				outChan <- PCResponse{
-					symbol, SUCCESS, "",
+				if !usable {
+					logMessage("Proxy " + proxy.Label(record) + " banned")
+					break // Continue processing with another proxy
 				}
+			} else {
+				pool.Record(record, PROXY_SUCCEEDED, time.Since(begin))
+				respond(symbol, SUCCESS, "", http.StatusOK)
 				logMessage("End processing [" + symbol + "]. Succeeded.")
 			}
 		}
 
 		if err := worker.Done(); err != nil {
-			outChan <- PCResponse{
-				"", WORKER_DONE_FAILURE, err.Error(),
-			}
+			respond("", WORKER_DONE_FAILURE, err.Error(), 0)
 		}
+		release()
 
-		if complete {
+		// Give up once the native reader is throttled
+		if complete || len(record) == 0 {
 			break
 		}
 	}
 
 	logMessage("Finish")
 }
-func (pc *ParallelCollector) Execute(parallel int) error {
+
+// Status code of the failed request, 0 if the error is not from the server
+func httpStatus(err error) int {
+	if e, ok := err.(HttpServerError); ok {
+		return e.StatusCode()
+	}
+	return 0
+}
+
+// Whether the symbol failed because of the proxy, i.e. the request did not
+// reach the server or the proxy rejected the credentials
+func proxyFailure(err error) bool {
+	var transportError TransportError
+	if errors.As(err, &transportError) {
+		return true
+	}
+	var serverError HttpServerError
+	return errors.As(err, &serverError) && serverError.StatusCode() == http.StatusProxyAuthRequired
+}
+
+// Process one symbol, bounded by the symbol timeout of the configuration.
+// A panic of the worker is returned as PanicError.
+func (pc *ParallelCollector) doSymbol(ctx context.Context, worker IWorker, symbol string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewPanicError(r, debug.Stack())
+		}
+	}()
+	if pc.Config != nil && pc.Config.SymbolTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pc.Config.SymbolTimeout)
+		defer cancel()
+	}
+	return worker.Do(ctx, symbol)
+}
+
+// Run the workers until all the symbols are processed or the context is
+// cancelled. Symbols are leased from the reliable queue and acknowledged once
+// the outcome is recorded, so that a crashed run loses no symbol. On
+// cancellation the workers stop taking new symbols, and the symbols not
+// consumed yet are returned to the queue so that the next run can resume from
+// there. The proxies are leased to the workers from a pool
+// scoring the health of each proxy.
+//
+// Failed symbols are retried within the run according to the retry policy of
+// the configuration.
+//
+// With PCParams.Distributed the run is registered in the cache for
+// `sdc worker` processes to join, and the results are collected from the
+// workers through the cache. The local workers are optional in this mode.
+//
+// The report is returned whenever the workers were started. RunIncompleteError
+// is returned if some symbols failed, were parked in the dead-letter set or
+// were pushed back to the cache.
+func (pc *ParallelCollector) Execute(ctx context.Context, parallel int) (*RunReport, error) {
 
 	var nAll int64
+	report := NewRunReport()
+	if len(pc.Params.RunID) > 0 {
+		report.RunID = pc.Params.RunID
+	}
+	out := pc.output()
 	summary := "\nResults Summary:\n"
+	policy, err := pc.retryPolicy()
+	if err != nil {
+		return nil, err
+	}
 	builder := pc.NewBuilderFunc()
+	builder.WithConfig(pc.Config)
 	builder.WithParams(&pc.Params)
 	builder.Default()
-	if err := builder.Prepare(); err != nil {
-		return err
+	if err := builder.Prepare(ctx); err != nil {
+		return nil, err
 	}
 
 	// Attach to cache
 	if err := pc.Cache.Connect(); err != nil {
-		return err
+		return nil, err
 	}
 	defer pc.Cache.Disconnect()
 
+	// Requeue the symbols leased by crashed runs
+	if _, err := pc.Cache.ReapExpired(CACHE_KEY_SYMBOL); err != nil {
+		return nil, err
+	}
+
 	// Get total number of symbols to be processed
-	if nAll, _ = pc.Cache.GetLength(CACHE_KEY_SYMBOL); nAll > 0 {
-		sdclogger.SDCLoggerInstance.Printf("%d symbols to be processed in parallel(%d).", nAll, parallel)
-		summary += fmt.Sprintf("Total: %d\n", nAll)
+	if nAll, _ = pc.Cache.QueueLength(CACHE_KEY_SYMBOL); nAll > 0 {
+		sdclogger.SDCLoggerInstance.Printf("%d symbols to be processed in parallel(%d). Run ID %s.", nAll, parallel, report.RunID)
+		summary += fmt.Sprintf("Run: %s\nTotal: %d\n", report.RunID, nAll)
+		report.Totals.Symbols = nAll
+		report.Totals.Units = nAll
 	} else {
 		sdclogger.SDCLoggerInstance.Println("No symbol found.")
-		return nil
+		report.Finish(false)
+		return report, nil
 	}
 
-	var wg sync.WaitGroup
-	inChan := make(chan string, 1000*1000)
-	outChan := make(chan PCResponse, 1000*1000)
-	proxyChan := make(chan string, 1000)
-
-	// Push proxies to channel
-	numProxies, _ := pc.Cache.GetLength(CACHE_KEY_PROXY)
-	if numProxies > 0 {
-		for {
-			proxy, err := pc.Cache.PopFromSet(CACHE_KEY_PROXY)
-			if err != nil {
-				return err
-			}
-			if proxy == "" {
-				sdclogger.SDCLoggerInstance.Println("All proxies are pushed into [proxy] channel.")
-				break
-			}
-			sdclogger.SDCLoggerInstance.Printf("Push %s into [proxy] channel.", proxy)
-			proxyChan <- proxy
-		}
+	if pc.metricsEnabled() {
+		sampled := make(chan struct{})
+		var samplerWG sync.WaitGroup
+		samplerWG.Add(1)
+		go func() {
+			defer samplerWG.Done()
+			pc.sampleQueuesUntil(sampled)
+		}()
+		defer func() {
+			close(sampled)
+			samplerWG.Wait()
+		}()
 	}
-	close(proxyChan)
-
-	// Push symbols to channel
-	go func() {
-		for {
-			symbol, err := pc.Cache.PopFromSet(CACHE_KEY_SYMBOL)
-
-			if err != nil {
-				break // Exit on error
-			}
-			if len(symbol) == 0 {
-				sdclogger.SDCLoggerInstance.Println("All symbols are pushed into [input] channel.")
-				break
-			}
-			sdclogger.SDCLoggerInstance.Printf("Push %s into [input] channel.", symbol)
-			inChan <- symbol
-		}
-	}()
-	defer close(inChan) // Close the inChan when done
 
-	// Start goroutine
-	i := 0
-	for ; i < parallel; i++ {
-		wg.Add(1)
-		go pc.workerRoutine(strconv.Itoa(i), inChan, outChan, proxyChan, &wg)
+	progress := pc.Params.Progress
+	if progress == nil {
+		progress = NewProgress(out, nAll)
+	} else {
+		progress.SetTotal(nAll)
 	}
-
-	// Cleanup
-	go func() {
-		wg.Wait()
-		close(outChan)
-	}()
-
-	// Handle PCResponse
-	processed := 0
-	succeeded := 0
-	for resp := range outChan {
-		processed++
-		if resp.ErrorID != SUCCESS {
-			sdclogger.SDCLoggerInstance.Printf("Failed to process symbol %s. Error %s", resp.Symbol, resp.ErrorText)
-			if resp.ErrorID == SERVER_SYMBOL_NOT_VALID {
-				pc.Cache.AddToSet(CACHE_KEY_SYMBOL_INVALID, resp.Symbol)
-			} else {
-				pc.Cache.AddToSet(CACHE_KEY_SYMBOL_ERROR, resp.Symbol)
-			}
-		} else {
-			succeeded++
-		}
-		fmt.Printf("Processed %d, succeeded %d\n", processed, succeeded)
+	progress.Start()
+	if pc.Params.Distributed {
+		err = pc.coordinate(ctx, parallel, policy, report, progress)
+	} else {
+		err = pc.process(ctx, parallel, policy, newReportRecorder(report, progress))
 	}
+	progress.Stop()
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		sdclogger.SDCLoggerInstance.Printf("Cancelled. %d symbols pushed back to the cache.", report.Totals.Requeued)
+		summary += fmt.Sprintf("Cancelled: %d symbols pushed back to the cache\n", report.Totals.Requeued)
+	}
+	report.Finish(ctx.Err() != nil)
+	pc.saveHistory(ctx, report, parallel)
 
-	// Check left symbols
-	if leftCnt, _ := pc.Cache.GetLength(CACHE_KEY_SYMBOL); leftCnt > 0 {
-		lefts, _ := pc.Cache.GetAllFromSet(CACHE_KEY_SYMBOL)
+	// Check left symbols, pending or leased by other runs
+	if leftCnt, _ := pc.Cache.QueueLength(CACHE_KEY_SYMBOL); leftCnt > 0 {
+		lefts, _ := pc.Cache.GetAllFromQueue(CACHE_KEY_SYMBOL)
 		sdclogger.SDCLoggerInstance.Printf("Left symbols: %v", lefts)
 		summary += fmt.Sprintf("Left: %v\n", lefts)
 	} else {
@@ -282,176 +468,450 @@ func (pc *ParallelCollector) Execute(parallel int) error {
 		sdclogger.SDCLoggerInstance.Println("No invalid symbol.")
 	}
 
-	fmt.Println(summary)
-	return nil
+	// Check dead symbols. Symbols failed too many times are not retried
+	// with -continue.
+	if deadCnt, _ := pc.Cache.GetLength(CACHE_KEY_SYMBOL_DEAD); deadCnt > 0 {
+		deads, _ := pc.Cache.GetAllFromSet(CACHE_KEY_SYMBOL_DEAD)
+		sdclogger.SDCLoggerInstance.Printf("Dead Symbols: %v", deads)
+		summary += fmt.Sprintf("Dead: %v\n", deads)
+	} else {
+		sdclogger.SDCLoggerInstance.Println("No dead symbol.")
+	}
+
+	fmt.Fprintln(out, summary)
+	if !report.Complete {
+		return report, NewRunIncompleteError(report)
+	}
+	return report, nil
 }
-func (pc *ParallelCollector) Done() {
 
+// Save the report to the run history. A run is not failed by its history.
+func (pc *ParallelCollector) saveHistory(ctx context.Context, report *RunReport, parallel int) {
+	if pc.History == nil {
+		return
+	}
+	parameters := map[string]any{
+		"parallel":     parallel,
+		"continue":     pc.Params.IsContinue,
+		"tickers_json": pc.Params.TickersJSON,
+		"proxy":        pc.Params.ProxyFile,
+		"distributed":  pc.Params.Distributed,
+	}
+	// Saved also for cancelled runs
+	if err := pc.History.Save(context.WithoutCancel(ctx), report, pc.Params.Kind, parameters); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+		fmt.Fprintf(pc.output(), "Failed to save run %s to history. Error: %s\n", report.RunID, err.Error())
+	}
 }
 
-type RedirectSymbolWorker struct {
-	collector  *SACollector
-	isContinue bool
+// Records the outcome of a symbol, or of a worker for an empty symbol. The
+// symbols returned to the queue without being taken by any worker have an
+// empty worker ID.
+type recordFunc func(resp PCResponse, status string, attempts int64)
+
+// Record the outcomes in the report and show the progress
+func newReportRecorder(report *RunReport, progress *Progress) recordFunc {
+	return func(resp PCResponse, status string, attempts int64) {
+		progress.Update(resp, status)
+		if status == SYMBOL_STATUS_STARTED {
+			return
+		}
+		if len(resp.Symbol) > 0 && len(resp.WorkerID) == 0 {
+			report.AddRequeued(resp.Symbol)
+			return
+		}
+		report.Add(resp, status, attempts)
+	}
 }
 
-type FinancialOverviewWorker struct {
-	collector  *SACollector
-	isContinue bool
+func (pc *ParallelCollector) output() io.Writer {
+	if pc.Params.Output == nil {
+		return os.Stdout
+	}
+	return pc.Params.Output
 }
 
-type FinancialDetailsWorker struct {
-	collector  *SACollector
-	isContinue bool
+func (pc *ParallelCollector) retryPolicy() (*RetryPolicy, error) {
+	retryConfig := config.NewConfig().Retry
+	if pc.Config != nil {
+		retryConfig = pc.Config.Retry
+	}
+	if pc.offline() {
+		retryConfig.RetryOn = nil // Responses not cached are missed again
+	}
+	return NewRetryPolicy(retryConfig)
+}
+
+// Responses are read from the http cache only
+func (pc *ParallelCollector) offline() bool {
+	return pc.Config != nil && pc.Config.HTTPCache.Offline
 }
 
-func (w *RedirectSymbolWorker) Init(cm cache.ICacheManager, logger *log.Logger) error {
-	if w.isContinue {
-		if err := cm.MoveSet(CACHE_KEY_SYMBOL_ERROR, CACHE_KEY_SYMBOL); err != nil {
-			return fmt.Errorf("failed to restore the error symbols. Error: %s", err.Error())
+// Queues are sampled for the metrics only when served, sparing the cache
+func (pc *ParallelCollector) metricsEnabled() bool {
+	return pc.Config != nil && len(pc.Config.MetricsAddr) > 0
+}
+
+func (pc *ParallelCollector) diagnosticsDir() string {
+	if pc.Config != nil {
+		return pc.Config.DiagnosticsDir
+	}
+	return config.DEFAULT_DIAGNOSTICS_DIR
+}
+
+func (pc *ParallelCollector) leaseTTL() time.Duration {
+	if pc.Config != nil {
+		return pc.Config.Queue.LeaseTTL
+	}
+	return config.DEFAULT_QUEUE_LEASE_TTL
+}
+
+// Process the symbols of the queue with the given number of workers until the
+// queue is drained or the context is cancelled. The outcome of each symbol is
+// recorded in the cache and passed to record.
+func (pc *ParallelCollector) process(ctx context.Context, parallel int, policy *RetryPolicy, record recordFunc) error {
+
+	leaseTTL := pc.leaseTTL()
+	var wg sync.WaitGroup
+	inChan := make(chan string, parallel)
+	outChan := make(chan PCResponse, 1000*1000)
+
+	// Proxies stay in the cache and are leased to the workers by the pool
+	pool, err := pc.newProxyPool()
+	if err != nil {
+		return err
+	}
+
+	// Requests of all workers share the rate limits
+	rateConfig := config.NewConfig().RateLimit
+	if pc.Config != nil {
+		rateConfig = pc.Config.RateLimit
+	}
+	limiter := NewRateLimiter(rateConfig)
+
+	// Clients of the workers take the user agents of the profile in turn
+	profile := NewClientProfileByConfig(pc.Config)
+
+	// Symbols leased by the run are kept alive by heartbeats until they are
+	// acknowledged or returned to the queue. Closing done stops the
+	// heartbeats, and the symbols still held by the feeder or waiting for a
+	// retry are collected in leftovers.
+	leases := newSymbolLeases()
+	done := make(chan struct{})
+	var leftoverMu sync.Mutex
+	var leftovers []string
+	leftover := func(symbol string) {
+		leftoverMu.Lock()
+		defer leftoverMu.Unlock()
+		leftovers = append(leftovers, symbol)
+	}
+	var keeperWG sync.WaitGroup
+	keeperWG.Add(1)
+	go func() {
+		defer keeperWG.Done()
+		pc.keepLeases(done, leases, leaseTTL)
+	}()
+
+	// Lease symbols to the input channel. The input channel is closed by the
+	// response handler once every symbol leased reaches a final outcome,
+	// since failed symbols may be fed again for retry.
+	var pending int64
+	fed := make(chan struct{})
+	var feederWG sync.WaitGroup
+	feederWG.Add(1)
+	go func() {
+		defer feederWG.Done()
+		defer close(fed)
+		for ctx.Err() == nil {
+			// Symbols in progress may queue more work, e.g. the datasets
+			// depending on them. Checked before leasing, so that the work
+			// queued by the last symbol is not missed.
+			busy := atomic.LoadInt64(&pending) > 0
+			symbol, err := pc.Cache.Lease(ctx, CACHE_KEY_SYMBOL, leaseTTL)
+
+			if err != nil {
+				break // Exit on error
+			}
+			if len(symbol) == 0 && busy {
+				select {
+				case <-time.After(FEEDER_POLL_INTERVAL):
+					continue
+				case <-done:
+					return
+				}
+			}
+			if len(symbol) == 0 {
+				sdclogger.SDCLoggerInstance.Println("All symbols are pushed into [input] channel.")
+				break
+			}
+			sdclogger.SDCLoggerInstance.Printf("Push %s into [input] channel.", symbol)
+			leases.add(symbol)
+			atomic.AddInt64(&pending, 1)
+			select {
+			case inChan <- symbol:
+			case <-done:
+				leftover(symbol)
+				return
+			}
 		}
-	} else {
-		if err := ClearCache(); err != nil {
-			return err
+	}()
+
+	// Start goroutine
+	i := 0
+	for ; i < parallel; i++ {
+		wg.Add(1)
+		go pc.workerRoutine(ctx, strconv.Itoa(i), inChan, outChan, pool, limiter, profile, &wg)
+	}
+
+	// Cleanup
+	go func() {
+		wg.Wait()
+		close(outChan)
+	}()
+
+	// Retries wait for the backoff delay, then feed the symbol again
+	var retryWG sync.WaitGroup
+	retry := func(symbol string, delay time.Duration) {
+		retryWG.Add(1)
+		go func() {
+			defer retryWG.Done()
+			select {
+			case <-time.After(delay):
+			case <-done:
+				leftover(symbol)
+				return
+			}
+			select {
+			case inChan <- symbol:
+			case <-done:
+				leftover(symbol)
+			}
+		}()
+	}
+
+	inClosed := false
+	closeIn := func() {
+		if !inClosed {
+			close(inChan)
+			inClosed = true
 		}
-		allSymbols, err := cache.LoadSymbols(cm, CACHE_KEY_SYMBOL, config.SchemaName)
-		if err != nil {
-			return errors.New("Failed to load symbols to cache. Error: " + err.Error())
+	}
+
+	// Handle PCResponse
+	feeding, responses := fed, outChan
+	for responses != nil {
+		select {
+		case <-feeding:
+			feeding = nil
+		case resp, ok := <-responses:
+			if !ok {
+				responses = nil
+				break
+			}
+			if resp.ErrorID == WORKER_STARTED {
+				record(resp, SYMBOL_STATUS_STARTED, 0)
+				break
+			}
+			if len(resp.Symbol) == 0 {
+				record(resp, "", 0)
+				sdclogger.SDCLoggerInstance.Printf("Worker %s failed. Error %s", resp.WorkerID, resp.ErrorText)
+				break
+			}
+			status, attempts, delay := pc.handleResponse(resp, policy)
+			symbolsProcessed.WithLabelValues(status).Inc()
+			record(resp, status, attempts)
+			if status == SYMBOL_STATUS_RETRYING {
+				retry(resp.Symbol, delay)
+			} else {
+				leases.remove(resp.Symbol)
+				atomic.AddInt64(&pending, -1)
+			}
+		}
+		if feeding == nil && atomic.LoadInt64(&pending) == 0 {
+			closeIn()
 		}
-		sdclogger.SDCLoggerInstance.Printf("Loaded %d symbols to cache", allSymbols)
+	}
 
-		allProxies, err := cache.LoadProxies(cm, CACHE_KEY_PROXY, config.ProxyFile)
-		if err != nil {
-			return errors.New("Failed to load proxies to cache. Error: " + err.Error())
+	// All workers are gone. Return the symbols not processed to the queue.
+	close(done)
+	feederWG.Wait()
+	retryWG.Wait()
+	closeIn()
+	for symbol := range inChan {
+		leftovers = append(leftovers, symbol)
+	}
+	for _, symbol := range leftovers {
+		if err := pc.Cache.Nack(CACHE_KEY_SYMBOL, symbol); err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
 		}
-		sdclogger.SDCLoggerInstance.Printf("Loaded %d proxies to cache", allProxies)
-	}
-
-	// Create tables
-	dbLoader := dbloader.NewPGLoader(config.SchemaName, logger)
-	dbLoader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
-	// TODO
-	// w.collector = NewSACollector(dbLoader, nil, logger, config.SchemaName)
-	// if err := w.collector.CreateTables(); err != nil {
-	// 	sdclogger.SDCLoggerInstance.Printf("Failed to create tables. Error: %s", err)
-	// 	return err
-	// } else {
-	// 	sdclogger.SDCLoggerInstance.Printf("All tables created")
-	// 	return nil
-	// }
+		leases.remove(symbol)
+		record(PCResponse{Symbol: symbol, ErrorID: WORKER_CANCELLED}, SYMBOL_STATUS_REQUEUED, 0)
+	}
+	keeperWG.Wait()
 	return nil
 }
-func (w *RedirectSymbolWorker) Do(symbol string, cm cache.ICacheManager) error {
-	if rsymbol, err := w.collector.MapRedirectedSymbol(symbol); err != nil {
-		return err
-	} else if len(rsymbol) > 0 {
-		symbol = rsymbol
+
+// Create the proxy pool of the proxies in the cache
+func (pc *ParallelCollector) newProxyPool() (*ProxyPool, error) {
+	poolConfig := config.NewConfig().ProxyPool
+	if pc.Config != nil {
+		poolConfig = pc.Config.ProxyPool
+	}
+	var proxies []string
+	if pc.offline() {
+		return NewProxyPool(pc.Cache, proxies, poolConfig) // No request leaves the process
 	}
+	if numProxies, _ := pc.Cache.GetLength(CACHE_KEY_PROXY); numProxies > 0 {
+		var err error
+		if proxies, err = pc.Cache.GetAllFromSet(CACHE_KEY_PROXY); err != nil {
+			return nil, err
+		}
+	}
+	return NewProxyPool(pc.Cache, proxies, poolConfig)
+}
 
-	cm.AddToSet(CACHE_KEY_SYMBOL_REDIRECTED, symbol)
-	return nil
+// Decide the outcome of a symbol processed by a worker and record it in the
+// cache. Failures are counted in the cache across runs. A failed symbol is
+// parked in the dead-letter set once it fails MaxAttempts times, otherwise it
+// is retried after the returned delay if the error is retryable.
+func (pc *ParallelCollector) handleResponse(resp PCResponse, policy *RetryPolicy) (string, int64, time.Duration) {
+	symbol := resp.Symbol
+	switch {
+	case resp.ErrorID == SUCCESS:
+		pc.forgetFailures(symbol)
+		pc.ack(symbol)
+		return SYMBOL_STATUS_SUCCEEDED, 0, 0
+	case resp.ErrorID == WORKER_CANCELLED:
+		if err := pc.Cache.Nack(CACHE_KEY_SYMBOL, symbol); err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
+		}
+		return SYMBOL_STATUS_REQUEUED, 0, 0
+	case resp.ErrorID == SERVER_SYMBOL_NOT_VALID && !policy.Retryable(resp.ErrorID):
+		sdclogger.SDCLoggerInstance.Printf("Failed to process symbol %s. Error %s", symbol, resp.ErrorText)
+		pc.forgetFailures(symbol)
+		pc.Cache.AddToSet(CACHE_KEY_SYMBOL_INVALID, symbol)
+		pc.ack(symbol)
+		return SYMBOL_STATUS_INVALID, 0, 0
+	}
+
+	sdclogger.SDCLoggerInstance.Printf("Failed to process symbol %s. Error %s", symbol, resp.ErrorText)
+	attempts, err := pc.Cache.IncrHashField(CACHE_KEY_SYMBOL_ATTEMPTS, symbol)
+	if err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+		attempts = policy.MaxAttempts // Not able to track the attempts, stop retrying
+	}
+	if err := pc.Cache.SetHashField(CACHE_KEY_SYMBOL_LAST_ERROR, symbol, resp.ErrorText); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+
+	if attempts >= policy.MaxAttempts {
+		sdclogger.SDCLoggerInstance.Printf("Symbol %s failed %d times, parked in %s.", symbol, attempts, CACHE_KEY_SYMBOL_DEAD)
+		pc.Cache.AddToSet(CACHE_KEY_SYMBOL_DEAD, symbol)
+		pc.ack(symbol)
+		return SYMBOL_STATUS_DEAD, attempts, 0
+	}
+	if policy.Retryable(resp.ErrorID) {
+		delay := policy.Backoff(attempts)
+		sdclogger.SDCLoggerInstance.Printf("Retry symbol %s in %s, attempt %d.", symbol, delay, attempts+1)
+		return SYMBOL_STATUS_RETRYING, attempts, delay
+	}
+	pc.Cache.AddToSet(CACHE_KEY_SYMBOL_ERROR, symbol)
+	pc.ack(symbol)
+	return SYMBOL_STATUS_FAILED, attempts, 0
 }
-func (w *RedirectSymbolWorker) Done() error {
-	return nil
+
+// Remove the symbol from the queue. The outcome is recorded before, so that a
+// crash in between leaves the symbol leased and it is processed again.
+func (pc *ParallelCollector) ack(symbol string) {
+	if err := pc.Cache.Ack(CACHE_KEY_SYMBOL, symbol); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
 }
 
-func (w *FinancialOverviewWorker) Init(cm cache.ICacheManager, logger *log.Logger) error {
-	if w.isContinue {
-		if err := cm.MoveSet(CACHE_KEY_SYMBOL_ERROR, CACHE_KEY_SYMBOL); err != nil {
-			return fmt.Errorf("failed to restore the error symbols. Error: %s", err.Error())
+// Extend the leases of the symbols held by the run and requeue the expired
+// leases of other runs, until done is closed.
+func (pc *ParallelCollector) keepLeases(done chan struct{}, leases *symbolLeases, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
 		}
-	} else {
-		if err := cm.CopySet(CACHE_KEY_SYMBOL_REDIRECTED, CACHE_KEY_SYMBOL); err != nil {
-			return fmt.Errorf("failed to restore the redirected symbols. Error: %s", err.Error())
+		for _, symbol := range leases.all() {
+			if err := pc.Cache.Heartbeat(CACHE_KEY_SYMBOL, symbol, ttl); err != nil {
+				sdclogger.SDCLoggerInstance.Println(err.Error())
+			}
 		}
-		allSymbols, _ := cm.GetLength(CACHE_KEY_SYMBOL)
-		logger.Printf("%d symbols to process", allSymbols)
-	}
-
-	// Create tables
-	dbLoader := dbloader.NewPGLoader(config.SchemaName, logger)
-	dbLoader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
-	// TODO
-	// w.collector = NewSACollector(dbLoader, nil, logger, config.SchemaName)
-	// if err := w.collector.CreateTables(); err != nil {
-	// 	sdclogger.SDCLoggerInstance.Printf("Failed to create tables. Error: %s", err)
-	// 	return err
-	// } else {
-	// 	sdclogger.SDCLoggerInstance.Printf("All tables created")
-	// 	return nil
-	// }
-	return nil
+		if _, err := pc.Cache.ReapExpired(CACHE_KEY_SYMBOL); err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
+		}
+	}
 }
-func (w *FinancialOverviewWorker) Do(symbol string, cm cache.ICacheManager) error {
-	// if _, err := w.collector.CollectFinancialOverall(symbol, reflect.TypeFor[StockOverview]()); err != nil {
-	// 	return err
-	// } else {
-	// 	return nil
-	// }
-	return nil
+
+// Symbols leased from the queue and not acknowledged yet
+type symbolLeases struct {
+	mu      sync.Mutex
+	symbols map[string]int
 }
-func (w *FinancialOverviewWorker) Done() error {
-	return nil
+
+func newSymbolLeases() *symbolLeases {
+	return &symbolLeases{symbols: make(map[string]int)}
 }
 
-func (w *FinancialDetailsWorker) Init(cm cache.ICacheManager, logger *log.Logger) error {
-	if w.isContinue {
-		if err := cm.MoveSet(CACHE_KEY_SYMBOL_ERROR, CACHE_KEY_SYMBOL); err != nil {
-			return fmt.Errorf("failed to restore the error symbols. Error: %s", err.Error())
-		}
-	} else {
-		if err := cm.CopySet(CACHE_KEY_SYMBOL_REDIRECTED, CACHE_KEY_SYMBOL); err != nil {
-			return fmt.Errorf("failed to restore the redirected symbols. Error: %s", err.Error())
-		}
-		allSymbols, _ := cm.GetLength(CACHE_KEY_SYMBOL)
-		logger.Printf("%d symbols to process", allSymbols)
-	}
-	return nil
+func (l *symbolLeases) add(symbol string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.symbols[symbol]++
 }
-func (w *FinancialDetailsWorker) Do(symbol string, cm cache.ICacheManager) error {
-	var retErr error
 
-	if _, err := w.collector.CollectFinancialsIncome(symbol); err != nil {
-		retErr = err
+func (l *symbolLeases) remove(symbol string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.symbols[symbol]--; l.symbols[symbol] <= 0 {
+		delete(l.symbols, symbol)
 	}
-	if _, err := w.collector.CollectFinancialsBalanceSheet(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := w.collector.CollectFinancialsCashFlow(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := w.collector.CollectFinancialsRatios(symbol); err != nil {
-		retErr = err
+}
+
+func (l *symbolLeases) all() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return common.Keys(l.symbols)
+}
+
+// Clear the failures recorded for the symbol
+func (pc *ParallelCollector) forgetFailures(symbol string) {
+	if err := pc.Cache.DeleteFromHash(CACHE_KEY_SYMBOL_ATTEMPTS, symbol); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
 	}
-	if _, err := w.collector.CollectAnalystRatings(symbol); err != nil {
-		retErr = err
+	if err := pc.Cache.DeleteFromHash(CACHE_KEY_SYMBOL_LAST_ERROR, symbol); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
 	}
-
-	return retErr
 }
-func (w *FinancialDetailsWorker) Done() error {
-	return nil
+
+func (pc *ParallelCollector) Done() {
+
 }
 
-func NewEODParallelCollector(p PCParams) ParallelCollector {
+func NewEODParallelCollector(cfg *config.Config, p PCParams) ParallelCollector {
+	p.Kind = RUN_KIND_EOD
 	return ParallelCollector{
 		NewYFWorkerBuilder,
-		cache.NewCacheManager(),
+		cache.NewCacheManager(cfg),
 		p,
+		cfg,
+		NewRunHistoryByConfig(cfg),
 	}
 }
 
-func NewFinancialParallelCollector(p PCParams) ParallelCollector {
+func NewFinancialParallelCollector(cfg *config.Config, p PCParams) ParallelCollector {
+	p.Kind = RUN_KIND_FINANCIALS
 	return ParallelCollector{
 		NewSAWorkerBuilder,
-		cache.NewCacheManager(),
+		cache.NewCacheManager(cfg),
 		p,
+		cfg,
+		NewRunHistoryByConfig(cfg),
 	}
 }
@@ -1,8 +1,9 @@
 package collector
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"os"
 
 	"github.com/wayming/sdc/cache"
 	"github.com/wayming/sdc/config"
@@ -17,69 +18,107 @@ type SAWorker struct {
 	cache     cache.ICacheManager
 	collector *SACollector
 	logger    *log.Logger
+	cfg       *config.Config
+	datasets  []string
+	queued    int // Units queued by the last unit
 }
 
 type SAWorkerBuilder struct {
 	CommonWorkerBuilder
+	datasets []string
 }
 
 func (w *SAWorker) Init() error {
 	// Collector
-	w.collector = NewSACollector(w.reader, w.exporters, w.db, w.logger)
+	w.collector = NewSACollector(w.reader, w.exporters, w.db, w.logger, w.cfg)
+	if err := w.collector.SelectDatasets(w.datasets); err != nil {
+		return err
+	}
 	// if err := w.collector.CreateTables(); err != nil {
 	// 	return err
 	// }
 	return nil
 }
-func (w *SAWorker) Do(symbol string) error {
-	redirectedSymbol, err := w.collector.MapRedirectedSymbol(symbol)
-	if err != nil {
-		return err
-	}
 
-	if len(redirectedSymbol) > 0 {
-		symbol = redirectedSymbol
+// Collect one dataset of a symbol. The units of the datasets depending on
+// it are queued on success.
+func (w *SAWorker) Do(ctx context.Context, unit string) error {
+	w.queued = 0
+	symbol, name := ParseWorkUnit(unit)
+	dataset, ok := w.collector.datasetOf(name)
+	if !ok {
+		return fmt.Errorf("dataset %s of work unit %s is not selected", name, unit)
 	}
 
-	if _, err := w.collector.CollectFinancialOverview(symbol); err != nil {
+	next, err := dataset.Collect(ctx, w.collector, symbol)
+	if err != nil {
 		return err
 	}
 
-	if err := w.collector.CollectFinancialDetails(symbol); err != nil {
+	// Dependents keep the priority of the unit
+	score, err := w.cache.QueueScore(CACHE_KEY_SYMBOL, unit)
+	if err != nil {
 		return err
 	}
+	for _, dependent := range w.collector.dependents(dataset.Name) {
+		added, err := w.cache.EnqueueScored(CACHE_KEY_SYMBOL, WorkUnit(next, dependent), score)
+		if err != nil {
+			return err
+		}
+		if added {
+			w.queued++
+		}
+	}
 	return nil
 }
+
+func (w *SAWorker) Queued() int {
+	return w.queued
+}
 func (w *SAWorker) Done() error {
 	return nil
 }
+
+// Collect the datasets of the given names only. Defaults to the datasets of
+// the configuration.
+func (b *SAWorkerBuilder) WithDatasets(names []string) {
+	b.datasets = names
+}
+
 func (b *SAWorkerBuilder) Default() error {
 	if b.logger == nil {
 		b.logger = sdclogger.SDCLoggerInstance.Logger
 	}
 
+	if b.cfg == nil {
+		b.cfg = config.NewConfig()
+	}
+
+	if b.datasets == nil {
+		b.datasets = b.cfg.Financials.Datasets
+	}
+
 	if b.db == nil {
-		b.db = dbloader.NewPGLoader(config.SchemaName, b.logger)
-		b.db.Connect(os.Getenv("PGHOST"),
-			os.Getenv("PGPORT"),
-			os.Getenv("PGUSER"),
-			os.Getenv("PGPASSWORD"),
-			os.Getenv("PGDATABASE"))
+		db, err := dbloader.NewPGLoaderByConfig(b.cfg, b.logger)
+		if err != nil {
+			return err
+		}
+		b.db = db
 	}
 
 	if b.exporters == nil {
 		var saExporters DataExporters
-		saExporters.AddExporter(NewDBExporter(b.db, config.SchemaName))
+		saExporters.AddExporter(NewDBExporter(b.db, b.cfg.SchemaName))
 		saExporters.AddExporter(NewSAFileExporter())
 		b.exporters = &saExporters
 	}
 
 	if b.reader == nil {
-		b.reader = NewHttpReader(NewLocalClient())
+		b.reader = WithHttpCache(NewHttpReader(NewLocalClient(NewClientProfileByConfig(b.cfg))), b.cfg)
 	}
 
 	if b.cache == nil {
-		b.cache = cache.NewCacheManager()
+		b.cache = cache.NewCacheManager(b.cfg)
 		b.cache.Connect()
 	}
 
@@ -88,18 +127,20 @@ func (b *SAWorkerBuilder) Default() error {
 	return nil
 }
 
-func (b *SAWorkerBuilder) Prepare() error {
+func (b *SAWorkerBuilder) Prepare(ctx context.Context) error {
 
 	b.Default()
 
 	// Prepare tables
-	c := NewSACollector(b.reader, b.exporters, b.db, b.logger)
+	c := NewSACollector(b.reader, b.exporters, b.db, b.logger, b.cfg)
+	if err := c.SelectDatasets(b.datasets); err != nil {
+		return err
+	}
 	if err := c.CreateTables(); err != nil {
 		return err
 	}
 
-	b.CommonWorkerBuilder.Prepare()
-	return nil
+	return b.CommonWorkerBuilder.Prepare(ctx)
 }
 
 func (b *SAWorkerBuilder) Build() IWorker {
@@ -109,6 +150,8 @@ func (b *SAWorkerBuilder) Build() IWorker {
 		exporters: b.exporters,
 		cache:     b.cache,
 		logger:    b.logger,
+		cfg:       b.cfg,
+		datasets:  b.datasets,
 	}
 }
 
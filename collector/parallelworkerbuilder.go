@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,15 +12,24 @@ import (
 	"regexp"
 
 	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/dbloader"
+	"github.com/wayming/sdc/proxy"
 )
 
 type IWorker interface {
 	Init() error
-	Do(symbol string) error
+	Do(ctx context.Context, symbol string) error
 	Done() error
 }
 
+// Worker queuing more units while processing a symbol, e.g. the datasets
+// depending on the unit processed
+type IQueuingWorker interface {
+	// Units queued by the last call of Do
+	Queued() int
+}
+
 type IWorkerBuilder interface {
 	WithLogger(l *log.Logger)
 	WithDB(db dbloader.DBLoader)
@@ -27,8 +37,9 @@ type IWorkerBuilder interface {
 	WithReader(r IHttpReader)
 	WithParams(p *PCParams)
 	WithCache(cm cache.ICacheManager)
+	WithConfig(cfg *config.Config)
 	Default() error
-	Prepare() error
+	Prepare(ctx context.Context) error
 	Build() IWorker
 }
 
@@ -38,6 +49,8 @@ type CommonWorkerBuilder struct {
 	exporters IDataExporter
 	cache     cache.ICacheManager
 	logger    *log.Logger
+	cfg       *config.Config
+	scorer    *SymbolScorer
 	Params    *PCParams
 }
 
@@ -59,6 +72,9 @@ func (b *CommonWorkerBuilder) WithParams(p *PCParams) {
 func (b *CommonWorkerBuilder) WithCache(cm cache.ICacheManager) {
 	b.cache = cm
 }
+func (b *CommonWorkerBuilder) WithConfig(cfg *config.Config) {
+	b.cfg = cfg
+}
 
 func (b *CommonWorkerBuilder) loadSymFromFile(f string) error {
 	reader, err := os.OpenFile(f, os.O_RDONLY, 0666)
@@ -98,7 +114,7 @@ func (b *CommonWorkerBuilder) loadSymFromFile(f string) error {
 				b.logger.Printf("Ignore symbol %s, name %s.", stock.Symbol, stock.Name)
 				continue
 			}
-			if err := b.cache.AddToSet(CACHE_KEY_SYMBOL, stock.Symbol); err != nil {
+			if _, err := b.cache.EnqueueScored(CACHE_KEY_SYMBOL, stock.Symbol, b.scorer.Score(stock.Symbol)); err != nil {
 				return err
 			}
 		} else {
@@ -131,7 +147,7 @@ func (b *CommonWorkerBuilder) loadSymFromDB(tableName string) error {
 			b.logger.Printf("Ignore the empty symbol.")
 			continue
 		}
-		if err := b.cache.AddToSet(CACHE_KEY_SYMBOL, row.Symbol); err != nil {
+		if _, err := b.cache.EnqueueScored(CACHE_KEY_SYMBOL, row.Symbol, b.scorer.Score(row.Symbol)); err != nil {
 			return err
 		}
 	}
@@ -139,14 +155,30 @@ func (b *CommonWorkerBuilder) loadSymFromDB(tableName string) error {
 }
 
 func (b *CommonWorkerBuilder) loadSymFromCache(setName string) error {
-	if err := b.cache.MoveSet(setName, CACHE_KEY_SYMBOL); err != nil {
+	num, err := b.cache.EnqueueSet(setName, CACHE_KEY_SYMBOL)
+	if err != nil {
 		return fmt.Errorf("failed to restore the error symbols. Error: %s", err.Error())
 	}
+	b.logger.Printf("%d symbols restored from %s", num, setName)
+
+	// Restored symbols are queued without score
+	members, err := b.cache.GetAllFromQueue(CACHE_KEY_SYMBOL)
+	if err != nil {
+		return fmt.Errorf("failed to score the restored symbols. Error: %s", err.Error())
+	}
+	for _, m := range members {
+		if score := b.scorer.Score(m); score > 0 {
+			if _, err := b.cache.EnqueueScored(CACHE_KEY_SYMBOL, m, score); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
-func (b *CommonWorkerBuilder) loadProxyFromFile(fname string) error {
-	num, err := cache.LoadProxies(b.cache, CACHE_KEY_PROXY, fname)
+func (b *CommonWorkerBuilder) loadProxyFromFile(ctx context.Context, fname string) error {
+	num, err := cache.LoadProxies(ctx, b.cache, CACHE_KEY_PROXY, CACHE_KEY_PROXY_HEALTH,
+		fname, proxy.NewCheckerByConfig(b.cfg))
 
 	if err != nil {
 		return err
@@ -155,7 +187,13 @@ func (b *CommonWorkerBuilder) loadProxyFromFile(fname string) error {
 		return nil
 	}
 }
-func (b *CommonWorkerBuilder) Prepare() error {
+func (b *CommonWorkerBuilder) Prepare(ctx context.Context) error {
+
+	scorer, err := NewSymbolScorer(b.db, b.logger, b.cfg.Queue)
+	if err != nil {
+		return err
+	}
+	b.scorer = scorer
 
 	if len(b.Params.TickersJSON) > 0 {
 		if err := b.loadSymFromFile(b.Params.TickersJSON); err != nil {
@@ -172,7 +210,7 @@ func (b *CommonWorkerBuilder) Prepare() error {
 	}
 
 	if len(b.Params.ProxyFile) > 0 {
-		if err := b.loadProxyFromFile(b.Params.ProxyFile); err != nil {
+		if err := b.loadProxyFromFile(ctx, b.Params.ProxyFile); err != nil {
 			return err
 		}
 	}
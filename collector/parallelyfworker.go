@@ -1,8 +1,8 @@
 package collector
 
 import (
+	"context"
 	"log"
-	"os"
 
 	"github.com/wayming/sdc/cache"
 	"github.com/wayming/sdc/config"
@@ -17,6 +17,7 @@ type YFEODWorker struct {
 	cache     cache.ICacheManager
 	collector *YFCollector
 	logger    *log.Logger
+	cfg       *config.Config
 }
 
 type YFWorkerBuilder struct {
@@ -25,11 +26,11 @@ type YFWorkerBuilder struct {
 
 func (w *YFEODWorker) Init() error {
 	// Collector
-	w.collector = NewYFCollector(w.reader, w.exporters, w.db, w.logger)
+	w.collector = NewYFCollector(w.reader, w.exporters, w.db, w.logger, w.cfg)
 	return nil
 }
-func (w *YFEODWorker) Do(symbol string) error {
-	if err := w.collector.EODForSymbol(symbol); err != nil {
+func (w *YFEODWorker) Do(ctx context.Context, symbol string) error {
+	if err := w.collector.EODForSymbol(ctx, symbol); err != nil {
 		return err
 	}
 	return nil
@@ -43,28 +44,31 @@ func (b *YFWorkerBuilder) Default() error {
 		b.logger = sdclogger.SDCLoggerInstance.Logger
 	}
 
+	if b.cfg == nil {
+		b.cfg = config.NewConfig()
+	}
+
 	if b.db == nil {
-		b.db = dbloader.NewPGLoader(config.SchemaName, b.logger)
-		b.db.Connect(os.Getenv("PGHOST"),
-			os.Getenv("PGPORT"),
-			os.Getenv("PGUSER"),
-			os.Getenv("PGPASSWORD"),
-			os.Getenv("PGDATABASE"))
+		db, err := dbloader.NewPGLoaderByConfig(b.cfg, b.logger)
+		if err != nil {
+			return err
+		}
+		b.db = db
 	}
 
 	if b.exporters == nil {
 		var yfExporters DataExporters
-		yfExporters.AddExporter(NewDBExporter(b.db, config.SchemaName))
+		yfExporters.AddExporter(NewDBExporter(b.db, b.cfg.SchemaName))
 		yfExporters.AddExporter(NewYFFileExporter())
 		b.exporters = &yfExporters
 	}
 
 	if b.reader == nil {
-		b.reader = NewHttpReader(NewLocalClient())
+		b.reader = WithHttpCache(NewHttpReader(NewLocalClient(NewClientProfileByConfig(b.cfg))), b.cfg)
 	}
 
 	if b.cache == nil {
-		b.cache = cache.NewCacheManager()
+		b.cache = cache.NewCacheManager(b.cfg)
 		b.cache.Connect()
 	}
 
@@ -80,6 +84,7 @@ func (b *YFWorkerBuilder) Build() IWorker {
 		exporters: b.exporters,
 		cache:     b.cache,
 		logger:    b.logger,
+		cfg:       b.cfg,
 	}
 }
 
@@ -0,0 +1,311 @@
+package collector
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/proxy"
+)
+
+// Interval of redrawing the progress view on a terminal
+const PROGRESS_REFRESH_INTERVAL = time.Second
+
+// Number of the latest throttled responses shown in the progress view
+const PROGRESS_RECENT_THROTTLED = 5
+
+// Symbol a worker is processing
+type workerProgress struct {
+	symbol string
+	proxy  string
+	since  time.Time
+}
+
+// Response of too many requests
+type throttledProgress struct {
+	at     time.Time
+	symbol string
+	proxy  string
+}
+
+// Progress of a run built from the responses of the workers. On a terminal
+// the progress is shown as a view redrawn every PROGRESS_REFRESH_INTERVAL.
+// Otherwise a line is logged for each symbol processed.
+type Progress struct {
+	out   io.Writer
+	tty   bool
+	total int64
+	start time.Time
+
+	mu        sync.Mutex
+	processed int
+	succeeded int
+	invalid   int
+	failed    int
+	retried   int
+	workers   map[string]*workerProgress
+	throttled []throttledProgress
+	lines     int // Lines of the view drawn last
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Progress of the given number of symbols written to out. Zero total if the
+// number of symbols is unknown. The total grows by the units queued by the
+// symbols, e.g. the datasets depending on them.
+func NewProgress(out io.Writer, total int64) *Progress {
+	return &Progress{
+		out:     out,
+		tty:     isTerminal(out),
+		total:   total,
+		start:   time.Now(),
+		workers: make(map[string]*workerProgress),
+	}
+}
+
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Set the number of symbols once known
+func (p *Progress) SetTotal(total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+// Start redrawing the view on a terminal until Stop
+func (p *Progress) Start() {
+	if !p.tty || p.done != nil {
+		return
+	}
+	p.done = make(chan struct{})
+	p.stopped = make(chan struct{})
+	go func() {
+		defer close(p.stopped)
+		ticker := time.NewTicker(PROGRESS_REFRESH_INTERVAL)
+		defer ticker.Stop()
+		for {
+			p.mu.Lock()
+			p.redraw()
+			p.mu.Unlock()
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop redrawing and leave the last view on the terminal
+func (p *Progress) Stop() {
+	if p.done == nil {
+		return
+	}
+	close(p.done)
+	<-p.stopped
+	p.done = nil
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.redraw()
+	p.lines = 0
+}
+
+// Update the progress with the outcome of a symbol, or the start of a symbol
+// for SYMBOL_STATUS_STARTED.
+func (p *Progress) Update(resp PCResponse, status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if status == SYMBOL_STATUS_STARTED {
+		p.workers[resp.WorkerID] = &workerProgress{resp.Symbol, resp.Proxy, time.Now()}
+		return
+	}
+	if len(resp.WorkerID) == 0 || len(resp.Symbol) == 0 {
+		return // Symbols never taken by a worker, or worker failures
+	}
+	if w, ok := p.workers[resp.WorkerID]; ok && w.symbol == resp.Symbol {
+		w.symbol = ""
+	}
+	if p.total > 0 {
+		p.total += int64(resp.Queued)
+	}
+	if resp.ErrorID == SERVER_TOO_MANY_REQUESTS {
+		p.throttled = append(p.throttled, throttledProgress{time.Now(), resp.Symbol, resp.Proxy})
+		if len(p.throttled) > PROGRESS_RECENT_THROTTLED {
+			p.throttled = p.throttled[len(p.throttled)-PROGRESS_RECENT_THROTTLED:]
+		}
+	}
+	switch status {
+	case SYMBOL_STATUS_REQUEUED:
+		return
+	case SYMBOL_STATUS_SUCCEEDED:
+		p.succeeded++
+	case SYMBOL_STATUS_INVALID:
+		p.invalid++
+	case SYMBOL_STATUS_RETRYING:
+		p.retried++
+	default:
+		p.failed++
+	}
+	p.processed++
+
+	if !p.tty {
+		line := p.summary()
+		if resp.ErrorID == SERVER_TOO_MANY_REQUESTS {
+			line += fmt.Sprintf(". Too many requests for [%s] through proxy [%s]", resp.Symbol, proxy.Label(resp.Proxy))
+		}
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// Print a message. On a terminal the message is printed above the view.
+func (p *Progress) Printf(format string, a ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tty && p.lines > 0 {
+		fmt.Fprintf(p.out, "\033[%dA\033[J", p.lines)
+		p.lines = 0
+	}
+	fmt.Fprintf(p.out, format, a...)
+}
+
+// Symbols finished per minute
+func (p *Progress) rate() float64 {
+	minutes := time.Since(p.start).Minutes()
+	if minutes <= 0 {
+		return 0
+	}
+	return float64(p.processed-p.retried) / minutes
+}
+
+// Estimated time to process the symbols left. Unknown if negative.
+func (p *Progress) eta() time.Duration {
+	done := int64(p.processed - p.retried)
+	rate := p.rate()
+	if p.total == 0 || rate == 0 || done >= p.total {
+		return -1
+	}
+	return time.Duration(float64(p.total-done) / rate * float64(time.Minute)).Round(time.Second)
+}
+
+func (p *Progress) summary() string {
+	total := "?"
+	if p.total > 0 {
+		total = fmt.Sprint(p.total)
+	}
+	eta := "-"
+	if d := p.eta(); d >= 0 {
+		eta = d.String()
+	}
+	return fmt.Sprintf("Processed %d/%s, succeeded %d, invalid %d, error %d, retried %d, %.1f/min, ETA %s",
+		p.processed, total, p.succeeded, p.invalid, p.failed, p.retried, p.rate(), eta)
+}
+
+// Symbol a worker is processing. Proxies are shown without credentials.
+type WorkerSnapshot struct {
+	Worker string    `json:"worker"`
+	Symbol string    `json:"symbol,omitempty"`
+	Proxy  string    `json:"proxy"`
+	Since  time.Time `json:"since"`
+}
+
+type ThrottledSnapshot struct {
+	At     time.Time `json:"at"`
+	Symbol string    `json:"symbol"`
+	Proxy  string    `json:"proxy"`
+}
+
+// Progress at a point in time. ETA is -1 if unknown.
+type ProgressSnapshot struct {
+	Total         int64               `json:"total"`
+	Processed     int                 `json:"processed"`
+	Succeeded     int                 `json:"succeeded"`
+	Invalid       int                 `json:"invalid"`
+	Failed        int                 `json:"failed"`
+	Retried       int                 `json:"retried"`
+	RatePerMinute float64             `json:"rate_per_minute"`
+	ETASeconds    float64             `json:"eta_seconds"`
+	Workers       []WorkerSnapshot    `json:"workers"`
+	Throttled     []ThrottledSnapshot `json:"throttled"`
+}
+
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := ProgressSnapshot{
+		Total:         p.total,
+		Processed:     p.processed,
+		Succeeded:     p.succeeded,
+		Invalid:       p.invalid,
+		Failed:        p.failed,
+		Retried:       p.retried,
+		RatePerMinute: p.rate(),
+		ETASeconds:    -1,
+		Workers:       []WorkerSnapshot{},
+		Throttled:     []ThrottledSnapshot{},
+	}
+	if eta := p.eta(); eta >= 0 {
+		s.ETASeconds = eta.Seconds()
+	}
+	for _, id := range common.Keys(p.workers) {
+		w := p.workers[id]
+		s.Workers = append(s.Workers, WorkerSnapshot{id, w.symbol, proxy.Label(w.proxy), w.since})
+	}
+	for _, t := range p.throttled {
+		s.Throttled = append(s.Throttled, ThrottledSnapshot{t.at, t.symbol, proxy.Label(t.proxy)})
+	}
+	return s
+}
+
+// Text of the progress view
+func (p *Progress) View() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.view()
+}
+
+func (p *Progress) view() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s, elapsed %s\n", p.summary(), time.Since(p.start).Round(time.Second))
+
+	for _, id := range common.Keys(p.workers) {
+		w := p.workers[id]
+		label := proxy.Label(w.proxy)
+		if len(w.symbol) == 0 {
+			fmt.Fprintf(&b, "  worker %-6s idle      proxy %s\n", id, label)
+		} else {
+			fmt.Fprintf(&b, "  worker %-6s %-9s %s, proxy %s\n", id, w.symbol, time.Since(w.since).Round(time.Second), label)
+		}
+	}
+
+	if len(p.throttled) > 0 {
+		b.WriteString("  too many requests:\n")
+		for i := len(p.throttled) - 1; i >= 0; i-- {
+			t := p.throttled[i]
+			fmt.Fprintf(&b, "    %s %s, proxy %s\n", t.at.Format("15:04:05"), t.symbol, proxy.Label(t.proxy))
+		}
+	}
+	return b.String()
+}
+
+// Replace the view drawn last with the current one
+func (p *Progress) redraw() {
+	text := p.view()
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\033[%dA\033[J", p.lines)
+	}
+	fmt.Fprint(p.out, text)
+	p.lines = strings.Count(text, "\n")
+}
@@ -0,0 +1,246 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/proxy"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+// Outcome of a request sent through a proxy
+const (
+	PROXY_SUCCEEDED = iota
+	PROXY_FAILED
+	PROXY_THROTTLED
+)
+
+// Weight of the latest latency in the moving average
+const PROXY_LATENCY_WEIGHT = 0.2
+
+// Health of a proxy, persisted in the cache across runs
+type ProxyStats struct {
+	Successes           int64     `json:"successes"`
+	Failures            int64     `json:"failures"`
+	Throttles           int64     `json:"throttles"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LatencyMillis       float64   `json:"latency_ms"`
+	CooldownUntil       time.Time `json:"cooldown_until"`
+	Banned              bool      `json:"banned"`
+}
+
+// Success ratio smoothed for proxies with few requests, in (0, 1)
+func (s *ProxyStats) Score() float64 {
+	return float64(s.Successes+1) / float64(s.Successes+s.Failures+s.Throttles+2)
+}
+
+type proxyEntry struct {
+	stats  ProxyStats
+	leases int
+}
+
+// Pool of proxies shared by the workers. A proxy can be leased by several
+// workers at once. Leases go to the proxy with the fewest leases and then the
+// best score. Throttled proxies are not leased until the cooldown is over, and
+// proxies failing repeatedly are banned.
+type ProxyPool struct {
+	cache   cache.ICacheManager
+	config  config.ProxyPoolConfig
+	mu      sync.Mutex
+	proxies map[string]*proxyEntry
+}
+
+// Create a pool of the proxies with the stats of the previous runs. Banned
+// proxies are left out. Proxies are keyed by their records in the URL form,
+// whatever the form of the records of the cache.
+func NewProxyPool(cm cache.ICacheManager, proxies []string, cfg config.ProxyPoolConfig) (*ProxyPool, error) {
+	p := ProxyPool{
+		cache:   cm,
+		config:  cfg,
+		proxies: make(map[string]*proxyEntry),
+	}
+	if len(proxies) == 0 {
+		return &p, nil
+	}
+
+	banned, err := cm.GetAllFromSet(CACHE_KEY_PROXY_BANNED)
+	if err != nil {
+		return nil, err
+	}
+	allStats, err := cm.GetAllFromHash(CACHE_KEY_PROXY_STATS)
+	if err != nil {
+		return nil, err
+	}
+	bannedRecords := make(map[string]bool)
+	for _, record := range banned {
+		bannedRecords[normaliseRecord(record)] = true
+	}
+	// Stats saved by earlier versions are keyed by the legacy records, e.g.
+	// host:port:user:password. The stats of the URL form take precedence.
+	stats := make(map[string]string)
+	for record, text := range allStats {
+		key := normaliseRecord(record)
+		if _, ok := stats[key]; !ok || record == key {
+			stats[key] = text
+		}
+	}
+	for _, record := range proxies {
+		record = normaliseRecord(record)
+		if bannedRecords[record] {
+			continue
+		}
+		entry := proxyEntry{}
+		if text, ok := stats[record]; ok {
+			if err := json.Unmarshal([]byte(text), &entry.stats); err != nil {
+				sdclogger.SDCLoggerInstance.Printf("Ignore the stats of proxy %s. Error: %s", proxy.Label(record), err.Error())
+				entry.stats = ProxyStats{}
+			}
+		}
+		if entry.stats.Banned {
+			continue
+		}
+		p.proxies[record] = &entry
+	}
+	sdclogger.SDCLoggerInstance.Printf("%d of %d proxies in the pool.", len(p.proxies), len(proxies))
+	return &p, nil
+}
+
+// Record of the proxy in the URL form. Invalid records are kept as they are.
+func normaliseRecord(record string) string {
+	if p, err := proxy.Parse(record); err == nil {
+		return p.String()
+	}
+	return record
+}
+
+// Number of proxies not banned
+func (p *ProxyPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.proxies)
+}
+
+// Lease a proxy, waiting for the cooldown of throttled proxies if none is
+// available. An empty proxy is returned when the pool has no proxy left.
+func (p *ProxyPool) Lease(ctx context.Context) (string, error) {
+	for {
+		record, wait := p.tryLease()
+		if len(record) > 0 || wait == 0 {
+			return record, nil
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Lease the best available proxy, or return the time until the first proxy
+// comes off the cooldown.
+func (p *ProxyPool) tryLease() (string, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	best := ""
+	var wait time.Duration
+	for _, record := range common.Keys(p.proxies) {
+		entry := p.proxies[record]
+		if until := entry.stats.CooldownUntil; until.After(now) {
+			if wait == 0 || until.Sub(now) < wait {
+				wait = until.Sub(now)
+			}
+			continue
+		}
+		if len(best) == 0 {
+			best = record
+			continue
+		}
+		b := p.proxies[best]
+		if entry.leases < b.leases || (entry.leases == b.leases && entry.stats.Score() > b.stats.Score()) {
+			best = record
+		}
+	}
+	if len(best) > 0 {
+		p.proxies[best].leases++
+	}
+	return best, wait
+}
+
+// Give back a leased proxy
+func (p *ProxyPool) Release(record string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if entry, ok := p.proxies[record]; ok && entry.leases > 0 {
+		entry.leases--
+	}
+}
+
+// Record the outcome of a request through the proxy and persist the stats.
+// Returns false if the proxy should not be used any more for now, because it
+// is throttled or banned.
+func (p *ProxyPool) Record(record string, result int, latency time.Duration) bool {
+	proxyRequests.WithLabelValues(proxy.Label(record), proxyResultLabel(result)).Inc()
+	if len(record) == 0 {
+		return true
+	}
+
+	p.mu.Lock()
+	entry, ok := p.proxies[record]
+	if !ok {
+		p.mu.Unlock()
+		return false
+	}
+	stats := &entry.stats
+	usable := true
+	switch result {
+	case PROXY_SUCCEEDED:
+		stats.Successes++
+		stats.ConsecutiveFailures = 0
+		if stats.LatencyMillis == 0 {
+			stats.LatencyMillis = float64(latency.Milliseconds())
+		} else {
+			stats.LatencyMillis += PROXY_LATENCY_WEIGHT * (float64(latency.Milliseconds()) - stats.LatencyMillis)
+		}
+	case PROXY_THROTTLED:
+		stats.Throttles++
+		stats.CooldownUntil = time.Now().Add(p.config.Cooldown)
+		usable = false
+	default:
+		stats.Failures++
+		stats.ConsecutiveFailures++
+		if stats.ConsecutiveFailures >= p.config.BanAfter {
+			stats.Banned = true
+			delete(p.proxies, record)
+			usable = false
+		}
+	}
+	saved := *stats
+	p.mu.Unlock()
+
+	p.save(record, saved)
+	return usable
+}
+
+func (p *ProxyPool) save(record string, stats ProxyStats) {
+	if stats.Banned {
+		sdclogger.SDCLoggerInstance.Printf("Proxy %s banned after %d consecutive failures.", proxy.Label(record), stats.ConsecutiveFailures)
+		if err := p.cache.AddToSet(CACHE_KEY_PROXY_BANNED, record); err != nil {
+			sdclogger.SDCLoggerInstance.Println(err.Error())
+		}
+	}
+	text, err := json.Marshal(stats)
+	if err != nil {
+		sdclogger.SDCLoggerInstance.Printf("Failed to marshal the stats of proxy %s. Error: %s", proxy.Label(record), err.Error())
+		return
+	}
+	if err := p.cache.SetHashField(CACHE_KEY_PROXY_STATS, record, string(text)); err != nil {
+		sdclogger.SDCLoggerInstance.Println(err.Error())
+	}
+}
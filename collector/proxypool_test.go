@@ -0,0 +1,134 @@
+package collector_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+	testcommon "github.com/wayming/sdc/testcommon"
+)
+
+func TestProxyPool(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	good, _ := json.Marshal(ProxyStats{Successes: 10})
+	fixture.CacheExpect().GetAllFromSet(CACHE_KEY_PROXY_BANNED).Return([]string{"http://p3:1"}, nil)
+	fixture.CacheExpect().GetAllFromHash(CACHE_KEY_PROXY_STATS).Return(map[string]string{"http://p2:1": string(good)}, nil)
+
+	saved := make(map[string]ProxyStats)
+	fixture.CacheExpect().SetHashField(CACHE_KEY_PROXY_STATS, gomock.Any(), gomock.Any()).
+		DoAndReturn(func(key string, proxy string, value string) error {
+			var stats ProxyStats
+			if err := json.Unmarshal([]byte(value), &stats); err != nil {
+				t.Errorf("Failed to unmarshal stats %s. Error: %v", value, err)
+			}
+			saved[proxy] = stats
+			return nil
+		}).AnyTimes()
+	fixture.CacheExpect().AddToSet(CACHE_KEY_PROXY_BANNED, "http://p2:1").Times(1)
+
+	cooldown := 50 * time.Millisecond
+	pool, err := NewProxyPool(fixture.CacheMock(), []string{"http://p1:1", "http://p2:1", "http://p3:1"},
+		config.ProxyPoolConfig{Cooldown: cooldown, BanAfter: 2})
+	if err != nil {
+		t.Fatalf("NewProxyPool() error = %v", err)
+	}
+	if pool.Size() != 2 {
+		t.Fatalf("Expecting banned proxy left out, got %d proxies", pool.Size())
+	}
+
+	ctx := context.Background()
+	t.Run("LeaseBestScore", func(t *testing.T) {
+		if proxy, _ := pool.Lease(ctx); proxy != "http://p2:1" {
+			t.Errorf("Expecting the proxy with the best score http://p2:1, got %s", proxy)
+		}
+		if proxy, _ := pool.Lease(ctx); proxy != "http://p1:1" {
+			t.Errorf("Expecting the proxy with the fewest leases http://p1:1, got %s", proxy)
+		}
+	})
+
+	t.Run("Cooldown", func(t *testing.T) {
+		if pool.Record("http://p1:1", PROXY_THROTTLED, time.Second) {
+			t.Errorf("Expecting throttled proxy not usable")
+		}
+		pool.Release("http://p1:1")
+		if proxy, _ := pool.Lease(ctx); proxy != "http://p2:1" {
+			t.Errorf("Expecting http://p2:1 while http://p1:1 cools down, got %s", proxy)
+		}
+		if saved["http://p1:1"].Throttles != 1 {
+			t.Errorf("Expecting throttle persisted, got %+v", saved["http://p1:1"])
+		}
+	})
+
+	t.Run("Ban", func(t *testing.T) {
+		if !pool.Record("http://p2:1", PROXY_FAILED, time.Second) {
+			t.Errorf("Expecting proxy usable after one failure")
+		}
+		if pool.Record("http://p2:1", PROXY_FAILED, time.Second) {
+			t.Errorf("Expecting proxy banned after two failures")
+		}
+		if !saved["http://p2:1"].Banned {
+			t.Errorf("Expecting ban persisted, got %+v", saved["http://p2:1"])
+		}
+
+		// Only the proxy cooling down is left
+		begin := time.Now()
+		if proxy, _ := pool.Lease(ctx); proxy != "http://p1:1" {
+			t.Errorf("Expecting http://p1:1 after the cooldown, got %s", proxy)
+		}
+		if waited := time.Since(begin); waited < cooldown/2 {
+			t.Errorf("Expecting lease to wait for the cooldown, waited %s", waited)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		pool.Record("http://p1:1", PROXY_THROTTLED, time.Second)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := pool.Lease(cancelled); err == nil {
+			t.Errorf("Expecting error leasing from a cancelled context")
+		}
+	})
+}
+
+func TestProxyPool_LegacyRecords(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// Bans and stats saved by earlier versions are keyed by the legacy records
+	good, _ := json.Marshal(ProxyStats{Successes: 10})
+	stale, _ := json.Marshal(ProxyStats{Failures: 1})
+	fresh, _ := json.Marshal(ProxyStats{Successes: 20})
+	fixture.CacheExpect().GetAllFromSet(CACHE_KEY_PROXY_BANNED).Return([]string{"p3:1:user:password"}, nil)
+	fixture.CacheExpect().GetAllFromHash(CACHE_KEY_PROXY_STATS).Return(map[string]string{
+		"p1:1:user:password":        string(good),
+		"p2:1:user:password":        string(stale),
+		"http://user:password@p2:1": string(fresh),
+	}, nil)
+	fixture.CacheExpect().SetHashField(CACHE_KEY_PROXY_STATS, "http://user:password@p1:1", gomock.Any()).Times(1)
+
+	pool, err := NewProxyPool(fixture.CacheMock(), []string{
+		"p1:1:user:password", "http://user:password@p2:1", "http://user:password@p3:1", "http://user:password@p1:1",
+	}, config.ProxyPoolConfig{Cooldown: time.Minute, BanAfter: 2})
+	if err != nil {
+		t.Fatalf("NewProxyPool() error = %v", err)
+	}
+	if pool.Size() != 2 {
+		t.Fatalf("Expecting the proxy banned by its legacy record left out and the duplicates merged, got %d proxies", pool.Size())
+	}
+
+	// The stats of the URL form take precedence over the legacy ones
+	ctx := context.Background()
+	if proxy, _ := pool.Lease(ctx); proxy != "http://user:password@p2:1" {
+		t.Errorf("Expecting the proxy with the best score http://user:password@p2:1, got %s", proxy)
+	}
+	if proxy, _ := pool.Lease(ctx); proxy != "http://user:password@p1:1" {
+		t.Errorf("Expecting the proxy of the legacy record in the URL form, got %s", proxy)
+	}
+	pool.Record("http://user:password@p1:1", PROXY_SUCCEEDED, time.Second)
+}
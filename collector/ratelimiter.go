@@ -0,0 +1,160 @@
+package collector
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/proxy"
+	"github.com/wayming/sdc/sdclogger"
+	"golang.org/x/time/rate"
+)
+
+// Token buckets shared by the workers of a parallel collector, by upstream
+// host and by proxy. A host throttling the requests through a proxy is paused
+// for the Retry-After of the response.
+type RateLimiter struct {
+	config  config.RateLimitConfig
+	mu      sync.Mutex
+	hosts   map[string]*rate.Limiter
+	proxies map[string]*rate.Limiter
+	paused  map[string]time.Time
+}
+
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
+	return &RateLimiter{
+		config:  cfg,
+		hosts:   make(map[string]*rate.Limiter),
+		proxies: make(map[string]*rate.Limiter),
+		paused:  make(map[string]time.Time),
+	}
+}
+
+// Nil for unlimited rates
+func (l *RateLimiter) limiter(limiters map[string]*rate.Limiter, key string, r float64) *rate.Limiter {
+	if r <= 0 {
+		return nil
+	}
+	limiter, ok := limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(r), l.config.Burst)
+		limiters[key] = limiter
+	}
+	return limiter
+}
+
+func pauseKey(host string, proxy string) string {
+	return host + "|" + proxy
+}
+
+// Block until a request to the host through the proxy is allowed. The proxy
+// is empty for direct requests.
+func (l *RateLimiter) Wait(ctx context.Context, host string, proxy string) error {
+	l.mu.Lock()
+	until := l.paused[pauseKey(host, proxy)]
+	hostLimiter := l.limiter(l.hosts, host, l.config.Hosts[host])
+	var proxyLimiter *rate.Limiter
+	if len(proxy) > 0 {
+		proxyLimiter = l.limiter(l.proxies, proxy, l.config.PerProxy)
+	}
+	l.mu.Unlock()
+
+	if wait := time.Until(until); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+	if hostLimiter != nil {
+		if err := hostLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	if proxyLimiter != nil {
+		if err := proxyLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Hold the requests to the host through the proxy for the given time
+func (l *RateLimiter) Pause(host string, proxy string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(d)
+	if key := pauseKey(host, proxy); until.After(l.paused[key]) {
+		l.paused[key] = until
+	}
+}
+
+// Delay requested by the Retry-After header, in seconds or as a HTTP date
+func retryAfter(header map[string][]string) (time.Duration, bool) {
+	value := http.Header(header).Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at), true
+	}
+	return 0, false
+}
+
+// IHttpReader waiting for the rate limiter before each request
+type RateLimitedReader struct {
+	reader  IHttpReader
+	limiter *RateLimiter
+	proxy   string
+}
+
+func NewRateLimitedReader(reader IHttpReader, limiter *RateLimiter, proxy string) *RateLimitedReader {
+	return &RateLimitedReader{reader: reader, limiter: limiter, proxy: proxy}
+}
+
+func (r *RateLimitedReader) Read(ctx context.Context, baseURL string, params map[string]string) (string, error) {
+	host, err := r.wait(ctx, baseURL)
+	if err != nil {
+		return "", err
+	}
+	body, err := r.reader.Read(ctx, baseURL, params)
+	r.honourRetryAfter(host, err)
+	return body, err
+}
+
+func (r *RateLimitedReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	host, err := r.wait(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	redirected, err := r.reader.RedirectedUrl(ctx, url)
+	r.honourRetryAfter(host, err)
+	return redirected, err
+}
+
+func (r *RateLimitedReader) wait(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	host := u.Hostname()
+	return host, r.limiter.Wait(ctx, host, r.proxy)
+}
+
+func (r *RateLimitedReader) honourRetryAfter(host string, err error) {
+	e, ok := err.(HttpServerError)
+	if !ok || e.StatusCode() != http.StatusTooManyRequests {
+		return
+	}
+	if d, ok := retryAfter(e.ResponseHeader()); ok && d > 0 {
+		sdclogger.SDCLoggerInstance.Printf("Pause requests to %s through proxy [%s] for %s.", host, proxy.Label(r.proxy), d)
+		r.limiter.Pause(host, r.proxy, d)
+	}
+}
@@ -0,0 +1,91 @@
+package collector_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+)
+
+// Reader answering every request with the given error
+type fakeReader struct {
+	err   error
+	reads int
+}
+
+func (r *fakeReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	r.reads++
+	return "", r.err
+}
+
+func (r *fakeReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	r.reads++
+	return url, r.err
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(config.RateLimitConfig{
+		Hosts:    map[string]float64{"stockanalysis.com": 20},
+		PerProxy: 0,
+		Burst:    1,
+	})
+	ctx := context.Background()
+
+	begin := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(ctx, "stockanalysis.com", "p1:1"); err != nil {
+			t.Fatalf("RateLimiter.Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(begin); elapsed < 90*time.Millisecond {
+		t.Errorf("Expecting 3 requests at 20/s to take 100ms, took %s", elapsed)
+	}
+
+	begin = time.Now()
+	for i := 0; i < 10; i++ {
+		limiter.Wait(ctx, "openbb", "")
+	}
+	if elapsed := time.Since(begin); elapsed > 50*time.Millisecond {
+		t.Errorf("Expecting unlimited host not to wait, took %s", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	limiter.Pause("stockanalysis.com", "", time.Minute)
+	if err := limiter.Wait(cancelled, "stockanalysis.com", ""); err == nil {
+		t.Errorf("Expecting error waiting for a paused host with a cancelled context")
+	}
+}
+
+func TestRateLimitedReader_RetryAfter(t *testing.T) {
+	limiter := NewRateLimiter(config.NewConfig().RateLimit)
+	header := http.Header{}
+	header.Set("Retry-After", "1")
+	throttled := &fakeReader{err: NewHttpServerError(http.StatusTooManyRequests, header, "Too Many Requests")}
+	reader := NewRateLimitedReader(throttled, limiter, "p1:1")
+
+	ctx := context.Background()
+	url := "https://stockanalysis.com/stocks/msft/"
+	if _, err := reader.Read(ctx, url, nil); err == nil {
+		t.Fatalf("Expecting error from throttled reader")
+	}
+
+	// Other proxies are not paused
+	other := NewRateLimitedReader(&fakeReader{}, limiter, "p2:1")
+	begin := time.Now()
+	if _, err := other.Read(ctx, url, nil); err != nil || time.Since(begin) > 100*time.Millisecond {
+		t.Errorf("Expecting another proxy not paused, err %v, took %s", err, time.Since(begin))
+	}
+
+	begin = time.Now()
+	reader.RedirectedUrl(ctx, url)
+	if elapsed := time.Since(begin); elapsed < 900*time.Millisecond {
+		t.Errorf("Expecting request paused for Retry-After of 1s, took %s", elapsed)
+	}
+	if throttled.reads != 2 {
+		t.Errorf("Expecting 2 reads, got %d", throttled.reads)
+	}
+}
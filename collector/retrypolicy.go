@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/config"
+)
+
+// Error classes that can be retried within a run
+var RETRYABLE_ERROR_CLASSES = []string{
+	errorCategory(WORKER_PROCESS_FAILURE),
+	errorCategory(SERVER_TOO_MANY_REQUESTS),
+	errorCategory(SERVER_SYMBOL_NOT_VALID),
+}
+
+// Decides whether and when a failed symbol is retried
+type RetryPolicy struct {
+	MaxAttempts int64
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	retryOn     map[int]bool
+}
+
+func NewRetryPolicy(cfg config.RetryConfig) (*RetryPolicy, error) {
+	p := RetryPolicy{
+		MaxAttempts: int64(cfg.MaxAttempts),
+		BaseDelay:   cfg.BaseDelay,
+		MaxDelay:    cfg.MaxDelay,
+		retryOn:     make(map[int]bool),
+	}
+	for _, class := range cfg.RetryOn {
+		if !common.Contains(RETRYABLE_ERROR_CLASSES, class) {
+			return nil, fmt.Errorf("unknown retryable error class %s, expecting one of %v", class, RETRYABLE_ERROR_CLASSES)
+		}
+		for _, errorID := range []int{WORKER_PROCESS_FAILURE, SERVER_TOO_MANY_REQUESTS, SERVER_SYMBOL_NOT_VALID} {
+			if errorCategory(errorID) == class {
+				p.retryOn[errorID] = true
+			}
+		}
+	}
+	return &p, nil
+}
+
+func (p *RetryPolicy) Retryable(errorID int) bool {
+	return p.retryOn[errorID]
+}
+
+// Delay before retrying a symbol failed the given number of times. The delay
+// doubles on each attempt up to MaxDelay, with a random jitter of up to half
+// of the delay so that retries from many workers spread out.
+func (p *RetryPolicy) Backoff(attempts int64) time.Duration {
+	delay := p.BaseDelay
+	for i := int64(1); i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
@@ -0,0 +1,268 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/dbloader"
+	"github.com/wayming/sdc/json2db"
+	"github.com/wayming/sdc/proxy"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+const TABLE_SDC_RUNS = "sdc_runs"
+const TABLE_SDC_RUN_ITEMS = "sdc_run_items"
+
+// Rows inserted by one statement
+const HISTORY_BATCH_SIZE = 1000
+
+// Row of sdc_runs, one per run
+type RunRow struct {
+	RunID      string    `json:"run_id" db:"PrimaryKey"`
+	Kind       string    `json:"kind"`
+	Parameters string    `json:"parameters"` // JSON of the command line options
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	Cancelled  bool      `json:"cancelled"`
+	Complete   bool      `json:"complete"`
+	Symbols    int64     `json:"symbols"`
+	Units      int64     `json:"units"`
+	Processed  int       `json:"processed"`
+	Succeeded  int       `json:"succeeded"`
+	Failed     int       `json:"failed"`
+	Invalid    int       `json:"invalid"`
+	Requeued   int       `json:"requeued"`
+	Retried    int       `json:"retried"`
+	Dead       int       `json:"dead"`
+}
+
+// Row of sdc_run_items, one per outcome of a symbol in a run. A retried
+// symbol has a row for each attempt.
+type RunItemRow struct {
+	RunID           string    `json:"run_id" db:"PrimaryKey"`
+	Seq             int       `json:"seq" db:"PrimaryKey"`
+	Symbol          string    `json:"symbol"`
+	Dataset         string    `json:"dataset"`
+	Status          string    `json:"status"`
+	ErrorCategory   string    `json:"error_category"`
+	ErrorText       string    `json:"error_text"`
+	HTTPStatus      int       `json:"http_status"`
+	Attempts        int64     `json:"attempts"`
+	Worker          string    `json:"worker"`
+	Proxy           string    `json:"proxy"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+// Conditions of a history query. Empty fields match all.
+type HistoryFilter struct {
+	RunID  string
+	Symbol string
+	Status string
+	Since  time.Time
+	Limit  int
+}
+
+// Run reports persisted in the database. Safe for concurrent use, e.g. by the
+// runs and the requests of the run server.
+type RunHistory struct {
+	mu      sync.Mutex
+	db      dbloader.DBLoader
+	connect func() (dbloader.DBLoader, error) // Connects the database on first use
+}
+
+func NewRunHistory(db dbloader.DBLoader) *RunHistory {
+	return &RunHistory{db: db}
+}
+
+// Run history in the database described by the configuration. The database
+// is connected on first use, and connected again on the next use if that
+// fails.
+func NewRunHistoryByConfig(cfg *config.Config) *RunHistory {
+	return &RunHistory{connect: func() (dbloader.DBLoader, error) {
+		return dbloader.NewPGLoaderByConfig(cfg, sdclogger.SDCLoggerInstance.Logger)
+	}}
+}
+
+func (h *RunHistory) database() (dbloader.DBLoader, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db == nil {
+		db, err := h.connect()
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to the run history: %v", err)
+		}
+		h.db = db
+	}
+	return h.db, nil
+}
+
+// Disconnect the database, if connected
+func (h *RunHistory) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.db != nil {
+		h.db.Disconnect()
+		h.db = nil
+	}
+}
+
+func (h *RunHistory) CreateTables() error {
+	db, err := h.database()
+	if err != nil {
+		return err
+	}
+	if err := db.CreateTableByJsonStruct(TABLE_SDC_RUNS, reflect.TypeFor[RunRow]()); err != nil {
+		return err
+	}
+	return db.CreateTableByJsonStruct(TABLE_SDC_RUN_ITEMS, reflect.TypeFor[RunItemRow]())
+}
+
+// Save the finished run with the parameters it was started with
+func (h *RunHistory) Save(ctx context.Context, report *RunReport, kind string, parameters map[string]any) error {
+	if err := h.CreateTables(); err != nil {
+		return err
+	}
+
+	params, err := json.Marshal(parameters)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the parameters of run %s: %v", report.RunID, err)
+	}
+	run := RunRow{
+		RunID:      report.RunID,
+		Kind:       kind,
+		Parameters: string(params),
+		StartTime:  report.StartTime,
+		EndTime:    report.EndTime,
+		Cancelled:  report.Cancelled,
+		Complete:   report.Complete,
+		Symbols:    report.Totals.Symbols,
+		Units:      report.Totals.Units,
+		Processed:  report.Totals.Processed,
+		Succeeded:  report.Totals.Succeeded,
+		Failed:     report.Totals.Failed,
+		Invalid:    report.Totals.Invalid,
+		Requeued:   report.Totals.Requeued,
+		Retried:    report.Totals.Retried,
+		Dead:       report.Totals.Dead,
+	}
+	if err := h.load(ctx, []RunRow{run}, TABLE_SDC_RUNS, reflect.TypeFor[RunRow]()); err != nil {
+		return err
+	}
+
+	items := make([]RunItemRow, 0, len(report.Symbols))
+	for i, s := range report.Symbols {
+		dataset := s.Dataset
+		if len(dataset) == 0 {
+			dataset = kind // Symbol as a whole
+		}
+		// Reports written before the proxies were labelled carry the records
+		label := s.Proxy
+		if len(label) > 0 {
+			label = proxy.Label(label)
+		}
+		items = append(items, RunItemRow{
+			RunID:           report.RunID,
+			Seq:             i + 1,
+			Symbol:          s.Symbol,
+			Dataset:         dataset,
+			Status:          s.Status,
+			ErrorCategory:   s.ErrorCategory,
+			ErrorText:       truncate(s.ErrorText, json2db.MAX_CHAR_SIZE),
+			HTTPStatus:      s.HTTPStatus,
+			Attempts:        s.Attempts,
+			Worker:          s.Worker,
+			Proxy:           label,
+			DurationSeconds: s.DurationSeconds,
+			FinishedAt:      s.FinishedAt,
+		})
+	}
+	for begin := 0; begin < len(items); begin += HISTORY_BATCH_SIZE {
+		end := min(begin+HISTORY_BATCH_SIZE, len(items))
+		if err := h.load(ctx, items[begin:end], TABLE_SDC_RUN_ITEMS, reflect.TypeFor[RunItemRow]()); err != nil {
+			return err
+		}
+	}
+	sdclogger.SDCLoggerInstance.Printf("Saved run %s with %d items to history.", report.RunID, len(items))
+	return nil
+}
+
+func (h *RunHistory) load(ctx context.Context, rows any, table string, rowType reflect.Type) error {
+	text, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rows of %s: %v", table, err)
+	}
+	db, err := h.database()
+	if err != nil {
+		return err
+	}
+	if _, err := db.LoadByJsonText(ctx, string(text), table, rowType); err != nil {
+		return fmt.Errorf("failed to save rows of %s: %v", table, err)
+	}
+	return nil
+}
+
+// Most recent runs started since the given time
+func (h *RunHistory) Runs(filter HistoryFilter) ([]RunRow, error) {
+	if err := h.CreateTables(); err != nil {
+		return nil, err
+	}
+	sql := "select runid, kind, coalesce(parameters, '') as parameters, starttime, endtime, cancelled, complete," +
+		" symbols, coalesce(units, symbols) as units, processed, succeeded, failed, invalid, requeued, retried, dead" +
+		" from " + TABLE_SDC_RUNS +
+		" where ($1 = '' or runid = $1) and starttime >= $2" +
+		" order by starttime desc limit $3"
+	db, err := h.database()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.RunQuery(sql, reflect.TypeFor[RunRow](), filter.RunID, filter.Since.UTC(), filter.limit())
+	if err != nil {
+		return nil, err
+	}
+	return rows.([]RunRow), nil
+}
+
+// Most recent outcomes of the symbols matching the filter
+func (h *RunHistory) Items(filter HistoryFilter) ([]RunItemRow, error) {
+	if err := h.CreateTables(); err != nil {
+		return nil, err
+	}
+	sql := "select runid, seq, symbol, dataset, status," +
+		" coalesce(errorcategory, '') as errorcategory, coalesce(errortext, '') as errortext, httpstatus, attempts," +
+		" coalesce(worker, '') as worker, coalesce(proxy, '') as proxy, durationseconds, finishedat" +
+		" from " + TABLE_SDC_RUN_ITEMS +
+		" where ($1 = '' or runid = $1) and ($2 = '' or lower(symbol) = lower($2)) and ($3 = '' or status = $3)" +
+		" and finishedat >= $4" +
+		" order by finishedat desc, seq desc limit $5"
+	db, err := h.database()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.RunQuery(sql, reflect.TypeFor[RunItemRow](), filter.RunID, filter.Symbol, filter.Status, filter.Since.UTC(), filter.limit())
+	if err != nil {
+		return nil, err
+	}
+	return rows.([]RunItemRow), nil
+}
+
+func (f HistoryFilter) limit() int {
+	if f.Limit <= 0 {
+		return 50
+	}
+	return f.Limit
+}
+
+// Cut the text to at most n characters
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,216 @@
+package collector
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/proxy"
+)
+
+// Final status of a symbol in the run report
+const (
+	SYMBOL_STATUS_SUCCEEDED = "succeeded"
+	SYMBOL_STATUS_FAILED    = "failed"
+	SYMBOL_STATUS_INVALID   = "invalid"
+	SYMBOL_STATUS_REQUEUED  = "requeued"
+	SYMBOL_STATUS_RETRYING  = "retrying"
+	SYMBOL_STATUS_DEAD      = "dead"
+	SYMBOL_STATUS_STARTED   = "started" // Not final. Shown in the progress only.
+)
+
+type SymbolReport struct {
+	Symbol          string    `json:"symbol"`
+	Dataset         string    `json:"dataset,omitempty"`
+	Status          string    `json:"status"`
+	ErrorCategory   string    `json:"error_category,omitempty"`
+	ErrorText       string    `json:"error_text,omitempty"`
+	Stack           string    `json:"stack,omitempty"`
+	HTTPStatus      int       `json:"http_status,omitempty"`
+	Attempts        int64     `json:"attempts,omitempty"`
+	Worker          string    `json:"worker"`
+	Proxy           string    `json:"proxy,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	FinishedAt      time.Time `json:"finished_at"`
+}
+
+type WorkerReport struct {
+	Worker           string   `json:"worker"`
+	Processed        int      `json:"processed"`
+	Succeeded        int      `json:"succeeded"`
+	Errors           []string `json:"errors,omitempty"`
+	SymbolsPerMinute float64  `json:"symbols_per_minute"`
+	Proxies          []string `json:"proxies,omitempty"`
+}
+
+type RunTotals struct {
+	Symbols   int64 `json:"symbols"` // Queued at the start of the run
+	Units     int64 `json:"units"`   // Symbols and the units they queued, e.g. the datasets depending on them
+	Processed int   `json:"processed"`
+	Succeeded int   `json:"succeeded"`
+	Failed    int   `json:"failed"`
+	Invalid   int   `json:"invalid"`
+	Requeued  int   `json:"requeued"`
+	Retried   int   `json:"retried"`
+	Dead      int   `json:"dead"`
+}
+
+// Outcome of ParallelCollector.Execute
+type RunReport struct {
+	RunID     string         `json:"run_id"`
+	StartTime time.Time      `json:"start_time"`
+	EndTime   time.Time      `json:"end_time"`
+	Cancelled bool           `json:"cancelled"`
+	Complete  bool           `json:"complete"`
+	Totals    RunTotals      `json:"totals"`
+	Symbols   []SymbolReport `json:"symbols"`
+	Workers   []WorkerReport `json:"workers"`
+	Proxies   []string       `json:"proxies"`
+
+	workers map[string]*WorkerReport
+}
+
+func NewRunReport() *RunReport {
+	return &RunReport{
+		RunID:     newRunID(),
+		StartTime: time.Now().UTC(),
+		Symbols:   []SymbolReport{},
+		Workers:   []WorkerReport{},
+		Proxies:   []string{},
+		workers:   make(map[string]*WorkerReport),
+	}
+}
+
+// Time based run ID with a random suffix, e.g. 20240730T125609Z-1a2b3c4d
+func newRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+}
+
+func errorCategory(errorID int) string {
+	switch errorID {
+	case WORKER_INIT_FAILURE:
+		return "worker_init"
+	case WORKER_DONE_FAILURE:
+		return "worker_done"
+	case WORKER_PROCESS_FAILURE:
+		return "process"
+	case SERVER_SYMBOL_NOT_VALID:
+		return "symbol_not_found"
+	case WORKER_CANCELLED:
+		return "cancelled"
+	case SERVER_TOO_MANY_REQUESTS:
+		return "too_many_requests"
+	case WORKER_PANIC:
+		return "panic"
+	}
+	return ""
+}
+
+// Record a response from a worker with the outcome decided for the symbol and
+// the number of failures of the symbol so far
+func (r *RunReport) Add(resp PCResponse, status string, attempts int64) {
+	w, ok := r.workers[resp.WorkerID]
+	if !ok {
+		w = &WorkerReport{Worker: resp.WorkerID}
+		r.workers[resp.WorkerID] = w
+	}
+	// Proxies are reported by label, leaving the credentials out of the report
+	label := ""
+	if len(resp.Proxy) > 0 {
+		label = proxy.Label(resp.Proxy)
+	}
+	if len(label) > 0 && !common.Contains(w.Proxies, label) {
+		w.Proxies = append(w.Proxies, label)
+	}
+
+	// Worker level failures are not bound to any symbol
+	if len(resp.Symbol) == 0 {
+		w.Errors = append(w.Errors, errorCategory(resp.ErrorID)+": "+resp.ErrorText)
+		return
+	}
+
+	r.Totals.Units += int64(resp.Queued)
+
+	name, dataset := ParseWorkUnit(resp.Symbol)
+	symbol := SymbolReport{
+		Symbol:          name,
+		Dataset:         dataset,
+		Status:          status,
+		HTTPStatus:      resp.HTTPStatus,
+		Attempts:        attempts,
+		Worker:          resp.WorkerID,
+		Proxy:           label,
+		DurationSeconds: resp.Duration.Seconds(),
+		FinishedAt:      time.Now().UTC(),
+	}
+	switch status {
+	case SYMBOL_STATUS_SUCCEEDED:
+		r.Totals.Succeeded++
+		w.Succeeded++
+	case SYMBOL_STATUS_INVALID:
+		r.Totals.Invalid++
+	case SYMBOL_STATUS_REQUEUED:
+		r.Totals.Requeued++
+	case SYMBOL_STATUS_RETRYING:
+		r.Totals.Retried++
+	case SYMBOL_STATUS_DEAD:
+		r.Totals.Dead++
+	default:
+		r.Totals.Failed++
+	}
+	if resp.ErrorID != SUCCESS {
+		symbol.ErrorCategory = errorCategory(resp.ErrorID)
+		symbol.ErrorText = resp.ErrorText
+		symbol.Stack = resp.Stack
+	}
+	if resp.ErrorID != WORKER_CANCELLED {
+		r.Totals.Processed++
+		w.Processed++
+	}
+	r.Symbols = append(r.Symbols, symbol)
+}
+
+// Record a symbol pushed back to the cache without being taken by any worker
+func (r *RunReport) AddRequeued(symbol string) {
+	name, dataset := ParseWorkUnit(symbol)
+	r.Symbols = append(r.Symbols, SymbolReport{Symbol: name, Dataset: dataset, Status: SYMBOL_STATUS_REQUEUED, FinishedAt: time.Now().UTC()})
+	r.Totals.Requeued++
+}
+
+// Stamp the end time and aggregate the per worker statistics
+func (r *RunReport) Finish(cancelled bool) {
+	r.EndTime = time.Now().UTC()
+	r.Cancelled = cancelled
+
+	minutes := r.EndTime.Sub(r.StartTime).Minutes()
+	proxies := make(map[string]bool)
+	r.Workers = []WorkerReport{}
+	for _, id := range common.Keys(r.workers) {
+		w := r.workers[id]
+		if minutes > 0 {
+			w.SymbolsPerMinute = float64(w.Processed) / minutes
+		}
+		for _, p := range w.Proxies {
+			proxies[p] = true
+		}
+		r.Workers = append(r.Workers, *w)
+	}
+	r.Proxies = append([]string{}, common.Keys(proxies)...)
+
+	workerErrors := 0
+	for _, w := range r.Workers {
+		workerErrors += len(w.Errors)
+	}
+	r.Complete = !r.Cancelled && r.Totals.Failed == 0 && r.Totals.Dead == 0 && r.Totals.Requeued == 0 && workerErrors == 0
+}
+
+func (r *RunReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
@@ -0,0 +1,393 @@
+package collector
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+// Status of the runs started by the run server
+const (
+	SERVED_RUN_RUNNING   = "running"
+	SERVED_RUN_COMPLETE  = "complete"
+	SERVED_RUN_PARTIAL   = "partial" // Some symbols failed or were left unprocessed
+	SERVED_RUN_CANCELLED = "cancelled"
+	SERVED_RUN_FAILED    = "failed" // The run did not start or stopped on an error
+)
+
+// Default window and size of the past runs listed
+const SERVED_HISTORY_SINCE = 7 * 24 * time.Hour
+const SERVED_HISTORY_LIMIT = 50
+
+// Parameters of a run started through the API, as the flags of the loads
+type RunRequest struct {
+	Kind        string `json:"kind"` // RUN_KIND_EOD or RUN_KIND_FINANCIALS
+	Parallel    int    `json:"parallel"`
+	ProxyFile   string `json:"proxy_file"`   // Financials default to the proxy file of the configuration
+	TickersJSON string `json:"tickers_json"` // Relative to the files directory of the configuration
+	Continue    bool   `json:"continue"`
+	Distributed bool   `json:"distributed"`
+}
+
+func (r *RunRequest) validate(cfg *config.Config) error {
+	if r.Kind != RUN_KIND_EOD && r.Kind != RUN_KIND_FINANCIALS {
+		return fmt.Errorf("unknown run kind %s, expecting %s or %s", r.Kind, RUN_KIND_EOD, RUN_KIND_FINANCIALS)
+	}
+	if r.Distributed && r.Parallel < 0 {
+		return fmt.Errorf("parallel must be at least 0 with distributed, got %d", r.Parallel)
+	}
+	if !r.Distributed && r.Parallel < 1 {
+		return fmt.Errorf("parallel must be at least 1, got %d", r.Parallel)
+	}
+	if r.Continue && len(r.TickersJSON) > 0 {
+		return errors.New("continue can not be used with tickers_json")
+	}
+	if r.Kind == RUN_KIND_FINANCIALS && len(r.ProxyFile) == 0 {
+		r.ProxyFile = cfg.ProxyFile
+		if len(r.ProxyFile) == 0 {
+			return errors.New("proxy file required when loading financials")
+		}
+	}
+	for _, file := range []*string{&r.TickersJSON, &r.ProxyFile} {
+		if len(*file) == 0 {
+			continue
+		}
+		path, err := servedFile(cfg, *file)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("file %s not accessible: %v", *file, err)
+		}
+		*file = path
+	}
+	return nil
+}
+
+// Path of a file named by a request. Requests may name the proxy file of the
+// configuration, or the files under the files directory of the configuration
+// by their relative paths.
+func servedFile(cfg *config.Config, name string) (string, error) {
+	if name == cfg.ProxyFile {
+		return name, nil
+	}
+	if len(cfg.Serve.FilesDir) == 0 {
+		return "", fmt.Errorf("file %s not allowed, only the proxy file of the configuration without a files directory", name)
+	}
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("file %s not allowed, expecting a path relative to the files directory", name)
+	}
+	return filepath.Join(cfg.Serve.FilesDir, name), nil
+}
+
+// State of a run started through the API
+type ServedRun struct {
+	RunID     string           `json:"run_id"`
+	Request   RunRequest       `json:"request"`
+	Status    string           `json:"status"`
+	Error     string           `json:"error,omitempty"`
+	StartTime time.Time        `json:"start_time"`
+	EndTime   *time.Time       `json:"end_time,omitempty"`
+	Totals    *RunTotals       `json:"totals,omitempty"`
+	Progress  ProgressSnapshot `json:"progress"`
+}
+
+type servedRun struct {
+	mu       sync.Mutex
+	run      ServedRun
+	progress *Progress
+	cancel   context.CancelFunc
+}
+
+func (r *servedRun) snapshot() ServedRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	run := r.run
+	run.Progress = r.progress.Snapshot()
+	return run
+}
+
+// Record the outcome of Execute
+func (r *servedRun) finish(report *RunReport, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now().UTC()
+	r.run.EndTime = &now
+	if report != nil {
+		totals := report.Totals
+		r.run.Totals = &totals
+	}
+	var incomplete RunIncompleteError
+	switch {
+	case report != nil && report.Cancelled:
+		r.run.Status = SERVED_RUN_CANCELLED
+	case errors.As(err, &incomplete):
+		r.run.Status = SERVED_RUN_PARTIAL
+	case err != nil:
+		r.run.Status = SERVED_RUN_FAILED
+	default:
+		r.run.Status = SERVED_RUN_COMPLETE
+	}
+	if err != nil {
+		r.run.Error = err.Error()
+	}
+}
+
+// REST API starting, listing and cancelling the runs of parallel collectors.
+// One run is active at a time, as the runs share the symbol queue. Runs
+// started are kept in memory, and the runs finished earlier are read from
+// the run history.
+//
+//	GET  /runs                    runs started by the server and past runs, ?since=24h&limit=50
+//	POST /runs                    start a run with a RunRequest
+//	GET  /runs/{id}               state and progress of a run
+//	POST /runs/{id}/cancel        cancel a run
+//	POST /symbols/errors/requeue  move the error symbols back to the queue
+//
+// Requests are authenticated by the bearer token of the configuration, if any.
+type RunServer struct {
+	ctx          context.Context
+	cfg          *config.Config
+	cache        cache.ICacheManager
+	history      *RunHistory // Nil for no past runs
+	newCollector func(kind string, cfg *config.Config, p PCParams) (ParallelCollector, error)
+
+	mu     sync.Mutex
+	runs   map[string]*servedRun
+	order  []string // Run IDs in the order started
+	active string
+	wg     sync.WaitGroup
+}
+
+// Runs started by the server are cancelled with the context. The cache
+// manager is connected by the caller and shared by the requests. The runs
+// share the history, closed by the caller.
+func NewRunServer(ctx context.Context, cfg *config.Config, cm cache.ICacheManager, history *RunHistory) *RunServer {
+	return &RunServer{
+		ctx:          ctx,
+		cfg:          cfg,
+		cache:        cm,
+		history:      history,
+		newCollector: NewParallelCollectorByKind,
+		runs:         make(map[string]*servedRun),
+	}
+}
+
+// Create the collectors of the runs with the given function instead
+func (s *RunServer) WithCollectorFunc(f func(kind string, cfg *config.Config, p PCParams) (ParallelCollector, error)) {
+	s.newCollector = f
+}
+
+func (s *RunServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /runs", s.listRuns)
+	mux.HandleFunc("POST /runs", s.startRun)
+	mux.HandleFunc("GET /runs/{id}", s.getRun)
+	mux.HandleFunc("POST /runs/{id}/cancel", s.cancelRun)
+	mux.HandleFunc("POST /symbols/errors/requeue", s.requeueErrors)
+	if len(s.cfg.Serve.Token) == 0 {
+		return mux
+	}
+	return s.authenticate(mux)
+}
+
+// Reject the requests without the bearer token of the configuration
+func (s *RunServer) authenticate(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.cfg.Serve.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Wait for the runs to finish, e.g. after the context is cancelled
+func (s *RunServer) Wait() {
+	s.wg.Wait()
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		sdclogger.SDCLoggerInstance.Printf("Failed to write response. Error: %s", err.Error())
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *RunServer) startRun(w http.ResponseWriter, req *http.Request) {
+	var request RunRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid run request: %v", err))
+		return
+	}
+	if err := request.validate(s.cfg); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.active) > 0 {
+		writeError(w, http.StatusConflict, fmt.Errorf("run %s is active", s.active))
+		return
+	}
+
+	runCfg := *s.cfg
+	progress := NewProgress(io.Discard, 0)
+	params := PCParams{
+		IsContinue:  request.Continue,
+		TickersJSON: request.TickersJSON,
+		ProxyFile:   request.ProxyFile,
+		Output:      io.Discard,
+		Distributed: request.Distributed,
+		RunID:       newRunID(),
+		Progress:    progress,
+	}
+	pc, err := s.newCollector(request.Kind, &runCfg, params)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	pc.History = s.history
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	run := &servedRun{
+		run:      ServedRun{RunID: params.RunID, Request: request, Status: SERVED_RUN_RUNNING, StartTime: time.Now().UTC()},
+		progress: progress,
+		cancel:   cancel,
+	}
+	s.runs[params.RunID] = run
+	s.order = append(s.order, params.RunID)
+	s.active = params.RunID
+	sdclogger.SDCLoggerInstance.Printf("Start run %s of %s with %d workers.", params.RunID, request.Kind, request.Parallel)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		report, err := pc.Execute(ctx, request.Parallel)
+		run.finish(report, err)
+		sdclogger.SDCLoggerInstance.Printf("Run %s finished as %s.", params.RunID, run.snapshot().Status)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.active == params.RunID {
+			s.active = ""
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, run.snapshot())
+}
+
+func (s *RunServer) listRuns(w http.ResponseWriter, req *http.Request) {
+	since := time.Now().Add(-SERVED_HISTORY_SINCE)
+	if text := req.URL.Query().Get("since"); len(text) > 0 {
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid since %s, expecting a duration like 24h: %v", text, err))
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	limit := SERVED_HISTORY_LIMIT
+	if text := req.URL.Query().Get("limit"); len(text) > 0 {
+		n, err := strconv.Atoi(text)
+		if err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %s, expecting a positive number", text))
+			return
+		}
+		limit = n
+	}
+
+	s.mu.Lock()
+	served := []ServedRun{}
+	for i := len(s.order) - 1; i >= 0; i-- {
+		served = append(served, s.runs[s.order[i]].snapshot())
+	}
+	s.mu.Unlock()
+
+	past := []RunRow{}
+	if s.history != nil {
+		rows, err := s.history.Runs(HistoryFilter{Since: since, Limit: limit})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		past = rows
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"runs": served, "history": past})
+}
+
+func (s *RunServer) getRun(w http.ResponseWriter, req *http.Request) {
+	runID := req.PathValue("id")
+	s.mu.Lock()
+	run, ok := s.runs[runID]
+	s.mu.Unlock()
+	if ok {
+		writeJSON(w, http.StatusOK, run.snapshot())
+		return
+	}
+
+	if s.history != nil {
+		rows, err := s.history.Runs(HistoryFilter{RunID: runID, Limit: 1})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(rows) > 0 {
+			writeJSON(w, http.StatusOK, rows[0])
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", runID))
+}
+
+func (s *RunServer) cancelRun(w http.ResponseWriter, req *http.Request) {
+	runID := req.PathValue("id")
+	s.mu.Lock()
+	run, ok := s.runs[runID]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %s not found", runID))
+		return
+	}
+	if snapshot := run.snapshot(); snapshot.Status != SERVED_RUN_RUNNING {
+		writeError(w, http.StatusConflict, fmt.Errorf("run %s is %s", runID, snapshot.Status))
+		return
+	}
+	sdclogger.SDCLoggerInstance.Printf("Cancel run %s.", runID)
+	run.cancel()
+	writeJSON(w, http.StatusAccepted, run.snapshot())
+}
+
+// Move the symbols failed in the previous runs back to the queue, as
+// -continue does. The active run, if any, processes them too.
+func (s *RunServer) requeueErrors(w http.ResponseWriter, req *http.Request) {
+	num, err := s.cache.EnqueueSet(CACHE_KEY_SYMBOL_ERROR, CACHE_KEY_SYMBOL)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	sdclogger.SDCLoggerInstance.Printf("%d error symbols requeued.", num)
+	writeJSON(w, http.StatusOK, map[string]int64{"requeued": num})
+}
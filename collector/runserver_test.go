@@ -0,0 +1,283 @@
+package collector_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/wayming/sdc/cache"
+	. "github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+	testcommon "github.com/wayming/sdc/testcommon"
+)
+
+func doRequest(t *testing.T, method string, url string, body string, want int, value any) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request. Error: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error = %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	text, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s status = %d, want %d. Body: %s", method, url, resp.StatusCode, want, text)
+	}
+	if value != nil {
+		if err := json.Unmarshal(text, value); err != nil {
+			t.Fatalf("Failed to unmarshal %s. Error: %v", text, err)
+		}
+	}
+}
+
+func TestRunServer_StartCancel(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	fixture.CacheExpect().QueueLength(CACHE_KEY_SYMBOL).Return(int64(1), nil).Times(1)
+	fixture.CacheExpect().QueueLength(CACHE_KEY_SYMBOL).Return(int64(0), nil).AnyTimes()
+	fixture.CacheExpect().GetLength(gomock.Any()).Return(int64(0), nil).AnyTimes()
+	fixture.CacheExpect().
+		Lease(gomock.Any(), CACHE_KEY_SYMBOL, gomock.Any()).
+		Return("msft", nil).
+		Times(1)
+	fixture.CacheExpect().
+		Lease(gomock.Any(), CACHE_KEY_SYMBOL, gomock.Any()).
+		Return("", nil).
+		AnyTimes() // No symbol left
+	fixture.CacheExpect().Nack(CACHE_KEY_SYMBOL, "msft").Return(nil).AnyTimes()
+
+	started := make(chan struct{})
+	var params PCParams
+	server := NewRunServer(context.Background(), fixture.Config(), fixture.CacheMock(), nil)
+	server.WithCollectorFunc(func(kind string, cfg *config.Config, p PCParams) (ParallelCollector, error) {
+		params = p
+		return ParallelCollector{
+			func() IWorkerBuilder {
+				// Process the symbol until the run is cancelled
+				return &fakeWorkerBuilder{do: func(ctx context.Context, symbol string) error {
+					close(started)
+					<-ctx.Done()
+					return ctx.Err()
+				}}
+			},
+			fixture.CacheMock(),
+			p,
+			cfg,
+			nil,
+		}, nil
+	})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	var run ServedRun
+	doRequest(t, http.MethodPost, ts.URL+"/runs", `{"kind": "eod", "parallel": 1}`, http.StatusAccepted, &run)
+	if run.Status != SERVED_RUN_RUNNING || run.RunID != params.RunID || params.Progress == nil {
+		t.Fatalf("Expecting run %s running with progress, got %+v", params.RunID, run)
+	}
+	<-started
+
+	doRequest(t, http.MethodPost, ts.URL+"/runs", `{"kind": "eod", "parallel": 1}`, http.StatusConflict, nil)
+
+	var got ServedRun
+	doRequest(t, http.MethodGet, ts.URL+"/runs/"+run.RunID, "", http.StatusOK, &got)
+	if got.Progress.Total != 1 || len(got.Progress.Workers) != 1 || got.Progress.Workers[0].Symbol != "msft" {
+		t.Errorf("Expecting progress of msft processed by a worker, got %+v", got.Progress)
+	}
+
+	doRequest(t, http.MethodPost, ts.URL+"/runs/"+run.RunID+"/cancel", "", http.StatusAccepted, nil)
+	server.Wait()
+
+	doRequest(t, http.MethodGet, ts.URL+"/runs/"+run.RunID, "", http.StatusOK, &got)
+	if got.Status != SERVED_RUN_CANCELLED || got.EndTime == nil || got.Totals == nil {
+		t.Errorf("Expecting run cancelled with totals, got %+v", got)
+	}
+	doRequest(t, http.MethodPost, ts.URL+"/runs/"+run.RunID+"/cancel", "", http.StatusConflict, nil)
+
+	var list struct {
+		Runs []ServedRun `json:"runs"`
+	}
+	doRequest(t, http.MethodGet, ts.URL+"/runs?since=1h", "", http.StatusOK, &list)
+	if len(list.Runs) != 1 || list.Runs[0].RunID != run.RunID {
+		t.Errorf("Expecting run %s listed, got %+v", run.RunID, list.Runs)
+	}
+	doRequest(t, http.MethodGet, ts.URL+"/runs/unknown", "", http.StatusNotFound, nil)
+}
+
+func TestRunServer_Validate(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	cfg := *fixture.Config()
+	cfg.ProxyFile = ""
+	server := NewRunServer(context.Background(), &cfg, fixture.CacheMock(), nil)
+	server.WithCollectorFunc(func(kind string, cfg *config.Config, p PCParams) (ParallelCollector, error) {
+		t.Errorf("Collector of %s created for an invalid request", kind)
+		return ParallelCollector{}, nil
+	})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"unknown kind", `{"kind": "dividends", "parallel": 1}`},
+		{"no workers", `{"kind": "eod", "parallel": 0}`},
+		{"no proxy file", `{"kind": "financials", "parallel": 1}`},
+		{"continue with tickers", `{"kind": "eod", "parallel": 1, "continue": true, "tickers_json": "tickers.json"}`},
+		{"tickers without files dir", `{"kind": "eod", "parallel": 1, "tickers_json": "tickers.json"}`},
+		{"proxy file not configured", `{"kind": "financials", "parallel": 1, "proxy_file": "/etc/passwd"}`},
+		{"invalid json", `{"kind": `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doRequest(t, http.MethodPost, ts.URL+"/runs", tt.body, http.StatusBadRequest, nil)
+		})
+	}
+	doRequest(t, http.MethodGet, ts.URL+"/runs?since=yesterday", "", http.StatusBadRequest, nil)
+}
+
+func TestRunServer_Files(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tickers.json"), []byte("[]"), 0644); err != nil {
+		t.Fatalf("Failed to write tickers. Error: %v", err)
+	}
+	cfg := *fixture.Config()
+	cfg.Serve.FilesDir = dir
+	var params PCParams
+	server := NewRunServer(context.Background(), &cfg, fixture.CacheMock(), nil)
+	server.WithCollectorFunc(func(kind string, cfg *config.Config, p PCParams) (ParallelCollector, error) {
+		params = p
+		return ParallelCollector{}, errors.New("not started")
+	})
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	// Files of the files directory only
+	doRequest(t, http.MethodPost, ts.URL+"/runs", `{"kind": "eod", "parallel": 1, "tickers_json": "tickers.json"}`, http.StatusBadRequest, nil)
+	if want := filepath.Join(dir, "tickers.json"); params.TickersJSON != want {
+		t.Errorf("Expecting tickers %s, got %s", want, params.TickersJSON)
+	}
+	for _, name := range []string{"../tickers.json", filepath.Join(dir, "tickers.json"), "missing.json"} {
+		params = PCParams{}
+		doRequest(t, http.MethodPost, ts.URL+"/runs", `{"kind": "eod", "parallel": 1, "tickers_json": "`+name+`"}`, http.StatusBadRequest, nil)
+		if len(params.RunID) > 0 {
+			t.Errorf("Expecting tickers %s rejected", name)
+		}
+	}
+}
+
+func TestRunServer_Token(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	ctrl := gomock.NewController(t)
+	cm := cache.NewMockICacheManager(ctrl)
+	cm.EXPECT().EnqueueSet(CACHE_KEY_SYMBOL_ERROR, CACHE_KEY_SYMBOL).Return(int64(0), nil).Times(1)
+
+	cfg := *fixture.Config()
+	cfg.Serve.Token = "secret"
+	server := NewRunServer(context.Background(), &cfg, cm, nil)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		name string
+		auth string
+		want int
+	}{
+		{"NoToken", "", http.StatusUnauthorized},
+		{"InvalidToken", "Bearer wrong", http.StatusUnauthorized},
+		{"NotBearer", "secret", http.StatusUnauthorized},
+		{"Token", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, ts.URL+"/symbols/errors/requeue", nil)
+			if len(tt.auth) > 0 {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to requeue errors. Error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("Status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunServer_RequeueErrors(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// The cache connected by the caller is shared by the requests, which
+	// neither connect nor disconnect it
+	ctrl := gomock.NewController(t)
+	cm := cache.NewMockICacheManager(ctrl)
+	cm.EXPECT().EnqueueSet(CACHE_KEY_SYMBOL_ERROR, CACHE_KEY_SYMBOL).Return(int64(3), nil).Times(4)
+
+	server := NewRunServer(context.Background(), fixture.Config(), cm, nil)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(ts.URL+"/symbols/errors/requeue", "application/json", nil)
+			if err != nil {
+				t.Errorf("Failed to requeue errors. Error: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			var got map[string]int64
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil || got["requeued"] != 3 {
+				t.Errorf("Expecting 3 symbols requeued, got %v, %v", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRunServer_HistoryUnavailable(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen. Error: %v", err)
+	}
+	cfg := *fixture.Config()
+	cfg.Postgres.Host, cfg.Postgres.Port, _ = net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	// Requests fail while the database is down, the server keeps serving
+	history := NewRunHistoryByConfig(&cfg)
+	defer history.Close()
+	server := NewRunServer(context.Background(), &cfg, fixture.CacheMock(), history)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	doRequest(t, http.MethodGet, ts.URL+"/runs", "", http.StatusInternalServerError, nil)
+	doRequest(t, http.MethodGet, ts.URL+"/runs/unknown", "", http.StatusInternalServerError, nil)
+}
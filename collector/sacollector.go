@@ -1,12 +1,12 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 	"reflect"
 	"regexp"
 	"strings"
@@ -25,13 +25,24 @@ type SACollector struct {
 	htmlParser    *SAHTMLParser
 	metricsFields map[string]map[string]JsonFieldMetadata
 	thisSymbol    string
+	baseURL       string
+	datasets      []SADataset
+	policy        config.FinancialsConfig
 }
 
-func NewSACollector(httpReader IHttpReader, exporters IDataExporter, db dbloader.DBLoader, l *log.Logger) *SACollector {
+func NewSACollector(httpReader IHttpReader, exporters IDataExporter, db dbloader.DBLoader, l *log.Logger, cfg *config.Config) *SACollector {
 	logger := l
 	if logger == nil {
 		logger = sdclogger.SDCLoggerInstance.Logger
 	}
+	if cfg == nil {
+		cfg = config.NewConfig()
+	}
+	// Pages read offline are parsed again whatever the freshness of the data
+	policy := cfg.Financials
+	if cfg.HTTPCache.Offline {
+		policy.Force = true
+	}
 	collector := SACollector{
 		loader:        db,
 		reader:        httpReader,
@@ -40,6 +51,9 @@ func NewSACollector(httpReader IHttpReader, exporters IDataExporter, db dbloader
 		htmlParser:    NewSAHTMLParser(logger),
 		metricsFields: AllSAMetricsFields(),
 		thisSymbol:    "",
+		baseURL:       cfg.Endpoints.StockAnalysis,
+		datasets:      SADatasets,
+		policy:        policy,
 	}
 	return &collector
 }
@@ -49,19 +63,40 @@ func (c *SACollector) SetSymbol(symbol string) {
 	c.thisSymbol = symbol
 }
 
+// Collect the datasets of the given names and the datasets they depend on.
+// All datasets are collected when no name is given.
+func (c *SACollector) SelectDatasets(names []string) error {
+	datasets, err := SelectSADatasets(names)
+	if err != nil {
+		return err
+	}
+	c.datasets = datasets
+	return nil
+}
+
+// The selected dataset of the given name
+func (c *SACollector) datasetOf(name string) (SADataset, bool) {
+	d, ok := SADatasetOf(name)
+	if !ok {
+		return SADataset{}, false
+	}
+	for _, selected := range c.datasets {
+		if selected.Name == d.Name {
+			return d, true
+		}
+	}
+	return SADataset{}, false
+}
+
+// Selected datasets to be collected once the given dataset is collected
+func (c *SACollector) dependents(name string) []string {
+	return dependentsOf(c.datasets, name)
+}
+
+// Create the tables of the selected datasets
 func (c *SACollector) CreateTables() error {
-	allTables := map[string]reflect.Type{
-		SADataTables[SA_REDIRECTED_SYMBOLS]:     SADataTypes[SA_REDIRECTED_SYMBOLS],
-		SADataTables[SA_STOCKOVERVIEW]:          SADataTypes[SA_STOCKOVERVIEW],
-		SADataTables[SA_FINANCIALSINCOME]:       SADataTypes[SA_FINANCIALSINCOME],
-		SADataTables[SA_FINANCIALSBALANCESHEET]: SADataTypes[SA_FINANCIALSBALANCESHEET],
-		SADataTables[SA_FINANCIALSCASHFLOW]:     SADataTypes[SA_FINANCIALSCASHFLOW],
-		SADataTables[SA_FINANCIALRATIOS]:        SADataTypes[SA_FINANCIALRATIOS],
-		SADataTables[SA_ANALYSTSRATING]:         SADataTypes[SA_ANALYSTSRATING],
-	}
-
-	for k, v := range allTables {
-		if err := c.loader.CreateTableByJsonStruct(k, v); err != nil {
+	for _, d := range c.datasets {
+		if err := c.loader.CreateTableByJsonStruct(SADataTables[d.Table], SADataTypes[d.Table]); err != nil {
 			return err
 		}
 	}
@@ -70,8 +105,8 @@ func (c *SACollector) CreateTables() error {
 	return nil
 }
 
-func (c *SACollector) MapRedirectedSymbol(symbol string) (string, error) {
-	redirected, err := c.redirectdSymbol(symbol)
+func (c *SACollector) MapRedirectedSymbol(ctx context.Context, symbol string) (string, error) {
+	redirected, err := c.redirectdSymbol(ctx, symbol)
 	if err != nil {
 		return "", err
 	}
@@ -79,10 +114,11 @@ func (c *SACollector) MapRedirectedSymbol(symbol string) (string, error) {
 		return "", nil
 	}
 
-	redirectMap := make(map[string]string)
+	redirectMap := make(map[string]interface{})
 	redirectMap["symbol"] = symbol
 	redirectMap["redirected_symbol"] = redirected
-	mapSlice := []map[string]string{redirectMap}
+	c.packTimestampFields(redirectMap)
+	mapSlice := []map[string]interface{}{redirectMap}
 	jsonText, err := json.Marshal(mapSlice)
 	if err != nil {
 		return "", errors.New("Failed to marshal redirect map to JSON text. Error: " + err.Error())
@@ -90,7 +126,7 @@ func (c *SACollector) MapRedirectedSymbol(symbol string) (string, error) {
 		c.logger.Println("JSON text generated - " + string(jsonText))
 	}
 
-	numOfRows, err := c.loader.LoadByJsonText(string(jsonText), SADataTables[SA_REDIRECTED_SYMBOLS], reflect.TypeFor[RedirectedSymbols]())
+	numOfRows, err := loadByJsonText(ctx, c.loader, string(jsonText), SADataTables[SA_REDIRECTED_SYMBOLS], reflect.TypeFor[RedirectedSymbols]())
 	if err != nil {
 		return "", errors.New("Failed to load data into table " + SADataTables[SA_REDIRECTED_SYMBOLS] + ". Error: " + err.Error())
 	}
@@ -100,7 +136,7 @@ func (c *SACollector) MapRedirectedSymbol(symbol string) (string, error) {
 }
 
 // Extract and write financial overview to database.
-func (c *SACollector) CollectFinancialOverview(symbol string) (int64, error) {
+func (c *SACollector) CollectFinancialOverview(ctx context.Context, symbol string) (int64, error) {
 	c.thisSymbol = symbol
 
 	// err := c.loader.Exec(
@@ -110,18 +146,17 @@ func (c *SACollector) CollectFinancialOverview(symbol string) (int64, error) {
 	// 	c.logger.Println(err.Error())
 	// }
 
-	if exists, _ := c.symbolExists(symbol, SADataTables[SA_STOCKOVERVIEW]); exists {
-		c.logger.Printf("skip [%s] as it already exists in %s.", symbol, SADataTables[SA_STOCKOVERVIEW])
+	if c.isFresh(symbol, SA_STOCKOVERVIEW) {
 		return 0, nil
 	}
 
-	overallUrl := "https://stockanalysis.com/stocks/" + symbol
-	jsonText, err := c.readOverviewPage(overallUrl, nil)
+	overallUrl := c.baseURL + "/stocks/" + symbol
+	jsonText, err := c.readOverviewPage(ctx, overallUrl, nil)
 	if err != nil {
 		return 0, err
 	}
 
-	numOfRows, err := c.loader.LoadByJsonText(jsonText, SADataTables[SA_STOCKOVERVIEW], reflect.TypeFor[StockOverview]())
+	numOfRows, err := loadByJsonText(ctx, c.loader, jsonText, SADataTables[SA_STOCKOVERVIEW], reflect.TypeFor[StockOverview]())
 	if err != nil {
 		return 0, errors.New("Failed to load data into table " + SADataTables[SA_STOCKOVERVIEW] + ". Error: " + err.Error())
 	}
@@ -130,84 +165,70 @@ func (c *SACollector) CollectFinancialOverview(symbol string) (int64, error) {
 	return numOfRows, nil
 }
 
-// Extract and write financial details to database. Only return the last error
-func (c *SACollector) CollectFinancialDetails(symbol string) error {
-	var retErr error
-	if _, err := c.CollectFinancialOverview(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := c.CollectFinancialsIncome(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := c.CollectFinancialsBalanceSheet(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := c.CollectFinancialsCashFlow(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := c.CollectFinancialsRatios(symbol); err != nil {
-		retErr = err
-	}
-	if _, err := c.CollectAnalystRatings(symbol); err != nil {
-		retErr = err
+// Collect the selected financial pages of the symbol. Pages failed do not
+// stop the others, and all the errors are returned.
+func (c *SACollector) CollectFinancialDetails(ctx context.Context, symbol string) error {
+	var errs []error
+	for _, dataset := range c.datasets {
+		if len(dataset.DependsOn) == 0 {
+			continue // Redirect is mapped by the caller
+		}
+		if _, err := dataset.Collect(ctx, c, symbol); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dataset.Name, err))
+		}
 	}
-	return retErr
+	return errors.Join(errs...)
 }
 
-func (c *SACollector) CollectFinancialsIncome(symbol string) (int64, error) {
+func (c *SACollector) CollectFinancialsIncome(ctx context.Context, symbol string) (int64, error) {
 	c.thisSymbol = symbol
-	financialsIncome := "https://stockanalysis.com/stocks/" + strings.ToLower(symbol) + "/financials/?p=quarterly"
+	financialsIncome := c.baseURL + "/stocks/" + strings.ToLower(symbol) + "/financials/?p=quarterly"
 
-	if exists, _ := c.symbolExists(symbol, SADataTables[SA_FINANCIALSINCOME]); exists {
-		c.logger.Printf("skip [%s] as it already exists in %s.", symbol, SADataTables[SA_FINANCIALSINCOME])
+	if c.isFresh(symbol, SA_FINANCIALSINCOME) {
 		return 0, nil
 	}
-	return c.collectFinancialDetailsCommon(financialsIncome, SADataTypes[SA_FINANCIALSINCOME], SADataTables[SA_FINANCIALSINCOME])
+	return c.collectFinancialDetailsCommon(ctx, financialsIncome, SADataTypes[SA_FINANCIALSINCOME], SADataTables[SA_FINANCIALSINCOME])
 }
 
-func (c *SACollector) CollectFinancialsBalanceSheet(symbol string) (int64, error) {
+func (c *SACollector) CollectFinancialsBalanceSheet(ctx context.Context, symbol string) (int64, error) {
 	c.thisSymbol = symbol
-	financialsBalanceSheet := "https://stockanalysis.com/stocks/" + strings.ToLower(symbol) + "/financials/balance-sheet/?p=quarterly"
+	financialsBalanceSheet := c.baseURL + "/stocks/" + strings.ToLower(symbol) + "/financials/balance-sheet/?p=quarterly"
 
-	if exists, _ := c.symbolExists(symbol, SADataTables[SA_FINANCIALSBALANCESHEET]); exists {
-		c.logger.Printf("skip [%s] as it already exists in %s.", symbol, SADataTables[SA_FINANCIALSBALANCESHEET])
+	if c.isFresh(symbol, SA_FINANCIALSBALANCESHEET) {
 		return 0, nil
 	}
-	return c.collectFinancialDetailsCommon(financialsBalanceSheet, SADataTypes[SA_FINANCIALSBALANCESHEET], SADataTables[SA_FINANCIALSBALANCESHEET])
+	return c.collectFinancialDetailsCommon(ctx, financialsBalanceSheet, SADataTypes[SA_FINANCIALSBALANCESHEET], SADataTables[SA_FINANCIALSBALANCESHEET])
 }
 
-func (c *SACollector) CollectFinancialsCashFlow(symbol string) (int64, error) {
+func (c *SACollector) CollectFinancialsCashFlow(ctx context.Context, symbol string) (int64, error) {
 	c.thisSymbol = symbol
-	financialsICashFlow := "https://stockanalysis.com/stocks/" + strings.ToLower(symbol) + "/financials/cash-flow-statement/?p=quarterly"
+	financialsICashFlow := c.baseURL + "/stocks/" + strings.ToLower(symbol) + "/financials/cash-flow-statement/?p=quarterly"
 
-	if exists, _ := c.symbolExists(symbol, SADataTables[SA_FINANCIALSCASHFLOW]); exists {
-		c.logger.Printf("skip [%s] as it already exists in %s.", symbol, SADataTables[SA_FINANCIALSCASHFLOW])
+	if c.isFresh(symbol, SA_FINANCIALSCASHFLOW) {
 		return 0, nil
 	}
-	return c.collectFinancialDetailsCommon(financialsICashFlow, SADataTypes[SA_FINANCIALSCASHFLOW], SADataTables[SA_FINANCIALSCASHFLOW])
+	return c.collectFinancialDetailsCommon(ctx, financialsICashFlow, SADataTypes[SA_FINANCIALSCASHFLOW], SADataTables[SA_FINANCIALSCASHFLOW])
 }
 
-func (c *SACollector) CollectFinancialsRatios(symbol string) (int64, error) {
+func (c *SACollector) CollectFinancialsRatios(ctx context.Context, symbol string) (int64, error) {
 	c.thisSymbol = symbol
-	financialsRatios := "https://stockanalysis.com/stocks/" + strings.ToLower(symbol) + "/financials/ratios/?p=quarterly"
+	financialsRatios := c.baseURL + "/stocks/" + strings.ToLower(symbol) + "/financials/ratios/?p=quarterly"
 
-	if exists, _ := c.symbolExists(symbol, SADataTables[SA_FINANCIALRATIOS]); exists {
-		c.logger.Printf("skip [%s] as it already exists in %s.", symbol, SADataTables[SA_FINANCIALRATIOS])
+	if c.isFresh(symbol, SA_FINANCIALRATIOS) {
 		return 0, nil
 	}
-	return c.collectFinancialDetailsCommon(financialsRatios, SADataTypes[SA_FINANCIALRATIOS], SADataTables[SA_FINANCIALRATIOS])
+	return c.collectFinancialDetailsCommon(ctx, financialsRatios, SADataTypes[SA_FINANCIALRATIOS], SADataTables[SA_FINANCIALRATIOS])
 }
 
-func (c *SACollector) CollectAnalystRatings(symbol string) (int64, error) {
+func (c *SACollector) CollectAnalystRatings(ctx context.Context, symbol string) (int64, error) {
 	c.thisSymbol = symbol
-	url := "https://stockanalysis.com/stocks/" + strings.ToLower(symbol) + "/ratings"
+	url := c.baseURL + "/stocks/" + strings.ToLower(symbol) + "/ratings"
 
-	if exists, _ := c.symbolExists(symbol, SADataTables[SA_ANALYSTSRATING]); exists {
-		c.logger.Printf("skip [%s] as it already exists in %s.", symbol, SADataTables[SA_ANALYSTSRATING])
+	if c.isFresh(symbol, SA_ANALYSTSRATING) {
 		return 0, nil
 	}
 
-	jsonText, err := c.readAnalystRatingsPage(url, nil)
+	jsonText, err := c.readAnalystRatingsPage(ctx, url, nil)
 	if err != nil {
 		httpError, ok := err.(HttpServerError)
 		if ok && httpError.StatusCode() == http.StatusNotFound {
@@ -217,7 +238,7 @@ func (c *SACollector) CollectAnalystRatings(symbol string) (int64, error) {
 		return 0, err
 	}
 
-	numOfRows, err := c.loader.LoadByJsonText(jsonText, SADataTables[SA_ANALYSTSRATING], SADataTypes[SA_ANALYSTSRATING])
+	numOfRows, err := loadByJsonText(ctx, c.loader, jsonText, SADataTables[SA_ANALYSTSRATING], SADataTypes[SA_ANALYSTSRATING])
 	if err != nil {
 		return 0, errors.New("Failed to load data into table " + SADataTables[SA_ANALYSTSRATING] + ". Error: " + err.Error())
 	}
@@ -226,9 +247,9 @@ func (c *SACollector) CollectAnalystRatings(symbol string) (int64, error) {
 	return numOfRows, nil
 }
 
-func (c *SACollector) collectFinancialDetailsCommon(url string, dataStructType reflect.Type, dbTableName string) (int64, error) {
+func (c *SACollector) collectFinancialDetailsCommon(ctx context.Context, url string, dataStructType reflect.Type, dbTableName string) (int64, error) {
 
-	jsonText, err := c.readFinanaceDetailsPage(url, nil, dataStructType.Name())
+	jsonText, numOfRows, err := c.readFinanaceDetailsPage(ctx, url, nil, dataStructType.Name())
 	if err != nil {
 		return 0, err
 	}
@@ -236,11 +257,11 @@ func (c *SACollector) collectFinancialDetailsCommon(url string, dataStructType r
 	// Write the the retrieved data to database
 	rowCount := int64(0)
 	if len(jsonText) > 0 {
-		err := c.exporter.Export(dataStructType, dbTableName, jsonText, c.thisSymbol)
+		err := c.exporter.Export(ctx, dataStructType, dbTableName, jsonText, c.thisSymbol)
 		if err != nil {
 			return 0, errors.New("Failed to load data into table " + dbTableName + ". Error: " + err.Error())
 		}
-
+		rowCount = int64(numOfRows)
 	} else {
 		c.logger.Printf("No data got from %s", url)
 	}
@@ -248,28 +269,11 @@ func (c *SACollector) collectFinancialDetailsCommon(url string, dataStructType r
 	return rowCount, nil
 }
 
-func (c *SACollector) symbolExists(symbol string, table string) (bool, error) {
-	type queryResult struct {
-		Symbol string
-	}
-	querySymbol := fmt.Sprintf("SELECT symbol FROM %s where symbol = '%s'", table, symbol)
-	results, err := c.loader.RunQuery(querySymbol, reflect.TypeFor[queryResult]())
-	if err != nil {
-		return false, errors.New("Failed to run query [" + querySymbol + "]. Error: " + err.Error())
-	}
-	queryResults, ok := results.([]queryResult)
-	if !ok {
-		return false, errors.New("failed to run assert the query results are returned as a slice of queryResults")
-	}
-	return len(queryResults) > 0, nil
-
-}
-
 // Read page from SA and extract the information
-func (c *SACollector) readAnalystRatingsPage(url string, params map[string]string) (string, error) {
+func (c *SACollector) readAnalystRatingsPage(ctx context.Context, url string, params map[string]string) (string, error) {
 	c.logger.Println("Read " + url)
 
-	htmlContent, err := c.reader.Read(url, params)
+	htmlContent, err := c.reader.Read(ctx, url, params)
 	if err != nil {
 		return "", err
 	}
@@ -291,6 +295,7 @@ func (c *SACollector) readAnalystRatingsPage(url string, params map[string]strin
 
 	// Add symbol to the struct if needed
 	c.packSymbolField(indicatorsMap, SADataTypes[SA_ANALYSTSRATING].Name())
+	c.packTimestampFields(indicatorsMap)
 
 	mapSlice := []map[string]interface{}{indicatorsMap}
 	jsonData, err := json.Marshal(mapSlice)
@@ -304,10 +309,10 @@ func (c *SACollector) readAnalystRatingsPage(url string, params map[string]strin
 }
 
 // Read page from SA and extract the information
-func (c *SACollector) readOverviewPage(url string, params map[string]string) (string, error) {
+func (c *SACollector) readOverviewPage(ctx context.Context, url string, params map[string]string) (string, error) {
 	c.logger.Println("Read " + url)
 
-	htmlContent, err := c.reader.Read(url, params)
+	htmlContent, err := c.reader.Read(ctx, url, params)
 	if err != nil {
 		return "", err
 	}
@@ -328,6 +333,7 @@ func (c *SACollector) readOverviewPage(url string, params map[string]string) (st
 
 	// Add symbol to the struct if needed
 	c.packSymbolField(indicatorsMap, SADataTypes[SA_STOCKOVERVIEW].Name())
+	c.packTimestampFields(indicatorsMap)
 
 	mapSlice := []map[string]interface{}{indicatorsMap}
 
@@ -341,45 +347,46 @@ func (c *SACollector) readOverviewPage(url string, params map[string]string) (st
 	return string(jsonData), nil
 }
 
-// Read page from SA and extract the information
-func (c *SACollector) readFinanaceDetailsPage(url string, params map[string]string, dataStructTypeName string) (string, error) {
+// Read page from SA and extract the data points, one per period
+func (c *SACollector) readFinanaceDetailsPage(ctx context.Context, url string, params map[string]string, dataStructTypeName string) (string, int, error) {
 	c.logger.Println("Load data from " + url)
-	htmlContent, err := c.reader.Read(url, params)
+	htmlContent, err := c.reader.Read(ctx, url, params)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 
 	htmlDoc, err := html.Parse(strings.NewReader(htmlContent))
 	if err != nil {
-		return "", errors.New("Failed to parse the html page " + url + ". Error: " + err.Error())
+		return "", 0, errors.New("Failed to parse the html page " + url + ". Error: " + err.Error())
 	}
 
 	// No data avaiable - not an error
 	if searchText(htmlDoc, "No quarterly.*available for this stock") != nil {
-		return "", nil
+		return "", 0, nil
 	}
 
 	indicatorsMap, err := c.htmlParser.DecodeFinancialsPage(htmlDoc, dataStructTypeName)
 	if err != nil {
-		return "", errors.New("Failed to parse " + url + ". Error: " + err.Error())
+		return "", 0, errors.New("Failed to parse " + url + ". Error: " + err.Error())
 	}
 	if len(indicatorsMap) == 0 {
-		return "", errors.New("No indicator found from financials " + url)
+		return "", 0, errors.New("No indicator found from financials " + url)
 	}
 
 	// Add symbol to the struct if needed
 	for _, datapoint := range indicatorsMap {
 		c.packSymbolField(datapoint, dataStructTypeName)
+		c.packTimestampFields(datapoint)
 	}
 
 	jsonData, err := json.Marshal(indicatorsMap)
 	if err != nil {
-		return "", errors.New("Failed to marshal stock data to JSON text. Error: " + err.Error())
+		return "", 0, errors.New("Failed to marshal stock data to JSON text. Error: " + err.Error())
 	} else {
 		c.logger.Println("JSON text generated - " + string(jsonData))
 
 	}
-	return string(jsonData), nil
+	return string(jsonData), len(indicatorsMap), nil
 }
 
 func (c *SACollector) packSymbolField(metrics map[string]interface{}, dataStructTypeName string) {
@@ -391,10 +398,10 @@ func (c *SACollector) packSymbolField(metrics map[string]interface{}, dataStruct
 	}
 }
 
-func (c *SACollector) redirectdSymbol(symbol string) (string, error) {
+func (c *SACollector) redirectdSymbol(ctx context.Context, symbol string) (string, error) {
 	symbol = strings.ToLower(symbol)
-	url := "https://stockanalysis.com/stocks/" + strings.ToLower(symbol) + "/financials/?p=quarterly"
-	redirectedURL, err := c.reader.RedirectedUrl(url)
+	url := c.baseURL + "/stocks/" + strings.ToLower(symbol) + "/financials/?p=quarterly"
+	redirectedURL, err := c.reader.RedirectedUrl(ctx, url)
 	if err != nil {
 		return "", err
 	}
@@ -645,25 +652,26 @@ func searchText(node *html.Node, text string) *html.Node {
 }
 
 // Entry function
-func CollectFinancialsForSymbol(symbol string) error {
+func CollectFinancialsForSymbol(ctx context.Context, cfg *config.Config, symbol string) error {
 	// dbloader
-	dbLoader := dbloader.NewPGLoader(config.SchemaName, sdclogger.SDCLoggerInstance.Logger)
-	dbLoader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
+	dbLoader, err := dbloader.NewPGLoaderByConfig(cfg, sdclogger.SDCLoggerInstance.Logger)
+	if err != nil {
+		return err
+	}
 	defer dbLoader.Disconnect()
 
 	// http reader
-	httpReader := NewHttpReader(NewLocalClient())
+	httpReader := WithHttpCache(NewHttpReader(NewLocalClient(NewClientProfileByConfig(cfg))), cfg)
 
 	// Exporters
 	var saExporter DataExporters
-	saExporter.AddExporter(NewDBExporter(dbLoader, config.SchemaName))
+	saExporter.AddExporter(NewDBExporter(dbLoader, cfg.SchemaName))
 	saExporter.AddExporter(NewSAFileExporter())
 
-	c := NewSACollector(httpReader, &saExporter, dbLoader, sdclogger.SDCLoggerInstance.Logger)
+	c := NewSACollector(httpReader, &saExporter, dbLoader, sdclogger.SDCLoggerInstance.Logger, cfg)
+	if err := c.SelectDatasets(cfg.Financials.Datasets); err != nil {
+		return err
+	}
 
 	if err := c.CreateTables(); err != nil {
 		sdclogger.SDCLoggerInstance.Printf("Failed to create tables. Error: %s", err)
@@ -673,7 +681,7 @@ func CollectFinancialsForSymbol(symbol string) error {
 	}
 
 	// If redirected
-	redirected, err := c.MapRedirectedSymbol(symbol)
+	redirected, err := c.MapRedirectedSymbol(ctx, symbol)
 	if err != nil {
 		e, ok := err.(HttpServerError)
 		if ok && e.StatusCode() == http.StatusNotFound {
@@ -687,7 +695,7 @@ func CollectFinancialsForSymbol(symbol string) error {
 		symbol = redirected
 	}
 
-	if err := c.CollectFinancialDetails(symbol); err != nil {
+	if err := c.CollectFinancialDetails(ctx, symbol); err != nil {
 		return err
 	}
 	fmt.Println("Collect financials for symbol " + symbol)
@@ -1,6 +1,7 @@
 package collector_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/golang/mock/gomock"
@@ -13,12 +14,13 @@ func TestSACollector_MapRedirectedSymbol(t *testing.T) {
 	defer fixture.Teardown(t)
 
 	fixture.DBExpect().LoadByJsonText(
+		gomock.Any(),
 		testcommon.NewStringPatternMatcher(".*meta.*fb.*"),
 		SADataTables[SA_REDIRECTED_SYMBOLS],
 		SADataTypes[SA_REDIRECTED_SYMBOLS]).Times(1)
 
-	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-	got, err := c.MapRedirectedSymbol("fb")
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	got, err := c.MapRedirectedSymbol(context.Background(), "fb")
 	if err != nil {
 		t.Fatalf("Failed to call MapRedirectedSymbol(), error %v", err)
 	}
@@ -28,21 +30,43 @@ func TestSACollector_MapRedirectedSymbol(t *testing.T) {
 	}
 }
 
+func TestSACollector_CreateTables(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// Only the tables of the selected datasets and their dependencies
+	gomock.InOrder(
+		fixture.DBExpect().CreateTableByJsonStruct(SADataTables[SA_REDIRECTED_SYMBOLS], SADataTypes[SA_REDIRECTED_SYMBOLS]),
+		fixture.DBExpect().CreateTableByJsonStruct(SADataTables[SA_ANALYSTSRATING], SADataTypes[SA_ANALYSTSRATING]),
+	)
+
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	if err := c.SelectDatasets([]string{DATASET_RATINGS}); err != nil {
+		t.Fatalf("Failed to call SelectDatasets(), error %v", err)
+	}
+	if err := c.CreateTables(); err != nil {
+		t.Fatalf("Failed to call CreateTables(), error %v", err)
+	}
+}
+
 func TestSACollector_CollectFinancialOverview(t *testing.T) {
 	fixture := testcommon.NewMockTestFixture(t)
 	defer fixture.Teardown(t)
 
 	expectNumOfRows := int64(10)
 	fixture.DBExpect().LoadByJsonText(
+		gomock.Any(),
 		testcommon.NewStringPatternMatcher(".*\"Symbol\":\"msft\"*"),
 		SADataTables[SA_STOCKOVERVIEW],
 		SADataTypes[SA_STOCKOVERVIEW]).Times(1).Return(expectNumOfRows, nil)
 	for _, v := range SADataTables {
-		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("select symbol from "+v+".*"), gomock.Any()).AnyTimes()
+		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("from "+v+" where symbol"), gomock.Any(), gomock.Any()).AnyTimes()
 	}
 
-	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-	num, err := c.CollectFinancialOverview("msft")
+	series := []string{`sdc_rows_loaded_total{table="` + SADataTables[SA_STOCKOVERVIEW] + `"}`}
+	before := metricValues(series)
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	num, err := c.CollectFinancialOverview(context.Background(), "msft")
 	if err != nil {
 		t.Fatalf("Failed to call CollectFinancialOverview(), error %v", err)
 	}
@@ -50,6 +74,9 @@ func TestSACollector_CollectFinancialOverview(t *testing.T) {
 	if num != expectNumOfRows {
 		t.Fatalf("Expecting %d rows were inserted into database table. However %d rows inserted.", expectNumOfRows, num)
 	}
+	if got := metricValues(series)[0] - before[0]; got != float64(expectNumOfRows) {
+		t.Errorf("Expecting %d rows counted in the metrics, got %g", expectNumOfRows, got)
+	}
 }
 
 func TestSACollector_CollectFinancialsIncome(t *testing.T) {
@@ -58,15 +85,16 @@ func TestSACollector_CollectFinancialsIncome(t *testing.T) {
 
 	expectNumOfRows := int64(10)
 	fixture.DBExpect().LoadByJsonText(
+		gomock.Any(),
 		testcommon.NewStringPatternMatcher(".*\"Symbol\":\"msft\"*"),
 		SADataTables[SA_FINANCIALSINCOME],
 		SADataTypes[SA_FINANCIALSINCOME]).Times(1).Return(expectNumOfRows, nil)
 	for _, v := range SADataTables {
-		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("select symbol from "+v+".*"), gomock.Any()).AnyTimes()
+		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("from "+v+" where symbol"), gomock.Any(), gomock.Any()).AnyTimes()
 	}
 
-	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-	num, err := c.CollectFinancialsIncome("msft")
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	num, err := c.CollectFinancialsIncome(context.Background(), "msft")
 	if err != nil {
 		t.Fatalf("Failed to call CollectFinancialsIncome(), error %v", err)
 	}
@@ -82,15 +110,16 @@ func TestSACollector_CollectBalanceSheet(t *testing.T) {
 
 	expectNumOfRows := int64(10)
 	fixture.DBExpect().LoadByJsonText(
+		gomock.Any(),
 		testcommon.NewStringPatternMatcher(".*\"Symbol\":\"msft\"*"),
 		SADataTables[SA_FINANCIALSBALANCESHEET],
 		SADataTypes[SA_FINANCIALSBALANCESHEET]).Times(1).Return(expectNumOfRows, nil)
 	for _, v := range SADataTables {
-		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("select symbol from "+v+".*"), gomock.Any()).AnyTimes()
+		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("from "+v+" where symbol"), gomock.Any(), gomock.Any()).AnyTimes()
 	}
 
-	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-	num, err := c.CollectFinancialsBalanceSheet("msft")
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	num, err := c.CollectFinancialsBalanceSheet(context.Background(), "msft")
 	if err != nil {
 		t.Fatalf("Failed to call CollectBalanceSheet(), error %v", err)
 	}
@@ -106,15 +135,16 @@ func TestSACollector_CollectCashFlow(t *testing.T) {
 
 	expectNumOfRows := int64(10)
 	fixture.DBExpect().LoadByJsonText(
+		gomock.Any(),
 		testcommon.NewStringPatternMatcher(".*\"Symbol\":\"msft\"*"),
 		SADataTables[SA_FINANCIALSCASHFLOW],
 		SADataTypes[SA_FINANCIALSCASHFLOW]).Times(1).Return(expectNumOfRows, nil)
 	for _, v := range SADataTables {
-		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("select symbol from "+v+".*"), gomock.Any()).AnyTimes()
+		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("from "+v+" where symbol"), gomock.Any(), gomock.Any()).AnyTimes()
 	}
 
-	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-	num, err := c.CollectFinancialsCashFlow("msft")
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	num, err := c.CollectFinancialsCashFlow(context.Background(), "msft")
 	if err != nil {
 		t.Fatalf("Failed to call CollectCashFlow(), error %v", err)
 	}
@@ -130,15 +160,16 @@ func TestSACollector_CollectRatios(t *testing.T) {
 
 	expectNumOfRows := int64(10)
 	fixture.DBExpect().LoadByJsonText(
+		gomock.Any(),
 		testcommon.NewStringPatternMatcher(".*\"Symbol\":\"msft\"*"),
 		SADataTables[SA_FINANCIALRATIOS],
 		SADataTypes[SA_FINANCIALRATIOS]).Times(1).Return(expectNumOfRows, nil)
 	for _, v := range SADataTables {
-		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("select symbol from "+v+".*"), gomock.Any()).AnyTimes()
+		fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher("from "+v+" where symbol"), gomock.Any(), gomock.Any()).AnyTimes()
 	}
 
-	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-	num, err := c.CollectFinancialsRatios("msft")
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	num, err := c.CollectFinancialsRatios(context.Background(), "msft")
 	if err != nil {
 		t.Fatalf("Failed to call CollectRatios(), error %v", err)
 	}
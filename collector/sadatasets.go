@@ -0,0 +1,144 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Datasets collected for a symbol by the financials pipeline. Each
+// (symbol, dataset) pair is a unit of work, queued, retried and reported on
+// its own.
+const (
+	DATASET_REDIRECT      = "redirect"
+	DATASET_OVERVIEW      = "overview"
+	DATASET_INCOME        = "income"
+	DATASET_BALANCE_SHEET = "balance_sheet"
+	DATASET_CASH_FLOW     = "cash_flow"
+	DATASET_RATIOS        = "ratios"
+	DATASET_RATINGS       = "ratings"
+)
+
+// Separates the symbol and the dataset of a work unit, e.g. MSFT/income
+const WORK_UNIT_SEPARATOR = "/"
+
+type SADataset struct {
+	Name string
+	// Dataset collected before this one. The units of the dataset are
+	// queued once the unit of the dependency succeeds for the symbol.
+	DependsOn string
+	// Key of the table in SADataTables and SADataTypes
+	Table string
+	// Collect the dataset and return the symbol for the dependent datasets
+	Collect func(ctx context.Context, c *SACollector, symbol string) (string, error)
+}
+
+// The redirect mapping comes first, since the other pages are collected
+// under the symbol the stock is redirected to.
+var SADatasets = []SADataset{
+	{DATASET_REDIRECT, "", SA_REDIRECTED_SYMBOLS, collectRedirect},
+	{DATASET_OVERVIEW, DATASET_REDIRECT, SA_STOCKOVERVIEW, collectBy((*SACollector).CollectFinancialOverview)},
+	{DATASET_INCOME, DATASET_REDIRECT, SA_FINANCIALSINCOME, collectBy((*SACollector).CollectFinancialsIncome)},
+	{DATASET_BALANCE_SHEET, DATASET_REDIRECT, SA_FINANCIALSBALANCESHEET, collectBy((*SACollector).CollectFinancialsBalanceSheet)},
+	{DATASET_CASH_FLOW, DATASET_REDIRECT, SA_FINANCIALSCASHFLOW, collectBy((*SACollector).CollectFinancialsCashFlow)},
+	{DATASET_RATIOS, DATASET_REDIRECT, SA_FINANCIALRATIOS, collectBy((*SACollector).CollectFinancialsRatios)},
+	{DATASET_RATINGS, DATASET_REDIRECT, SA_ANALYSTSRATING, collectBy((*SACollector).CollectAnalystRatings)},
+}
+
+func collectRedirect(ctx context.Context, c *SACollector, symbol string) (string, error) {
+	redirected, err := c.MapRedirectedSymbol(ctx, symbol)
+	if err != nil {
+		return "", err
+	}
+	if len(redirected) > 0 {
+		c.logger.Printf("Symbol %s is redirected to %s", symbol, redirected)
+		return redirected, nil
+	}
+	return symbol, nil
+}
+
+func collectBy(collect func(c *SACollector, ctx context.Context, symbol string) (int64, error)) func(ctx context.Context, c *SACollector, symbol string) (string, error) {
+	return func(ctx context.Context, c *SACollector, symbol string) (string, error) {
+		if _, err := collect(c, ctx, symbol); err != nil {
+			return "", err
+		}
+		return symbol, nil
+	}
+}
+
+func WorkUnit(symbol string, dataset string) string {
+	return symbol + WORK_UNIT_SEPARATOR + dataset
+}
+
+// Split the work unit into the symbol and the dataset. The dataset is empty
+// for a unit of the symbol as a whole.
+func ParseWorkUnit(unit string) (string, string) {
+	symbol, dataset, _ := strings.Cut(unit, WORK_UNIT_SEPARATOR)
+	return symbol, dataset
+}
+
+// The dataset of the given name. A unit without dataset starts the pipeline
+// of the symbol, so that the symbols loaded to the queue start from the
+// first dataset.
+func SADatasetOf(name string) (SADataset, bool) {
+	if len(name) == 0 {
+		return SADatasets[0], true
+	}
+	for _, d := range SADatasets {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return SADataset{}, false
+}
+
+// Datasets to be collected once the given dataset is collected
+func SADependents(name string) []string {
+	return dependentsOf(SADatasets, name)
+}
+
+func dependentsOf(datasets []SADataset, name string) []string {
+	var dependents []string
+	for _, d := range datasets {
+		if d.DependsOn == name && len(name) > 0 {
+			dependents = append(dependents, d.Name)
+		}
+	}
+	return dependents
+}
+
+// The datasets of the given names with the datasets they depend on, in the
+// order of SADatasets. All datasets are selected when no name is given.
+func SelectSADatasets(names []string) ([]SADataset, error) {
+	if len(names) == 0 {
+		return SADatasets, nil
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range names {
+		for len(name) > 0 && !selected[name] {
+			d, ok := SADatasetOf(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown dataset %s, expecting one of %s", name, strings.Join(SADatasetNames(), ","))
+			}
+			selected[name] = true
+			name = d.DependsOn
+		}
+	}
+
+	var datasets []SADataset
+	for _, d := range SADatasets {
+		if selected[d.Name] {
+			datasets = append(datasets, d)
+		}
+	}
+	return datasets, nil
+}
+
+func SADatasetNames() []string {
+	names := make([]string, 0, len(SADatasets))
+	for _, d := range SADatasets {
+		names = append(names, d.Name)
+	}
+	return names
+}
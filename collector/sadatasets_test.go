@@ -0,0 +1,75 @@
+package collector_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/wayming/sdc/collector"
+)
+
+func TestParseWorkUnit(t *testing.T) {
+	tests := []struct {
+		unit    string
+		symbol  string
+		dataset string
+	}{
+		{"msft", "msft", ""},
+		{WorkUnit("msft", DATASET_INCOME), "msft", DATASET_INCOME},
+		{"brk.b/ratings", "brk.b", DATASET_RATINGS},
+	}
+	for _, tt := range tests {
+		symbol, dataset := ParseWorkUnit(tt.unit)
+		if symbol != tt.symbol || dataset != tt.dataset {
+			t.Errorf("ParseWorkUnit(%s) = %s, %s, want %s, %s", tt.unit, symbol, dataset, tt.symbol, tt.dataset)
+		}
+	}
+}
+
+func TestSADatasets_Dependencies(t *testing.T) {
+	// Symbols queued without dataset start from the redirect mapping
+	if d, ok := SADatasetOf(""); !ok || d.Name != DATASET_REDIRECT {
+		t.Errorf("SADatasetOf(\"\") = %s, %v, want %s", d.Name, ok, DATASET_REDIRECT)
+	}
+	if _, ok := SADatasetOf("unknown"); ok {
+		t.Errorf("SADatasetOf(unknown) expecting not found")
+	}
+
+	want := []string{DATASET_OVERVIEW, DATASET_INCOME, DATASET_BALANCE_SHEET, DATASET_CASH_FLOW, DATASET_RATIOS, DATASET_RATINGS}
+	if got := SADependents(DATASET_REDIRECT); !reflect.DeepEqual(got, want) {
+		t.Errorf("SADependents(%s) = %v, want %v", DATASET_REDIRECT, got, want)
+	}
+	if got := SADependents(DATASET_INCOME); len(got) != 0 {
+		t.Errorf("SADependents(%s) = %v, want none", DATASET_INCOME, got)
+	}
+	if got := SADependents(""); len(got) != 0 {
+		t.Errorf("SADependents(\"\") = %v, want none", got)
+	}
+}
+
+func TestSelectSADatasets(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{nil, []string{DATASET_REDIRECT, DATASET_OVERVIEW, DATASET_INCOME, DATASET_BALANCE_SHEET, DATASET_CASH_FLOW, DATASET_RATIOS, DATASET_RATINGS}},
+		{[]string{DATASET_RATINGS, DATASET_RATIOS}, []string{DATASET_REDIRECT, DATASET_RATIOS, DATASET_RATINGS}},
+		{[]string{DATASET_REDIRECT}, []string{DATASET_REDIRECT}},
+	}
+	for _, tt := range tests {
+		datasets, err := SelectSADatasets(tt.names)
+		if err != nil {
+			t.Fatalf("SelectSADatasets(%v) error = %v", tt.names, err)
+		}
+		var got []string
+		for _, d := range datasets {
+			got = append(got, d.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SelectSADatasets(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+
+	if _, err := SelectSADatasets([]string{DATASET_RATIOS, "dividends"}); err == nil {
+		t.Errorf("SelectSADatasets() expecting error for unknown dataset")
+	}
+}
@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"errors"
+	"reflect"
+	"time"
+)
+
+// Freshness of the rows of a symbol in a SA table. Timestamps never set are
+// returned as the epoch.
+type SAFreshness struct {
+	Total        int64
+	UpdatedAt    time.Time
+	PeriodEnding time.Time
+}
+
+// Whether the data should be collected again. Data never collected, or
+// collected before the timestamps were recorded, is always stale. Data older
+// than the max age is stale. Statements are stale once the filing lag passed
+// since the end of the quarter after the last quarter collected, unless they
+// were collected after that.
+func (f SAFreshness) Stale(now time.Time, maxAge time.Duration, filingLag time.Duration) bool {
+	if f.Total == 0 || !isSet(f.UpdatedAt) {
+		return true
+	}
+	if maxAge > 0 && now.Sub(f.UpdatedAt) >= maxAge {
+		return true
+	}
+	if filingLag > 0 && isSet(f.PeriodEnding) {
+		expected := f.PeriodEnding.AddDate(0, 3, 0).Add(filingLag)
+		if !now.Before(expected) && f.UpdatedAt.Before(expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSet(t time.Time) bool {
+	return t.After(time.Unix(0, 0))
+}
+
+// Freshness of the symbol in the table of the given key in SADataTables
+func (c *SACollector) freshness(symbol string, table string) (SAFreshness, error) {
+	sql := "select count(*) as total, coalesce(max(updatedat), 'epoch') as updatedat"
+	if _, ok := SADataTypes[table].FieldByName("PeriodEnding"); ok {
+		sql += ", coalesce(max(periodending), 'epoch') as periodending"
+	}
+	sql += " from " + SADataTables[table] + " where symbol = $1"
+
+	results, err := c.loader.RunQuery(sql, reflect.TypeFor[SAFreshness](), symbol)
+	if err != nil {
+		return SAFreshness{}, errors.New("Failed to run query [" + sql + "]. Error: " + err.Error())
+	}
+	rows, ok := results.([]SAFreshness)
+	if !ok || len(rows) == 0 {
+		return SAFreshness{}, errors.New("failed to assert the query results are returned as a slice of SAFreshness")
+	}
+	return rows[0], nil
+}
+
+// Whether collecting the symbol into the table can be skipped. The data is
+// collected when its freshness is unknown.
+func (c *SACollector) isFresh(symbol string, table string) bool {
+	if c.policy.Force {
+		return false
+	}
+	var name string
+	for _, d := range c.datasets {
+		if d.Table == table {
+			name = d.Name
+		}
+	}
+
+	f, err := c.freshness(symbol, table)
+	if err != nil {
+		c.logger.Printf("Collect [%s] as the freshness in %s is unknown. Error: %v", symbol, SADataTables[table], err)
+		return false
+	}
+	if f.Stale(time.Now(), c.policy.MaxAge[name], c.policy.FilingLag) {
+		return false
+	}
+	c.logger.Printf("skip [%s] as it was updated in %s at %s.", symbol, SADataTables[table], f.UpdatedAt.Format(time.RFC3339))
+	return true
+}
+
+// Stamp the rows with the time collected. The collected time of the rows
+// already in the database is kept.
+func (c *SACollector) packTimestampFields(metrics map[string]interface{}) {
+	now := time.Now().UTC()
+	metrics["collected_at"] = now
+	metrics["updated_at"] = now
+}
@@ -0,0 +1,88 @@
+package collector_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/wayming/sdc/collector"
+	testcommon "github.com/wayming/sdc/testcommon"
+)
+
+func TestSAFreshness_Stale(t *testing.T) {
+	epoch := time.Unix(0, 0).UTC()
+	now := time.Date(2024, 7, 30, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name      string
+		freshness SAFreshness
+		maxAge    time.Duration
+		want      bool
+	}{
+		{"NeverCollected", SAFreshness{0, epoch, epoch}, 0, true},
+		{"CollectedBeforeTimestamps", SAFreshness{4, epoch, epoch}, 0, true},
+		{"Fresh", SAFreshness{1, now.Add(-time.Hour), epoch}, day, false},
+		{"OlderThanMaxAge", SAFreshness{1, now.Add(-2 * day), epoch}, day, true},
+		{"NoMaxAge", SAFreshness{1, now.AddDate(-1, 0, 0), epoch}, 0, false},
+		// Quarter after the one ended on 2024-03-31 is expected by 2024-08-15
+		{"NextQuarterNotExpected", SAFreshness{8, now.AddDate(0, 0, -60), time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)}, 0, false},
+		// Quarter after the one ended on 2023-12-31 is expected by 2024-05-15
+		{"NextQuarterExpected", SAFreshness{8, now.AddDate(0, 0, -90), time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)}, 0, true},
+		{"NextQuarterCollectedSince", SAFreshness{8, now.AddDate(0, 0, -30), time.Date(2023, 12, 31, 0, 0, 0, 0, time.UTC)}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.freshness.Stale(now, tt.maxAge, 45*day); got != tt.want {
+				t.Errorf("SAFreshness.Stale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSACollector_CollectAnalystRatings_Fresh(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// Ratings updated an hour ago are not collected again
+	fixture.DBExpect().
+		RunQuery(testcommon.NewStringPatternMatcher("from "+SADataTables[SA_ANALYSTSRATING]+" where symbol"), reflect.TypeFor[SAFreshness](), "msft").
+		Return([]SAFreshness{{Total: 1, UpdatedAt: time.Now().Add(-time.Hour)}}, nil).
+		Times(1)
+	fixture.DBExpect().LoadByJsonText(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	num, err := c.CollectAnalystRatings(context.Background(), "msft")
+	if err != nil {
+		t.Fatalf("Failed to call CollectAnalystRatings(), error %v", err)
+	}
+	if num != 0 {
+		t.Errorf("Expecting no rows loaded for fresh ratings, got %d", num)
+	}
+}
+
+func TestSACollector_CollectAnalystRatings_Offline(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// Pages read offline are parsed again whatever the freshness
+	fixture.DBExpect().
+		RunQuery(testcommon.NewStringPatternMatcher("from "+SADataTables[SA_ANALYSTSRATING]+" where symbol"), gomock.Any(), gomock.Any()).
+		Times(0)
+	fixture.DBExpect().
+		LoadByJsonText(gomock.Any(), gomock.Any(), SADataTables[SA_ANALYSTSRATING], SADataTypes[SA_ANALYSTSRATING]).
+		Return(int64(1), nil).
+		Times(1)
+
+	cfg := *fixture.Config()
+	cfg.HTTPCache.Offline = true
+	c := NewSACollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), &cfg)
+	num, err := c.CollectAnalystRatings(context.Background(), "msft")
+	if err != nil {
+		t.Fatalf("Failed to call CollectAnalystRatings(), error %v", err)
+	}
+	if num != 1 {
+		t.Errorf("Expecting the ratings loaded offline, got %d rows", num)
+	}
+}
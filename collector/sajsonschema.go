@@ -2,6 +2,7 @@ package collector
 
 import (
 	"reflect"
+	"time"
 
 	"github.com/wayming/sdc/json2db"
 )
@@ -14,34 +15,41 @@ const SA_FINANCIALSCASHFLOW = "SAFinancialsCashFlow"
 const SA_FINANCIALRATIOS = "SAFinancialRatios"
 const SA_ANALYSTSRATING = "SAAnalystsRating"
 
+// Columns of the SA tables are the lowercased field names. The timestamps of
+// the rows are stored in collectedat and updatedat, loaded from the
+// collected_at and updated_at JSON fields.
 type RedirectedSymbols struct {
-	Symbol           string `json:"symbol" db:"PrimaryKey"`
-	RedirectedSymbol string `json:"redirected_symbol"`
+	Symbol           string    `json:"symbol" db:"PrimaryKey"`
+	RedirectedSymbol string    `json:"redirected_symbol"`
+	CollectedAt      time.Time `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 type StockOverview struct {
-	FiftyTwoWeekRange string  `json:"52_week_range"`
-	Analysts          string  `json:"analysts"`
-	Beta              float64 `json:"beta"`
-	DaysRange         string  `json:"days_range"`
-	Dividend          string  `json:"dividend"`
-	EarningsDate      string  `json:"earnings_date"`
-	EPSTTM            float64 `json:"eps_ttm"`
-	ExDividendDate    string  `json:"ex_dividend_date"`
-	ForwardPE         float64 `json:"forward_pe"`
-	MarketCap         float64 `json:"market_cap"`
-	NetIncomeTTM      string  `json:"net_income_ttm"`
-	Open              float64 `json:"open"`
-	PERatio           float64 `json:"pe_ratio"`
-	PreviousClose     float64 `json:"previous_close"`
-	PriceTarget       float64 `json:"price_target"`
-	RevenueTTM        float64 `json:"revenue_ttm"`
-	SharesOut         float64 `json:"shares_out"`
-	Symbol            string  `json:"symbol" db:"PrimaryKey"`
-	Volume            float64 `json:"volume"`
-	Revenue           float64 `json:"revenue"`
-	NetIncome         float64 `json:"net_income"`
-	EPS               float64 `json:"eps"`
+	FiftyTwoWeekRange string    `json:"52_week_range"`
+	Analysts          string    `json:"analysts"`
+	Beta              float64   `json:"beta"`
+	DaysRange         string    `json:"days_range"`
+	Dividend          string    `json:"dividend"`
+	EarningsDate      string    `json:"earnings_date"`
+	EPSTTM            float64   `json:"eps_ttm"`
+	ExDividendDate    string    `json:"ex_dividend_date"`
+	ForwardPE         float64   `json:"forward_pe"`
+	MarketCap         float64   `json:"market_cap"`
+	NetIncomeTTM      string    `json:"net_income_ttm"`
+	Open              float64   `json:"open"`
+	PERatio           float64   `json:"pe_ratio"`
+	PreviousClose     float64   `json:"previous_close"`
+	PriceTarget       float64   `json:"price_target"`
+	RevenueTTM        float64   `json:"revenue_ttm"`
+	SharesOut         float64   `json:"shares_out"`
+	Symbol            string    `json:"symbol" db:"PrimaryKey"`
+	Volume            float64   `json:"volume"`
+	Revenue           float64   `json:"revenue"`
+	NetIncome         float64   `json:"net_income"`
+	EPS               float64   `json:"eps"`
+	CollectedAt       time.Time `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt         time.Time `json:"updated_at"`
 }
 
 type FinancialsIncome struct {
@@ -182,6 +190,8 @@ type FinancialsIncome struct {
 	ReinsuranceIncomeOrExpense               float64      `json:"reinsurance_income_or_expense"`
 	TenantReimbursements                     float64      `json:"tenant_reimbursements"`
 	NonInsuranceActivitiesExpense            float64      `json:"non_insurance_activities_expense"`
+	CollectedAt                              time.Time    `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt                                time.Time    `json:"updated_at"`
 }
 
 type FinancialsBalanceSheet struct {
@@ -306,6 +316,8 @@ type FinancialsBalanceSheet struct {
 	TotalRealEstateAssets                  float64      `json:"total_real_estate_assets"`
 	DeferredLongTermTaxAssets              float64      `json:"deferred_long_term_tax_assets"`
 	NetNuclearFuel                         float64      `json:"net_nuclear_fuel"`
+	CollectedAt                            time.Time    `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt                              time.Time    `json:"updated_at"`
 }
 
 type FinancialsCashFlow struct {
@@ -441,6 +453,8 @@ type FinancialsCashFlow struct {
 	NetCashFromDiscontinuedOperations                 float64      `json:"net_cash_from_discontinued_operations"`
 	RestructuringActivities                           float64      `json:"restructuring_activities"`
 	CashAcquisition                                   float64      `json:"cash_acquisition"`
+	CollectedAt                                       time.Time    `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt                                         time.Time    `json:"updated_at"`
 }
 
 type FinancialRatios struct {
@@ -479,14 +493,18 @@ type FinancialRatios struct {
 	ReturnOnEquityROE      float64      `json:"return_on_equity_roe"`
 	Symbol                 string       `json:"symbol" db:"PrimaryKey"`
 	TotalShareholderReturn float64      `json:"total_shareholder_return"`
+	CollectedAt            time.Time    `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt              time.Time    `json:"updated_at"`
 }
 
 type AnalystsRating struct {
-	Symbol          string  `json:"symbol" db:"PrimaryKey"`
-	TotalAnalysts   int64   `json:"total_analysts"`
-	ConsensusRating string  `json:"consensus_rating"`
-	PriceTarget     float64 `json:"price_target"`
-	Upside          float64 `json:"upside"`
+	Symbol          string    `json:"symbol" db:"PrimaryKey"`
+	TotalAnalysts   int64     `json:"total_analysts"`
+	ConsensusRating string    `json:"consensus_rating"`
+	PriceTarget     float64   `json:"price_target"`
+	Upside          float64   `json:"upside"`
+	CollectedAt     time.Time `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt       time.Time `json:"updated_at"`
 }
 
 func AllSAMetricsFields() map[string]map[string]JsonFieldMetadata {
@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/dbloader"
+)
+
+// Score of the symbols of the watchlist, above any market cap
+const QUEUE_SCORE_WATCHLIST = 1e15
+
+// Scores symbols queued, so that symbols of higher scores are processed
+// first. Symbols of the watchlist are scored above any other symbol, and
+// symbols are scored by market cap if the queue priority is market_cap.
+type SymbolScorer struct {
+	watchlist  map[string]bool
+	marketCaps map[string]float64
+}
+
+// Scorer of the queue priority and watchlist of the config. Symbols are
+// scored by the market caps collected in sa_stockoverview. Market caps not
+// available are logged and the symbols are scored by the watchlist only.
+func NewSymbolScorer(db dbloader.DBLoader, logger *log.Logger, cfg config.QueueConfig) (*SymbolScorer, error) {
+	s := &SymbolScorer{map[string]bool{}, map[string]float64{}}
+	if len(cfg.Watchlist) > 0 {
+		if err := s.loadWatchlist(cfg.Watchlist); err != nil {
+			return nil, err
+		}
+		logger.Printf("%d symbols loaded from watchlist %s", len(s.watchlist), cfg.Watchlist)
+	}
+	if cfg.Priority == config.QUEUE_PRIORITY_MARKET_CAP {
+		if err := s.loadMarketCaps(db); err != nil {
+			logger.Printf("Symbols are not scored by market cap. Error: %v", err)
+		} else {
+			logger.Printf("%d market caps loaded from %s", len(s.marketCaps), SADataTables[SA_STOCKOVERVIEW])
+		}
+	}
+	return s, nil
+}
+
+// Lines are symbols. Empty lines and lines starting with # are ignored.
+func (s *SymbolScorer) loadWatchlist(fname string) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return errors.New("Failed to open watchlist " + fname + ". Error: " + err.Error())
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		symbol := strings.TrimSpace(scanner.Text())
+		if len(symbol) == 0 || strings.HasPrefix(symbol, "#") {
+			continue
+		}
+		s.watchlist[strings.ToLower(symbol)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.New("Failed to read watchlist " + fname + ". Error: " + err.Error())
+	}
+	return nil
+}
+
+func (s *SymbolScorer) loadMarketCaps(db dbloader.DBLoader) error {
+	type queryResult struct {
+		Symbol    string
+		MarketCap float64
+	}
+
+	sql := "select symbol, coalesce(max(marketcap), 0) as marketcap from " + SADataTables[SA_STOCKOVERVIEW] + " group by symbol"
+	results, err := db.RunQuery(sql, reflect.TypeFor[queryResult]())
+	if err != nil {
+		return errors.New("Failed to run query [" + sql + "]. Error: " + err.Error())
+	}
+	rows, ok := results.([]queryResult)
+	if !ok {
+		return errors.New("failed to assert the slice of queryResults")
+	}
+	for _, row := range rows {
+		s.marketCaps[strings.ToLower(row.Symbol)] = row.MarketCap
+	}
+	return nil
+}
+
+// Score of the symbol of the work unit. Zero if the symbol has no priority.
+func (s *SymbolScorer) Score(unit string) float64 {
+	symbol, _ := ParseWorkUnit(unit)
+	symbol = strings.ToLower(symbol)
+	if s.watchlist[symbol] {
+		return QUEUE_SCORE_WATCHLIST + s.marketCaps[symbol]
+	}
+	return s.marketCaps[symbol]
+}
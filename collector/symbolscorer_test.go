@@ -0,0 +1,60 @@
+package collector_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+	testcommon "github.com/wayming/sdc/testcommon"
+)
+
+func TestSymbolScorer_Score(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	watchlist := filepath.Join(t.TempDir(), "watchlist.txt")
+	if err := os.WriteFile(watchlist, []byte("# Holdings\nIBM\n\n"), 0644); err != nil {
+		t.Fatalf("Failed to write watchlist %s. Error: %v", watchlist, err)
+	}
+
+	fixture.DBExpect().
+		RunQuery(testcommon.NewStringPatternMatcher("marketcap.* from sa_stockoverview"), gomock.Any()).
+		DoAndReturn(func(sql string, rtype reflect.Type, args ...any) (any, error) {
+			results := reflect.MakeSlice(reflect.SliceOf(rtype), 0, 2)
+			for symbol, cap := range map[string]float64{"MSFT": 3e12, "ibm": 2e11} {
+				row := reflect.New(rtype).Elem()
+				row.Field(0).SetString(symbol)
+				row.Field(1).SetFloat(cap)
+				results = reflect.Append(results, row)
+			}
+			return results.Interface(), nil
+		}).
+		Times(1)
+
+	cfg := config.QueueConfig{Priority: config.QUEUE_PRIORITY_MARKET_CAP, Watchlist: watchlist}
+	s, err := NewSymbolScorer(fixture.DBMock(), fixture.Logger(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to call NewSymbolScorer(), error %v", err)
+	}
+
+	tests := []struct {
+		unit string
+		want float64
+	}{
+		{"msft", 3e12},
+		{WorkUnit("MSFT", DATASET_OVERVIEW), 3e12},
+		{"ibm", QUEUE_SCORE_WATCHLIST + 2e11},
+		{"zm", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unit, func(t *testing.T) {
+			if got := s.Score(tt.unit); got != tt.want {
+				t.Errorf("SymbolScorer.Score() = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
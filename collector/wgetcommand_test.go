@@ -1,6 +1,8 @@
 package collector
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -31,6 +33,16 @@ func Test_getHttpCode(t *testing.T) {
 }
 
 func TestWgetCmd_RedirectedUrl(t *testing.T) {
+	// Redirects of the renamed symbols, as served by stockanalysis.com
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stocks/fb/financials/" {
+			http.Redirect(w, r, "/stocks/meta/financials/?period=quarterly", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
 	tests := []struct {
 		name    string
 		url     string
@@ -39,7 +51,7 @@ func TestWgetCmd_RedirectedUrl(t *testing.T) {
 	}{
 		{
 			name:    "TestWgetCmd_RedirectedUrl",
-			url:     "https://stockanalysis.com/stocks/fb/financials/?p=quarterly",
+			url:     srv.URL + "/stocks/fb/financials/?p=quarterly",
 			want:    "/stocks/meta/financials/?period=quarterly",
 			wantErr: false,
 		},
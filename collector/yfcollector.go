@@ -1,6 +1,7 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/dbloader"
@@ -22,25 +24,35 @@ type YFCollector struct {
 	exporters IDataExporter
 	db        dbloader.DBLoader
 	logger    *log.Logger
+	baseURL   string
+	eod       config.EODConfig
 }
 
-func NewYFCollector(httpReader IHttpReader, exporters IDataExporter, db dbloader.DBLoader, l *log.Logger) *YFCollector {
+// First day of the bars downloaded by a full EOD load
+const EOD_START_DATE = "2000-01-01"
+
+func NewYFCollector(httpReader IHttpReader, exporters IDataExporter, db dbloader.DBLoader, l *log.Logger, cfg *config.Config) *YFCollector {
 	logger := l
 	if logger == nil {
 		logger = sdclogger.SDCLoggerInstance.Logger
 	}
+	if cfg == nil {
+		cfg = config.NewConfig()
+	}
 	return &YFCollector{
 		reader:    httpReader,
 		exporters: exporters,
 		db:        db,
 		logger:    logger,
+		baseURL:   cfg.Endpoints.OpenBB,
+		eod:       cfg.EOD,
 	}
 }
 
-func (c *YFCollector) Tickers() error {
-	apiURL := "http://openbb:8001/api/v1/equity/search?provider=nasdaq&is_symbol=true&use_cache=true&active=true&is_etf=false&is_fund=false"
+func (c *YFCollector) Tickers(ctx context.Context) error {
+	apiURL := c.baseURL + "/api/v1/equity/search?provider=nasdaq&is_symbol=true&use_cache=true&active=true&is_etf=false&is_fund=false"
 
-	textJSON, err := c.reader.Read(apiURL, nil)
+	textJSON, err := c.reader.Read(ctx, apiURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to load data from %s: %v ", apiURL, err)
 	}
@@ -59,20 +71,70 @@ func (c *YFCollector) Tickers() error {
 		return err
 	}
 
-	if err := c.exporters.Export(YFDataTypes[YF_TICKERS], strings.ToLower(YFDataTables[YF_TICKERS]), dataText, ""); err != nil {
+	if err := c.exporters.Export(ctx, YFDataTypes[YF_TICKERS], strings.ToLower(YFDataTables[YF_TICKERS]), dataText, ""); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c *YFCollector) EODForSymbol(symbol string) error {
-	baseURL := "http://openbb:8001/api/v1/equity/price/historical"
+// Download the bars of the symbol since the last bar stored, going back the
+// overlap to catch corrections, and upsert them. All bars are downloaded
+// when none is stored, in full mode, or when a split is found in the new
+// bars since the bars stored are adjusted by the splits.
+func (c *YFCollector) EODForSymbol(ctx context.Context, symbol string) error {
+	c.logger.Println("Load EDO for symbool", symbol)
+
+	tableName := strings.ToLower(YFDataTables[YF_EOD] + "_" + symbol)
+	startDate := EOD_START_DATE
+	last, err := c.lastEODDate(tableName)
+	if err != nil {
+		return err
+	}
+	incremental := !c.eod.Full && isSet(last)
+	if incremental {
+		startDate = last.Add(-c.eod.Overlap).Format("2006-01-02")
+	}
+
+	bars, err := c.readEOD(ctx, symbol, startDate)
+	if err != nil {
+		return err
+	}
+	if incremental && splitSince(bars, last) {
+		c.logger.Printf("Split of %s found since %s, reload all bars.", symbol, last.Format("2006-01-02"))
+		if bars, err = c.readEOD(ctx, symbol, EOD_START_DATE); err != nil {
+			return err
+		}
+	}
+
+	if len(bars) > 0 {
+		if err := c.db.CreateTableByJsonStruct(tableName, YFDataTypes[YF_EOD]); err != nil {
+			return err
+		}
+		dataText, err := json.Marshal(bars)
+		if err != nil {
+			return errors.New("Failed to marshal EOD of " + symbol + ", Error: " + err.Error())
+		}
+		if err := c.exporters.Export(ctx, YFDataTypes[YF_EOD], tableName, string(dataText), symbol); err != nil {
+			return err
+		}
+		c.logger.Printf("Successfully loaded %d EOD rows since %s to %s", len(bars), startDate, tableName)
+	} else {
+		c.logger.Printf("No data found for %s since %s", symbol, startDate)
+	}
+
+	return nil
+}
+
+// Bars of the symbol since the start date. No bar is returned for symbols
+// unknown to the server.
+func (c *YFCollector) readEOD(ctx context.Context, symbol string, startDate string) ([]YFEOD, error) {
+	baseURL := c.baseURL + "/api/v1/equity/price/historical"
 	params := map[string]string{
 		"chart":           "false",
 		"provider":        "yfinance",
 		"interval":        "1d",
-		"start_date":      "2000-01-01",
+		"start_date":      startDate,
 		"adjustment":      "splits_only",
 		"extended_hours":  "false",
 		"adjusted":        "false",
@@ -85,44 +147,73 @@ func (c *YFCollector) EODForSymbol(symbol string) error {
 		"prepost":         "false",
 	}
 
-	c.logger.Println("Load EDO for symbool", symbol)
-
 	params["symbol"] = symbol
-	textJSON, err := c.reader.Read(baseURL, params)
+	textJSON, err := c.reader.Read(ctx, baseURL, params)
 	if err != nil {
 		if serverError, ok := err.(HttpServerError); ok {
 			if serverError.status == http.StatusBadRequest {
 				c.logger.Printf("No data found for %s, continue processing.", symbol)
-				return nil
+				return nil, nil
 			}
 		}
-		return errors.New("Failed to load data from url " + baseURL + ", Error: " + err.Error())
+		// Wrapped for the worker to tell transport errors of the proxy
+		return nil, fmt.Errorf("Failed to load data from url %s, Error: %w", baseURL, err)
 	}
 	c.logger.Printf("EOD received:\n%s", textJSON)
 
-	dataText, err := ExtractData(textJSON, reflect.TypeFor[YFEODResponse]())
+	var response YFEODResponse
+	if err := json.Unmarshal([]byte(textJSON), &response); err != nil {
+		return nil, errors.New("Failed to unmarshal json text, Error: " + err.Error())
+	}
+	return response.Results, nil
+}
+
+// Date of the last bar stored in the table, or the epoch if none. Tables are
+// created with the first bars of the symbols.
+func (c *YFCollector) lastEODDate(tableName string) (time.Time, error) {
+	type tableResult struct {
+		Found bool
+	}
+	type queryResult struct {
+		Date time.Time
+	}
+
+	sql := "select to_regclass($1) is not null as found"
+	tables, err := c.db.RunQuery(sql, reflect.TypeFor[tableResult](), tableName)
 	if err != nil {
-		return err
+		return time.Time{}, errors.New("Failed to run query [" + sql + "]. Error: " + err.Error())
+	}
+	tableResults, ok := tables.([]tableResult)
+	if !ok || len(tableResults) == 0 {
+		return time.Time{}, errors.New("failed to assert the slice of tableResults")
+	}
+	if !tableResults[0].Found {
+		return time.Unix(0, 0).UTC(), nil
 	}
 
-	if len(dataText) > 0 {
-		tableName := strings.ToLower(YFDataTables[YF_EOD] + "_" + symbol)
-		if err := c.db.CreateTableByJsonStruct(tableName, YFDataTypes[YF_EOD]); err != nil {
-			return err
-		}
+	sql = "select coalesce(max(date), 'epoch') as date from " + tableName
+	results, err := c.db.RunQuery(sql, reflect.TypeFor[queryResult]())
+	if err != nil {
+		return time.Time{}, errors.New("Failed to run query [" + sql + "]. Error: " + err.Error())
+	}
+	queryResults, ok := results.([]queryResult)
+	if !ok || len(queryResults) == 0 {
+		return time.Time{}, errors.New("failed to assert the slice of queryResults")
+	}
+	return queryResults[0].Date, nil
+}
 
-		if err := c.exporters.Export(YFDataTypes[YF_EOD], tableName, dataText, symbol); err != nil {
-			return err
+// Whether a split happened after the given date
+func splitSince(bars []YFEOD, date time.Time) bool {
+	for _, bar := range bars {
+		if bar.Date.After(date) && bar.SplitRatio != 0 && bar.SplitRatio != 1 {
+			return true
 		}
-		c.logger.Printf("Successfully loaded EOD rows to %s", tableName)
-	} else {
-		c.logger.Printf("No data found for %s", symbol)
 	}
-
-	return nil
+	return false
 }
 
-func (c *YFCollector) EOD() error {
+func (c *YFCollector) EOD(ctx context.Context) error {
 	type queryResult struct {
 		Symbol string
 	}
@@ -140,7 +231,7 @@ func (c *YFCollector) EOD() error {
 	}
 
 	for _, row := range queryResults {
-		if err := c.EODForSymbol(row.Symbol); err != nil {
+		if err := c.EODForSymbol(ctx, row.Symbol); err != nil {
 			return err
 		}
 	}
@@ -196,17 +287,15 @@ func FilterSymbolVariations(textJSON string) (string, error) {
 }
 
 // Entry Function
-func YFCollect(fileJSON string, loadTickers bool, loadEOD bool) error {
-	db := dbloader.NewPGLoader(config.SchemaName, sdclogger.SDCLoggerInstance.Logger)
-	db.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
-
-	reader := NewHttpReader(NewLocalClient())
+func YFCollect(ctx context.Context, cfg *config.Config, fileJSON string, loadTickers bool, loadEOD bool) error {
+	db, err := dbloader.NewPGLoaderByConfig(cfg, sdclogger.SDCLoggerInstance.Logger)
+	if err != nil {
+		return err
+	}
+
+	reader := WithHttpCache(NewHttpReader(NewLocalClient(NewClientProfileByConfig(cfg))), cfg)
 	var yfExporters DataExporters
-	yfExporters.AddExporter(NewDBExporter(db, config.SchemaName))
+	yfExporters.AddExporter(NewDBExporter(db, cfg.SchemaName))
 
 	if loadTickers && len(fileJSON) > 0 {
 
@@ -228,22 +317,22 @@ func YFCollect(fileJSON string, loadTickers bool, loadEOD bool) error {
 		}
 
 		db.CreateTableByJsonStruct(YFDataTables[YF_TICKERS], YFDataTypes[YF_TICKERS])
-		if err := yfExporters.Export(YFDataTypes[YF_TICKERS], YFDataTables[YF_TICKERS], textFiltered, ""); err != nil {
+		if err := yfExporters.Export(ctx, YFDataTypes[YF_TICKERS], YFDataTables[YF_TICKERS], textFiltered, ""); err != nil {
 			return err
 		}
 		return nil
 	}
 
-	cl := NewYFCollector(reader, &yfExporters, db, sdclogger.SDCLoggerInstance.Logger)
+	cl := NewYFCollector(reader, &yfExporters, db, sdclogger.SDCLoggerInstance.Logger, cfg)
 	yfExporters.AddExporter(NewYFFileExporter())
 	if loadTickers {
-		if err := cl.Tickers(); err != nil {
+		if err := cl.Tickers(ctx); err != nil {
 			return err
 		}
 
 	}
 	if loadEOD {
-		if err := cl.EOD(); err != nil {
+		if err := cl.EOD(ctx); err != nil {
 			return err
 		}
 	}
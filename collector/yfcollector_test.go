@@ -1,9 +1,11 @@
 package collector_test
 
 import (
+	"context"
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	. "github.com/wayming/sdc/collector"
@@ -15,10 +17,10 @@ func TestYFCollector_Tickers(t *testing.T) {
 	defer fixture.Teardown(t)
 
 	fixture.DBExpect().CreateTableByJsonStruct(testcommon.NewStringPatternMatcher(YFDataTables[YF_TICKERS]+".*"), YFDataTypes[YF_TICKERS])
-	fixture.DBExpect().LoadByJsonText(gomock.Any(), testcommon.NewStringPatternMatcher(YFDataTables[YF_TICKERS]+".*"), YFDataTypes[YF_TICKERS])
+	fixture.DBExpect().LoadByJsonText(gomock.Any(), gomock.Any(), testcommon.NewStringPatternMatcher(YFDataTables[YF_TICKERS]+".*"), YFDataTypes[YF_TICKERS])
 	t.Run("TestYFCollector_Tickers", func(t *testing.T) {
-		c := NewYFCollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-		if err := c.Tickers(); err != nil {
+		c := NewYFCollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+		if err := c.Tickers(context.Background()); err != nil {
 			t.Errorf("YFTickers() error = %v", err)
 		}
 	})
@@ -48,9 +50,9 @@ func TestYFCollector_EOD(t *testing.T) {
 			result = reflect.Append(result, row)
 			return result.Interface(), nil
 		})
-	fixture.DBExpect().CreateTableByJsonStruct(testcommon.NewStringPatternMatcher(YFDataTables[YF_EOD]+".*"), YFDataTypes[YF_EOD])
-	fixture.DBExpect().LoadByJsonText(gomock.Any(), testcommon.NewStringPatternMatcher(YFDataTables[YF_EOD]+".*"), YFDataTypes[YF_EOD]).
-		DoAndReturn(func(text string, tableName string, structType reflect.Type) (int64, error) {
+	expectLastEODDate(fixture, "msft", time.Unix(0, 0).UTC())
+	fixture.DBExpect().LoadByJsonText(gomock.Any(), gomock.Any(), testcommon.NewStringPatternMatcher(YFDataTables[YF_EOD]+".*"), YFDataTypes[YF_EOD]).
+		DoAndReturn(func(ctx context.Context, text string, tableName string, structType reflect.Type) (int64, error) {
 			countOfFirstField := 0
 			var err error
 			if countOfFirstField, err = CountMatches(text, `"`+structType.Field(0).Tag.Get("json")+`"`); err != nil {
@@ -75,8 +77,8 @@ func TestYFCollector_EOD(t *testing.T) {
 		})
 
 	t.Run("TestYFCollector_EOD", func(t *testing.T) {
-		c := NewYFCollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger())
-		if err := c.EOD(); err != nil {
+		c := NewYFCollector(fixture.Reader(), fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+		if err := c.EOD(context.Background()); err != nil {
 			t.Errorf("YFCollector::EOD error=%v", err)
 		}
 	})
@@ -84,6 +86,138 @@ func TestYFCollector_EOD(t *testing.T) {
 	// teardownYFTest()
 }
 
+// Reader answering EOD requests with the bars by start date
+type eodReader struct {
+	responses  map[string]string
+	startDates []string
+}
+
+func (r *eodReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	r.startDates = append(r.startDates, params["start_date"])
+	return r.responses[params["start_date"]], nil
+}
+
+func (r *eodReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	return url, nil
+}
+
+// Expect the lookup of the EOD table of the symbol
+func expectEODTable(fixture *testcommon.MockTestFixture, symbol string, found bool) {
+	fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher(`to_regclass`), gomock.Any(), YFDataTables[YF_EOD]+"_"+symbol).
+		DoAndReturn(func(sql string, resultType reflect.Type, args ...any) (interface{}, error) {
+			row := reflect.New(resultType).Elem()
+			row.Field(0).SetBool(found)
+			return reflect.Append(reflect.MakeSlice(reflect.SliceOf(resultType), 0, 1), row).Interface(), nil
+		})
+}
+
+// Expect the last date stored in the EOD table of the symbol, and the bars
+// loaded into the table
+func expectLastEODDate(fixture *testcommon.MockTestFixture, symbol string, last time.Time) {
+	expectEODTable(fixture, symbol, true)
+	fixture.DBExpect().CreateTableByJsonStruct(YFDataTables[YF_EOD]+"_"+symbol, YFDataTypes[YF_EOD])
+	fixture.DBExpect().RunQuery(testcommon.NewStringPatternMatcher(`max\(date\).* from `+YFDataTables[YF_EOD]+"_"+symbol), gomock.Any()).
+		DoAndReturn(func(sql string, resultType reflect.Type, args ...any) (interface{}, error) {
+			row := reflect.New(resultType).Elem()
+			row.Field(0).Set(reflect.ValueOf(last))
+			return reflect.Append(reflect.MakeSlice(reflect.SliceOf(resultType), 0, 1), row).Interface(), nil
+		})
+}
+
+func TestYFCollector_EODForSymbol_Incremental(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	expectLastEODDate(fixture, "msft", time.Date(2024, 7, 26, 0, 0, 0, 0, time.UTC))
+	reader := &eodReader{responses: map[string]string{
+		"2024-07-19": `{"results": [
+			{"date": "2024-07-26", "open": 418.2, "high": 428.9, "low": 417.3, "close": 425.3, "volume": 23583800, "split_ratio": 0, "dividend": 0},
+			{"date": "2024-07-29", "open": 431.6, "high": 432.2, "low": 424.7, "close": 426.7, "volume": 15125800, "split_ratio": 0, "dividend": 0}
+		]}`,
+	}}
+	var loaded []YFEOD
+	fixture.DBExpect().LoadByJsonText(gomock.Any(), gomock.Any(), YFDataTables[YF_EOD]+"_msft", YFDataTypes[YF_EOD]).
+		DoAndReturn(func(ctx context.Context, text string, tableName string, structType reflect.Type) (int64, error) {
+			if err := json.Unmarshal([]byte(text), &loaded); err != nil {
+				t.Errorf("Failed to unmarshal bars %s. Error: %v", text, err)
+			}
+			return int64(len(loaded)), nil
+		})
+
+	// Rows of the EOD tables of the symbols are counted as one table
+	series := []string{
+		`sdc_rows_loaded_total{table="` + YFDataTables[YF_EOD] + `"}`,
+		`sdc_rows_loaded_total{table="` + YFDataTables[YF_EOD] + `_msft"}`,
+	}
+	before := metricValues(series)
+	c := NewYFCollector(reader, fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	if err := c.EODForSymbol(context.Background(), "msft"); err != nil {
+		t.Fatalf("YFCollector.EODForSymbol() error = %v", err)
+	}
+	after := metricValues(series)
+	if after[0]-before[0] != 2 || after[1] != 0 {
+		t.Errorf("Expecting 2 rows counted for %s only, got %v", YFDataTables[YF_EOD], after)
+	}
+	if len(reader.startDates) != 1 {
+		t.Errorf("Expecting the bars since the overlap only, got requests since %v", reader.startDates)
+	}
+	if len(loaded) != 2 {
+		t.Errorf("Expecting 2 bars loaded, got %d", len(loaded))
+	}
+}
+
+func TestYFCollector_EODForSymbol_NoBars(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	// No table is created for the symbols without bars
+	expectEODTable(fixture, "unknown", false)
+	reader := &eodReader{responses: map[string]string{EOD_START_DATE: `{"results": []}`}}
+
+	c := NewYFCollector(reader, fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	if err := c.EODForSymbol(context.Background(), "unknown"); err != nil {
+		t.Fatalf("YFCollector.EODForSymbol() error = %v", err)
+	}
+	if want := []string{EOD_START_DATE}; !reflect.DeepEqual(reader.startDates, want) {
+		t.Errorf("Expecting all the bars requested, got requests since %v", reader.startDates)
+	}
+}
+
+func TestYFCollector_EODForSymbol_Split(t *testing.T) {
+	fixture := testcommon.NewMockTestFixture(t)
+	defer fixture.Teardown(t)
+
+	expectLastEODDate(fixture, "nvda", time.Date(2024, 6, 7, 0, 0, 0, 0, time.UTC))
+	reader := &eodReader{responses: map[string]string{
+		"2024-05-31": `{"results": [
+			{"date": "2024-06-10", "open": 120.4, "high": 123.1, "low": 117.0, "close": 121.8, "volume": 314162700, "split_ratio": 10, "dividend": 0}
+		]}`,
+		EOD_START_DATE: `{"results": [
+			{"date": "2000-01-03", "open": 0.09, "high": 0.09, "low": 0.08, "close": 0.09, "volume": 300912000, "split_ratio": 0, "dividend": 0},
+			{"date": "2024-06-10", "open": 120.4, "high": 123.1, "low": 117.0, "close": 121.8, "volume": 314162700, "split_ratio": 10, "dividend": 0}
+		]}`,
+	}}
+	var loaded []YFEOD
+	fixture.DBExpect().LoadByJsonText(gomock.Any(), gomock.Any(), YFDataTables[YF_EOD]+"_nvda", YFDataTypes[YF_EOD]).
+		DoAndReturn(func(ctx context.Context, text string, tableName string, structType reflect.Type) (int64, error) {
+			if err := json.Unmarshal([]byte(text), &loaded); err != nil {
+				t.Errorf("Failed to unmarshal bars %s. Error: %v", text, err)
+			}
+			return int64(len(loaded)), nil
+		})
+
+	c := NewYFCollector(reader, fixture.Exporter(), fixture.DBMock(), fixture.Logger(), fixture.Config())
+	if err := c.EODForSymbol(context.Background(), "nvda"); err != nil {
+		t.Fatalf("YFCollector.EODForSymbol() error = %v", err)
+	}
+	if want := []string{"2024-05-31", EOD_START_DATE}; !reflect.DeepEqual(reader.startDates, want) {
+		t.Errorf("Expecting requests since %v, got %v", want, reader.startDates)
+	}
+	if len(loaded) != 2 {
+		t.Errorf("Expecting all the 2 bars reloaded, got %d", len(loaded))
+	}
+}
+
 func TestExtractDataTickers(t *testing.T) {
 	inpuJSONText := `[
 		{
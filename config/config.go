@@ -1,5 +1,898 @@
 package config
 
-// Global Variables
-var SchemaName string = "sdc"
-var ProxyFile string = "data/proxy7.txt"
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_SCHEMA_NAME = "sdc"
+const DEFAULT_PROXY_FILE = "data/proxy7.txt"
+const DEFAULT_OPENBB_URL = "http://openbb:8001"
+const DEFAULT_SA_URL = "https://stockanalysis.com"
+const DEFAULT_MS_URL = "http://api.marketstack.com"
+const DEFAULT_SYMBOL_TIMEOUT = 10 * time.Minute
+const DEFAULT_RETRY_MAX_ATTEMPTS = 3
+const DEFAULT_RETRY_BASE_DELAY = 10 * time.Second
+const DEFAULT_RETRY_MAX_DELAY = 5 * time.Minute
+const DEFAULT_DIAGNOSTICS_DIR = "diagnostics"
+const DEFAULT_PROXY_COOLDOWN = 2 * time.Minute
+const DEFAULT_PROXY_BAN_AFTER = 5
+const DEFAULT_RATE_LIMIT_BURST = 1
+const DEFAULT_QUEUE_LEASE_TTL = 2 * time.Minute
+const DEFAULT_FILING_LAG = 45 * 24 * time.Hour
+const DEFAULT_EOD_OVERLAP = 7 * 24 * time.Hour
+const DEFAULT_HTTP_CONNECT_TIMEOUT = 10 * time.Second
+const DEFAULT_HTTP_READ_TIMEOUT = 30 * time.Second
+const DEFAULT_HTTP_TIMEOUT = 60 * time.Second
+const DEFAULT_HTTP_MAX_IDLE_CONNS = 100
+const DEFAULT_HTTP_MAX_IDLE_CONNS_PER_HOST = 10
+const DEFAULT_HTTP_IDLE_CONN_TIMEOUT = 90 * time.Second
+const DEFAULT_HTTP_CACHE_TTL = 24 * time.Hour
+const DEFAULT_PROXY_CHECK_URL = "https://api.ipify.org"
+const DEFAULT_PROXY_CHECK_TIMEOUT = 10 * time.Second
+const DEFAULT_PROXY_CHECK_WORKERS = 20
+
+// Content encodings the HTTP clients can decode
+var HTTP_COMPRESSIONS = []string{"gzip", "deflate", "br"}
+
+// Queue priority processing the symbols of larger market caps first
+const QUEUE_PRIORITY_MARKET_CAP = "market_cap"
+
+// Postgres connection settings
+type PGConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+}
+
+// Redis connection settings
+type RedisConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// Base URLs of the upstream data sources
+type EndpointsConfig struct {
+	OpenBB         string `yaml:"openbb"`
+	StockAnalysis  string `yaml:"stockanalysis"`
+	MarketStack    string `yaml:"marketstack"`
+	MarketStackKey string `yaml:"marketstack_key"`
+}
+
+// Retry policy of failed symbols in parallel collectors
+type RetryConfig struct {
+	// Symbols failed this many times are parked in the dead-letter set
+	MaxAttempts int           `yaml:"max_attempts"`
+	BaseDelay   time.Duration `yaml:"base_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	// Error classes retried within the run, e.g. process, too_many_requests
+	RetryOn []string `yaml:"retry_on"`
+}
+
+// Health policy of the proxy pool used by parallel collectors
+type ProxyPoolConfig struct {
+	// Time a proxy is rested after the server throttled it
+	Cooldown time.Duration `yaml:"cooldown"`
+	// Number of consecutive failures before a proxy is banned. Only requests
+	// failed on the way to the server count, not errors of the data.
+	BanAfter int `yaml:"ban_after"`
+}
+
+// Checks of the proxies loaded into the pool and of `sdc proxies check`
+type ProxyCheckConfig struct {
+	// URL answering the public IP address of the caller in the body. Proxies
+	// passing on the address of the collector are transparent. Empty skips
+	// the probe.
+	ProbeURL string `yaml:"probe_url"`
+	// URLs of the upstream sources requested through the proxies, to detect
+	// the proxies blocked by them. Empty for the stockanalysis endpoint.
+	Targets []string `yaml:"targets"`
+	// Upper bound of each connect and request
+	Timeout time.Duration `yaml:"timeout"`
+	// Proxies checked at once
+	Workers int `yaml:"workers"`
+}
+
+// Requests per second shared by all workers of a parallel collector. Zero or
+// missing rates leave the requests unlimited.
+type RateLimitConfig struct {
+	// Rate per upstream host, e.g. stockanalysis.com
+	Hosts map[string]float64 `yaml:"hosts"`
+	// Rate per proxy, across all hosts
+	PerProxy float64 `yaml:"per_proxy"`
+	Burst    int     `yaml:"burst"`
+}
+
+// Symbol queue shared by parallel collectors
+type QueueConfig struct {
+	// Time a symbol stays leased without a heartbeat before it is requeued
+	LeaseTTL time.Duration `yaml:"lease_ttl"`
+	// Order symbols are processed in, e.g. market_cap for large caps first.
+	// Empty to process symbols in the order queued.
+	Priority string `yaml:"priority"`
+	// File of symbols, one per line, processed before any other symbol
+	Watchlist string `yaml:"watchlist"`
+}
+
+// Financials loads from stockanalysis
+type FinancialsConfig struct {
+	// Datasets collected, e.g. ratios, ratings. Empty for all datasets.
+	Datasets []string `yaml:"datasets"`
+	// Age by dataset after which the data of a symbol is collected again.
+	// Datasets without age are refreshed only when a new quarter is expected.
+	MaxAge map[string]time.Duration `yaml:"max_age"`
+	// Time after the end of a fiscal quarter until its statements are
+	// expected to be published. Zero disables the check.
+	FilingLag time.Duration `yaml:"filing_lag"`
+	// Collect the datasets even if the data is fresh
+	Force bool `yaml:"force"`
+}
+
+// End of day loads from openbb
+type EODConfig struct {
+	// Time before the last bar stored that is downloaded again to catch
+	// corrections of recent bars
+	Overlap time.Duration `yaml:"overlap"`
+	// Download all the bars instead of the bars since the last one stored
+	Full bool `yaml:"full"`
+}
+
+// REST API of `sdc serve`
+type ServeConfig struct {
+	// Bearer token required by the requests of the API. Required to serve on
+	// addresses other than the loopback ones.
+	Token string `yaml:"token"`
+	// Directory of the proxy and tickers files the runs started through the
+	// API may name. Only the proxy file of the configuration if empty.
+	FilesDir string `yaml:"files_dir"`
+}
+
+// Profile of the HTTP clients requesting the upstream sources. Each worker
+// has its own client, with its own cookies and connection pool.
+type HTTPClientConfig struct {
+	// Time to connect, including the TLS handshake
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Time to receive the response headers once the request is sent
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// Upper bound of a request, including reading the body. Deadlines of the
+	// request context apply on top of it. Zero for no limit.
+	Timeout time.Duration `yaml:"timeout"`
+	// User agents assigned to the clients in turn. Go's user agent if empty.
+	UserAgents []string `yaml:"user_agents"`
+	// Headers sent with every request, e.g. Accept-Language
+	Headers map[string]string `yaml:"headers"`
+	// Keep the cookies set by the servers for the following requests
+	Cookies bool `yaml:"cookies"`
+	// Content encodings accepted and decoded, gzip, deflate or br. Uncompressed
+	// responses are requested if empty.
+	Compression         []string      `yaml:"compression"`
+	DisableKeepAlives   bool          `yaml:"disable_keep_alives"`
+	MaxIdleConns        int           `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `yaml:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int           `yaml:"max_conns_per_host"` // Zero for no limit
+	IdleConnTimeout     time.Duration `yaml:"idle_conn_timeout"`
+}
+
+// Responses of the upstream sources cached on disk, e.g. to parse the pages
+// again after fixing a parser
+type HTTPCacheConfig struct {
+	// Directory of the responses. Empty disables the cache.
+	Dir string `yaml:"dir"`
+	// Age after which a response is requested again. Zero keeps the
+	// responses forever.
+	TTL time.Duration `yaml:"ttl"`
+	// Read the responses from the cache only, whatever their age. Requests
+	// not cached fail.
+	Offline bool `yaml:"offline"`
+}
+
+type Config struct {
+	SchemaName string `yaml:"schema"`
+	ProxyFile  string `yaml:"proxy_file"`
+	// Upper bound of collecting one symbol in parallel collectors. Zero disables it.
+	SymbolTimeout time.Duration `yaml:"symbol_timeout"`
+	// Pages read by symbols panicking in parallel collectors are saved here.
+	// Empty disables it.
+	DiagnosticsDir string `yaml:"diagnostics_dir"`
+	// Address the Prometheus metrics are served on, e.g. :5001. Empty disables it.
+	MetricsAddr string           `yaml:"metrics_addr"`
+	Retry       RetryConfig      `yaml:"retry"`
+	ProxyPool   ProxyPoolConfig  `yaml:"proxy_pool"`
+	ProxyCheck  ProxyCheckConfig `yaml:"proxy_check"`
+	RateLimit   RateLimitConfig  `yaml:"rate_limit"`
+	Queue       QueueConfig      `yaml:"queue"`
+	HTTPClient  HTTPClientConfig `yaml:"http_client"`
+	HTTPCache   HTTPCacheConfig  `yaml:"http_cache"`
+	Financials  FinancialsConfig `yaml:"financials"`
+	EOD         EODConfig        `yaml:"eod"`
+	Serve       ServeConfig      `yaml:"serve"`
+	Postgres    PGConfig         `yaml:"postgres"`
+	Redis       RedisConfig      `yaml:"redis"`
+	Endpoints   EndpointsConfig  `yaml:"endpoints"`
+}
+
+// A setting that can be overridden by an environment variable and a command line flag.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+// Postgres database and schema
+var databaseSettings = []setting{
+	{"schema", "SDC_SCHEMA", "Database schema name.",
+		func(c *Config, v string) error { c.SchemaName = v; return nil }},
+	{"pg_host", "PGHOST", "Postgres host.",
+		func(c *Config, v string) error { c.Postgres.Host = v; return nil }},
+	{"pg_port", "PGPORT", "Postgres port.",
+		func(c *Config, v string) error { c.Postgres.Port = v; return nil }},
+	{"pg_user", "PGUSER", "Postgres user.",
+		func(c *Config, v string) error { c.Postgres.User = v; return nil }},
+	{"pg_password", "PGPASSWORD", "Postgres password.",
+		func(c *Config, v string) error { c.Postgres.Password = v; return nil }},
+	{"pg_database", "PGDATABASE", "Postgres database name.",
+		func(c *Config, v string) error { c.Postgres.Database = v; return nil }},
+}
+
+// Redis cache of the symbols and the proxies
+var cacheSettings = []setting{
+	{"redis_host", "REDISHOST", "Redis host.",
+		func(c *Config, v string) error { c.Redis.Host = v; return nil }},
+	{"redis_port", "REDISPORT", "Redis port.",
+		func(c *Config, v string) error { c.Redis.Port = v; return nil }},
+	{"redis_password", "REDISPASSWORD", "Redis password.",
+		func(c *Config, v string) error { c.Redis.Password = v; return nil }},
+	{"redis_db", "REDISDB", "Redis database number.",
+		func(c *Config, v string) error {
+			db, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid redis db %s: %v", v, err)
+			}
+			c.Redis.DB = db
+			return nil
+		}},
+}
+
+// Base URLs of the upstream sources
+var endpointSettings = []setting{
+	{"openbb_url", "SDC_OPENBB_URL", "Base URL of the OpenBB API.",
+		func(c *Config, v string) error { c.Endpoints.OpenBB = v; return nil }},
+	{"sa_url", "SDC_SA_URL", "Base URL of stockanalysis.",
+		func(c *Config, v string) error { c.Endpoints.StockAnalysis = v; return nil }},
+	{"ms_url", "SDC_MS_URL", "Base URL of the marketstack API.",
+		func(c *Config, v string) error { c.Endpoints.MarketStack = v; return nil }},
+	{"ms_key", "MSACCESSKEY", "Access key of the marketstack API.",
+		func(c *Config, v string) error { c.Endpoints.MarketStackKey = v; return nil }},
+}
+
+// Runs of the parallel collectors
+var runSettings = []setting{
+	{"symbol_timeout", "SDC_SYMBOL_TIMEOUT", "Timeout of collecting one symbol, e.g. 90s or 10m. 0 disables it.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid symbol timeout %s: %v", v, err)
+			}
+			c.SymbolTimeout = d
+			return nil
+		}},
+	{"diagnostics_dir", "SDC_DIAGNOSTICS_DIR", "Directory the pages read by panicking symbols are saved to. Empty disables it.",
+		func(c *Config, v string) error { c.DiagnosticsDir = v; return nil }},
+}
+
+// Prometheus metrics
+var metricsSettings = []setting{
+	{"metrics_addr", "SDC_METRICS_ADDR", "Address the Prometheus metrics are served on at /metrics, e.g. :5001. Empty disables it.",
+		func(c *Config, v string) error { c.MetricsAddr = v; return nil }},
+}
+
+// Retries of the failed symbols
+var retrySettings = []setting{
+	{"retry_max_attempts", "SDC_RETRY_MAX_ATTEMPTS", "Number of failures before a symbol is parked in the dead-letter set.",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid retry max attempts %s: %v", v, err)
+			}
+			c.Retry.MaxAttempts = n
+			return nil
+		}},
+	{"retry_base_delay", "SDC_RETRY_BASE_DELAY", "Delay before the first retry of a symbol, doubled on each further retry.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid retry base delay %s: %v", v, err)
+			}
+			c.Retry.BaseDelay = d
+			return nil
+		}},
+	{"retry_max_delay", "SDC_RETRY_MAX_DELAY", "Upper bound of the delay between retries of a symbol.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid retry max delay %s: %v", v, err)
+			}
+			c.Retry.MaxDelay = d
+			return nil
+		}},
+	{"retry_on", "SDC_RETRY_ON", "Comma separated error classes retried within the run.",
+		func(c *Config, v string) error {
+			c.Retry.RetryOn = []string{}
+			for _, class := range strings.Split(v, ",") {
+				if class = strings.TrimSpace(class); len(class) > 0 {
+					c.Retry.RetryOn = append(c.Retry.RetryOn, class)
+				}
+			}
+			return nil
+		}},
+}
+
+// Proxies of the parallel collectors
+var proxySettings = []setting{
+	{"proxy_file", "SDC_PROXY_FILE", "Default file with list of proxy servers.",
+		func(c *Config, v string) error { c.ProxyFile = v; return nil }},
+	{"proxy_cooldown", "SDC_PROXY_COOLDOWN", "Time a proxy is rested after being throttled by the server.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid proxy cooldown %s: %v", v, err)
+			}
+			c.ProxyPool.Cooldown = d
+			return nil
+		}},
+	{"proxy_ban_after", "SDC_PROXY_BAN_AFTER", "Number of consecutive failures before a proxy is banned.",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid proxy ban after %s: %v", v, err)
+			}
+			c.ProxyPool.BanAfter = n
+			return nil
+		}},
+}
+
+// Health checks of the proxies
+var proxyCheckSettings = []setting{
+	{"proxy_check_url", "SDC_PROXY_CHECK_URL", "URL answering the public IP address of the caller, to detect transparent proxies. Empty skips it.",
+		func(c *Config, v string) error { c.ProxyCheck.ProbeURL = v; return nil }},
+	{"proxy_check_targets", "SDC_PROXY_CHECK_TARGETS", "Comma separated URLs requested through the proxies checked. Empty for the stockanalysis endpoint.",
+		func(c *Config, v string) error {
+			c.ProxyCheck.Targets = []string{}
+			for _, target := range strings.Split(v, ",") {
+				if target = strings.TrimSpace(target); len(target) > 0 {
+					c.ProxyCheck.Targets = append(c.ProxyCheck.Targets, target)
+				}
+			}
+			return nil
+		}},
+	{"proxy_check_timeout", "SDC_PROXY_CHECK_TIMEOUT", "Timeout of each connect and request of the proxy checks.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid proxy check timeout %s: %v", v, err)
+			}
+			c.ProxyCheck.Timeout = d
+			return nil
+		}},
+	{"proxy_check_workers", "SDC_PROXY_CHECK_WORKERS", "Proxies checked at once.",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid proxy check workers %s: %v", v, err)
+			}
+			c.ProxyCheck.Workers = n
+			return nil
+		}},
+}
+
+// Rate limits of the requests
+var rateLimitSettings = []setting{
+	{"rate_limit", "SDC_RATE_LIMIT", "Comma separated requests per second by host, e.g. stockanalysis.com=2,openbb=10.",
+		func(c *Config, v string) error {
+			hosts := make(map[string]float64)
+			for _, pair := range strings.Split(v, ",") {
+				if pair = strings.TrimSpace(pair); len(pair) == 0 {
+					continue
+				}
+				host, value, found := strings.Cut(pair, "=")
+				if !found {
+					return fmt.Errorf("invalid rate limit %s, expecting host=rate", pair)
+				}
+				r, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return fmt.Errorf("invalid rate limit %s: %v", pair, err)
+				}
+				hosts[strings.TrimSpace(host)] = r
+			}
+			c.RateLimit.Hosts = hosts
+			return nil
+		}},
+	{"rate_limit_proxy", "SDC_RATE_LIMIT_PROXY", "Requests per second through each proxy. 0 disables it.",
+		func(c *Config, v string) error {
+			r, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("invalid proxy rate limit %s: %v", v, err)
+			}
+			c.RateLimit.PerProxy = r
+			return nil
+		}},
+	{"rate_limit_burst", "SDC_RATE_LIMIT_BURST", "Requests allowed at once above the rate limits.",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid rate limit burst %s: %v", v, err)
+			}
+			c.RateLimit.Burst = n
+			return nil
+		}},
+}
+
+// Queue of the symbols
+var queueSettings = []setting{
+	{"queue_lease_ttl", "SDC_QUEUE_LEASE_TTL", "Time a symbol stays leased without a heartbeat before it is requeued.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid queue lease ttl %s: %v", v, err)
+			}
+			c.Queue.LeaseTTL = d
+			return nil
+		}},
+	{"queue_priority", "SDC_QUEUE_PRIORITY", "Order symbols are processed in, market_cap for large caps first. Empty for the order queued.",
+		func(c *Config, v string) error { c.Queue.Priority = v; return nil }},
+	{"watchlist", "SDC_WATCHLIST", "File of symbols, one per line, processed before any other symbol.",
+		func(c *Config, v string) error { c.Queue.Watchlist = v; return nil }},
+}
+
+// HTTP clients and the http cache
+var httpSettings = []setting{
+	{"http_connect_timeout", "SDC_HTTP_CONNECT_TIMEOUT", "Timeout of connecting to the upstream sources, including the TLS handshake.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid http connect timeout %s: %v", v, err)
+			}
+			c.HTTPClient.ConnectTimeout = d
+			return nil
+		}},
+	{"http_read_timeout", "SDC_HTTP_READ_TIMEOUT", "Timeout of receiving the response headers once a request is sent.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid http read timeout %s: %v", v, err)
+			}
+			c.HTTPClient.ReadTimeout = d
+			return nil
+		}},
+	{"http_timeout", "SDC_HTTP_TIMEOUT", "Timeout of a request to the upstream sources, including reading the body. 0 disables it.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid http timeout %s: %v", v, err)
+			}
+			c.HTTPClient.Timeout = d
+			return nil
+		}},
+	{"http_user_agents", "SDC_HTTP_USER_AGENTS", "User agents assigned to the workers in turn, separated by |.",
+		func(c *Config, v string) error {
+			c.HTTPClient.UserAgents = []string{}
+			for _, agent := range strings.Split(v, "|") {
+				if agent = strings.TrimSpace(agent); len(agent) > 0 {
+					c.HTTPClient.UserAgents = append(c.HTTPClient.UserAgents, agent)
+				}
+			}
+			return nil
+		}},
+	{"http_cookies", "SDC_HTTP_COOKIES", "Keep the cookies set by the upstream sources, true or false.",
+		func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid http cookies %s: %v", v, err)
+			}
+			c.HTTPClient.Cookies = b
+			return nil
+		}},
+	{"http_compression", "SDC_HTTP_COMPRESSION", "Comma separated content encodings accepted, gzip, deflate or br. none for uncompressed responses.",
+		func(c *Config, v string) error {
+			c.HTTPClient.Compression = []string{}
+			for _, encoding := range strings.Split(v, ",") {
+				if encoding = strings.TrimSpace(encoding); len(encoding) > 0 && encoding != "none" {
+					c.HTTPClient.Compression = append(c.HTTPClient.Compression, encoding)
+				}
+			}
+			return nil
+		}},
+	{"http_max_conns_per_host", "SDC_HTTP_MAX_CONNS_PER_HOST", "Connections of a worker to each host. 0 for no limit.",
+		func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid http max conns per host %s: %v", v, err)
+			}
+			c.HTTPClient.MaxConnsPerHost = n
+			return nil
+		}},
+	{"http_cache_dir", "SDC_HTTP_CACHE_DIR", "Directory the responses of the upstream sources are cached in. Empty disables it.",
+		func(c *Config, v string) error { c.HTTPCache.Dir = v; return nil }},
+	{"http_cache_ttl", "SDC_HTTP_CACHE_TTL", "Age after which a cached response is requested again, e.g. 24h. 0 keeps responses forever.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid http cache ttl %s: %v", v, err)
+			}
+			c.HTTPCache.TTL = d
+			return nil
+		}},
+	{"http_cache_offline", "SDC_HTTP_CACHE_OFFLINE", "Read the responses from the http cache only, true or false.",
+		func(c *Config, v string) error {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid http cache offline %s: %v", v, err)
+			}
+			c.HTTPCache.Offline = b
+			return nil
+		}},
+}
+
+// Financials loads
+var financialsSettings = []setting{
+	{"datasets", "SDC_DATASETS", "Comma separated datasets collected by financials loads, e.g. ratios,ratings. Empty for all.",
+		func(c *Config, v string) error {
+			c.Financials.Datasets = []string{}
+			for _, dataset := range strings.Split(v, ",") {
+				if dataset = strings.TrimSpace(dataset); len(dataset) > 0 {
+					c.Financials.Datasets = append(c.Financials.Datasets, dataset)
+				}
+			}
+			return nil
+		}},
+	{"max_age", "SDC_MAX_AGE", "Comma separated age by dataset after which financials are collected again, e.g. ratings=24h,income=2160h.",
+		func(c *Config, v string) error {
+			maxAge := make(map[string]time.Duration)
+			for dataset, age := range c.Financials.MaxAge {
+				maxAge[dataset] = age
+			}
+			for _, pair := range strings.Split(v, ",") {
+				if pair = strings.TrimSpace(pair); len(pair) == 0 {
+					continue
+				}
+				dataset, value, found := strings.Cut(pair, "=")
+				if !found {
+					return fmt.Errorf("invalid max age %s, expecting dataset=age", pair)
+				}
+				d, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("invalid max age %s: %v", pair, err)
+				}
+				maxAge[strings.TrimSpace(dataset)] = d
+			}
+			c.Financials.MaxAge = maxAge
+			return nil
+		}},
+	{"filing_lag", "SDC_FILING_LAG", "Time after the end of a fiscal quarter until its statements are expected. 0 disables it.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid filing lag %s: %v", v, err)
+			}
+			c.Financials.FilingLag = d
+			return nil
+		}},
+}
+
+// EOD loads
+var eodSettings = []setting{
+	{"eod_overlap", "SDC_EOD_OVERLAP", "Time before the last EOD bar stored that is downloaded again, e.g. 168h.",
+		func(c *Config, v string) error {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("invalid eod overlap %s: %v", v, err)
+			}
+			c.EOD.Overlap = d
+			return nil
+		}},
+}
+
+// REST API of sdc serve
+var serveSettings = []setting{
+	{"serve_token", "SDC_SERVE_TOKEN", "Bearer token required by the requests of the API. Required to serve on addresses other than localhost.",
+		func(c *Config, v string) error { c.Serve.Token = v; return nil }},
+	{"serve_files_dir", "SDC_SERVE_FILES_DIR", "Directory of the proxy and tickers files the runs started through the API may name.",
+		func(c *Config, v string) error { c.Serve.FilesDir = v; return nil }},
+}
+
+// All the settings, overridden by the environment variables
+var settings = slices.Concat(
+	databaseSettings,
+	cacheSettings,
+	endpointSettings,
+	runSettings,
+	metricsSettings,
+	retrySettings,
+	proxySettings,
+	proxyCheckSettings,
+	rateLimitSettings,
+	queueSettings,
+	httpSettings,
+	financialsSettings,
+	eodSettings,
+	serveSettings,
+)
+
+// Built-in defaults
+func NewConfig() *Config {
+	return &Config{
+		SchemaName:     DEFAULT_SCHEMA_NAME,
+		ProxyFile:      DEFAULT_PROXY_FILE,
+		SymbolTimeout:  DEFAULT_SYMBOL_TIMEOUT,
+		DiagnosticsDir: DEFAULT_DIAGNOSTICS_DIR,
+		Retry: RetryConfig{
+			MaxAttempts: DEFAULT_RETRY_MAX_ATTEMPTS,
+			BaseDelay:   DEFAULT_RETRY_BASE_DELAY,
+			MaxDelay:    DEFAULT_RETRY_MAX_DELAY,
+			RetryOn:     []string{"process", "too_many_requests"},
+		},
+		ProxyPool: ProxyPoolConfig{
+			Cooldown: DEFAULT_PROXY_COOLDOWN,
+			BanAfter: DEFAULT_PROXY_BAN_AFTER,
+		},
+		ProxyCheck: ProxyCheckConfig{
+			ProbeURL: DEFAULT_PROXY_CHECK_URL,
+			Timeout:  DEFAULT_PROXY_CHECK_TIMEOUT,
+			Workers:  DEFAULT_PROXY_CHECK_WORKERS,
+		},
+		RateLimit: RateLimitConfig{
+			Hosts: map[string]float64{},
+			Burst: DEFAULT_RATE_LIMIT_BURST,
+		},
+		Queue: QueueConfig{
+			LeaseTTL: DEFAULT_QUEUE_LEASE_TTL,
+		},
+		HTTPClient: HTTPClientConfig{
+			ConnectTimeout: DEFAULT_HTTP_CONNECT_TIMEOUT,
+			ReadTimeout:    DEFAULT_HTTP_READ_TIMEOUT,
+			Timeout:        DEFAULT_HTTP_TIMEOUT,
+			UserAgents: []string{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+				"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+				"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			},
+			Headers: map[string]string{
+				"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,application/json;q=0.8,*/*;q=0.7",
+				"Accept-Language": "en-US,en;q=0.9",
+			},
+			Cookies:             true,
+			Compression:         []string{"gzip", "deflate"},
+			MaxIdleConns:        DEFAULT_HTTP_MAX_IDLE_CONNS,
+			MaxIdleConnsPerHost: DEFAULT_HTTP_MAX_IDLE_CONNS_PER_HOST,
+			IdleConnTimeout:     DEFAULT_HTTP_IDLE_CONN_TIMEOUT,
+		},
+		HTTPCache: HTTPCacheConfig{
+			TTL: DEFAULT_HTTP_CACHE_TTL,
+		},
+		Financials: FinancialsConfig{
+			MaxAge: map[string]time.Duration{
+				"overview":      24 * time.Hour,
+				"income":        90 * 24 * time.Hour,
+				"balance_sheet": 90 * 24 * time.Hour,
+				"cash_flow":     90 * 24 * time.Hour,
+				"ratios":        90 * 24 * time.Hour,
+				"ratings":       24 * time.Hour,
+			},
+			FilingLag: DEFAULT_FILING_LAG,
+		},
+		EOD: EODConfig{
+			Overlap: DEFAULT_EOD_OVERLAP,
+		},
+		Postgres: PGConfig{
+			Host: "localhost",
+			Port: "5432",
+		},
+		Redis: RedisConfig{
+			Host: "localhost",
+			Port: "6379",
+		},
+		Endpoints: EndpointsConfig{
+			OpenBB:        DEFAULT_OPENBB_URL,
+			StockAnalysis: DEFAULT_SA_URL,
+			MarketStack:   DEFAULT_MS_URL,
+		},
+	}
+}
+
+// Load the configuration in layers. Built-in defaults are overridden by the
+// YAML file, which are overridden by environment variables. The file is
+// optional and skipped when the name is empty.
+func Load(file string) (*Config, error) {
+	c := NewConfig()
+	if len(file) > 0 {
+		if err := c.LoadFile(file); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.LoadEnv(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) LoadFile(file string) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", file, err)
+	}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", file, err)
+	}
+	return nil
+}
+
+func (c *Config) LoadEnv() error {
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && len(v) > 0 {
+			if err := s.set(c, v); err != nil {
+				return fmt.Errorf("invalid environment variable %s: %v", s.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if len(c.SchemaName) == 0 {
+		return errors.New("schema name must not be empty")
+	}
+	if len(c.Endpoints.OpenBB) == 0 || len(c.Endpoints.StockAnalysis) == 0 {
+		return errors.New("base urls of openbb and stockanalysis must not be empty")
+	}
+	if c.SymbolTimeout < 0 {
+		return errors.New("symbol timeout must not be negative")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return errors.New("retry max attempts must be at least 1")
+	}
+	if c.Retry.BaseDelay < 0 || c.Retry.MaxDelay < c.Retry.BaseDelay {
+		return errors.New("retry delays must not be negative and max delay must not be less than base delay")
+	}
+	if c.ProxyPool.Cooldown < 0 || c.ProxyPool.BanAfter < 1 {
+		return errors.New("proxy cooldown must not be negative and ban after must be at least 1")
+	}
+	if c.ProxyCheck.Timeout <= 0 || c.ProxyCheck.Workers < 1 {
+		return errors.New("proxy check timeout must be positive and workers must be at least 1")
+	}
+	for _, u := range append([]string{c.ProxyCheck.ProbeURL}, c.ProxyCheck.Targets...) {
+		if len(u) > 0 && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("invalid proxy check url %s, expecting an http or https url", u)
+		}
+	}
+	for host, r := range c.RateLimit.Hosts {
+		if r < 0 {
+			return fmt.Errorf("rate limit of host %s must not be negative", host)
+		}
+	}
+	if c.RateLimit.PerProxy < 0 || c.RateLimit.Burst < 1 {
+		return errors.New("proxy rate limit must not be negative and rate limit burst must be at least 1")
+	}
+	if c.Queue.LeaseTTL < time.Second {
+		return errors.New("queue lease ttl must be at least 1s")
+	}
+	if c.Queue.Priority != "" && c.Queue.Priority != QUEUE_PRIORITY_MARKET_CAP {
+		return fmt.Errorf("unknown queue priority %s, expecting %s or empty", c.Queue.Priority, QUEUE_PRIORITY_MARKET_CAP)
+	}
+	if c.HTTPClient.ConnectTimeout < 0 || c.HTTPClient.ReadTimeout < 0 || c.HTTPClient.IdleConnTimeout < 0 {
+		return errors.New("http timeouts must not be negative")
+	}
+	if c.HTTPClient.MaxIdleConns < 0 || c.HTTPClient.MaxIdleConnsPerHost < 0 || c.HTTPClient.MaxConnsPerHost < 0 {
+		return errors.New("http connection limits must not be negative")
+	}
+	for _, encoding := range c.HTTPClient.Compression {
+		if !slices.Contains(HTTP_COMPRESSIONS, encoding) {
+			return fmt.Errorf("unsupported http compression %s, expecting one of %s", encoding, strings.Join(HTTP_COMPRESSIONS, ", "))
+		}
+	}
+	if c.HTTPCache.TTL < 0 {
+		return errors.New("http cache ttl must not be negative")
+	}
+	if c.HTTPCache.Offline && len(c.HTTPCache.Dir) == 0 {
+		return errors.New("http cache dir required when offline")
+	}
+	for dataset, age := range c.Financials.MaxAge {
+		if age < 0 {
+			return fmt.Errorf("max age of dataset %s must not be negative", dataset)
+		}
+	}
+	if c.Financials.FilingLag < 0 {
+		return errors.New("filing lag must not be negative")
+	}
+	if c.EOD.Overlap < 0 {
+		return errors.New("eod overlap must not be negative")
+	}
+	return nil
+}
+
+// Command line flags overriding the configuration. Only flags explicitly set
+// on the command line take effect. Commands register the flags of the
+// subsystems they use.
+type Flags struct {
+	fs         *flag.FlagSet
+	ConfigFile *string
+	values     map[string]*string
+	registered []setting
+}
+
+// Flag of the configuration file only
+func NewFlags(fs *flag.FlagSet) *Flags {
+	return &Flags{
+		fs:         fs,
+		ConfigFile: fs.String("config", os.Getenv("SDC_CONFIG"), "Configuration file in YAML format."),
+		values:     make(map[string]*string),
+	}
+}
+
+// Flags of all the subsystems
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := NewFlags(fs)
+	f.register(settings)
+	return f
+}
+
+func (f *Flags) RegisterDatabaseFlags()   { f.register(databaseSettings) }
+func (f *Flags) RegisterCacheFlags()      { f.register(cacheSettings) }
+func (f *Flags) RegisterEndpointFlags()   { f.register(endpointSettings) }
+func (f *Flags) RegisterRunFlags()        { f.register(runSettings) }
+func (f *Flags) RegisterMetricsFlags()    { f.register(metricsSettings) }
+func (f *Flags) RegisterRetryFlags()      { f.register(retrySettings) }
+func (f *Flags) RegisterProxyFlags()      { f.register(proxySettings) }
+func (f *Flags) RegisterProxyCheckFlags() { f.register(proxyCheckSettings) }
+func (f *Flags) RegisterRateLimitFlags()  { f.register(rateLimitSettings) }
+func (f *Flags) RegisterQueueFlags()      { f.register(queueSettings) }
+func (f *Flags) RegisterHTTPFlags()       { f.register(httpSettings) }
+func (f *Flags) RegisterFinancialsFlags() { f.register(financialsSettings) }
+func (f *Flags) RegisterEODFlags()        { f.register(eodSettings) }
+func (f *Flags) RegisterServeFlags()      { f.register(serveSettings) }
+
+func (f *Flags) register(group []setting) {
+	for _, s := range group {
+		f.values[s.flag] = f.fs.String(s.flag, "", s.usage+" Overrides environment variable "+s.env+".")
+		f.registered = append(f.registered, s)
+	}
+}
+
+func (f *Flags) Apply(c *Config) error {
+	var err error
+	f.fs.Visit(func(fl *flag.Flag) {
+		for _, s := range f.registered {
+			if s.flag == fl.Name && err == nil {
+				if e := s.set(c, *f.values[s.flag]); e != nil {
+					err = fmt.Errorf("invalid flag -%s: %v", s.flag, e)
+				}
+			}
+		}
+	})
+	return err
+}
+
+// Load the configuration file specified by the flags, then apply the flags.
+func (f *Flags) Load() (*Config, error) {
+	c, err := Load(*f.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Apply(c); err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
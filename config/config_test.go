@@ -0,0 +1,358 @@
+package config_test
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wayming/sdc/config"
+)
+
+const TEST_CONFIG_YAML = `
+schema: sdc_staging
+symbol_timeout: 90s
+retry:
+  max_attempts: 5
+  retry_on: [process]
+proxy_pool:
+  cooldown: 30s
+rate_limit:
+  hosts:
+    sa.staging: 2.5
+financials:
+  datasets: [ratios, ratings]
+  max_age:
+    ratings: 12h
+eod:
+  overlap: 72h
+queue:
+  priority: market_cap
+postgres:
+  host: pg.staging
+  port: "5433"
+redis:
+  host: redis.staging
+endpoints:
+  stockanalysis: http://sa.staging
+`
+
+func writeTestConfig(t *testing.T) string {
+	file := filepath.Join(t.TempDir(), "sdc.yaml")
+	if err := os.WriteFile(file, []byte(TEST_CONFIG_YAML), 0644); err != nil {
+		t.Fatalf("Failed to write config file %s. Error: %v", file, err)
+	}
+	return file
+}
+
+func TestLoad(t *testing.T) {
+	file := writeTestConfig(t)
+	t.Setenv("PGHOST", "")
+	t.Setenv("REDISHOST", "redis.env")
+	t.Setenv("SDC_SCHEMA", "")
+	t.Setenv("SDC_SYMBOL_TIMEOUT", "")
+	t.Setenv("SDC_RETRY_MAX_ATTEMPTS", "")
+	t.Setenv("SDC_RETRY_ON", "")
+	t.Setenv("SDC_PROXY_COOLDOWN", "")
+	t.Setenv("SDC_PROXY_BAN_AFTER", "")
+	t.Setenv("SDC_RATE_LIMIT", "")
+	t.Setenv("SDC_DATASETS", "")
+	t.Setenv("SDC_MAX_AGE", "")
+	t.Setenv("SDC_FILING_LAG", "")
+	t.Setenv("SDC_EOD_OVERLAP", "")
+	t.Setenv("SDC_QUEUE_PRIORITY", "")
+	t.Setenv("SDC_DIAGNOSTICS_DIR", "")
+
+	cfg, err := config.Load(file)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"FileOverridesDefault", cfg.SchemaName, "sdc_staging"},
+		{"FileOverridesDefaultNested", cfg.Postgres.Port, "5433"},
+		{"EmptyEnvIgnored", cfg.Postgres.Host, "pg.staging"},
+		{"EnvOverridesFile", cfg.Redis.Host, "redis.env"},
+		{"DefaultKept", cfg.Endpoints.OpenBB, config.DEFAULT_OPENBB_URL},
+		{"FileEndpoint", cfg.Endpoints.StockAnalysis, "http://sa.staging"},
+		{"FileDuration", cfg.SymbolTimeout.String(), "1m30s"},
+		{"DefaultDiagnosticsDir", cfg.DiagnosticsDir, config.DEFAULT_DIAGNOSTICS_DIR},
+		{"FileRetryAttempts", strconv.Itoa(cfg.Retry.MaxAttempts), "5"},
+		{"FileRetryOn", strings.Join(cfg.Retry.RetryOn, ","), "process"},
+		{"DefaultRetryDelay", cfg.Retry.BaseDelay.String(), config.DEFAULT_RETRY_BASE_DELAY.String()},
+		{"FileProxyCooldown", cfg.ProxyPool.Cooldown.String(), "30s"},
+		{"DefaultProxyBanAfter", strconv.Itoa(cfg.ProxyPool.BanAfter), strconv.Itoa(config.DEFAULT_PROXY_BAN_AFTER)},
+		{"DefaultQueueLeaseTTL", cfg.Queue.LeaseTTL.String(), config.DEFAULT_QUEUE_LEASE_TTL.String()},
+		{"FileRateLimit", fmt.Sprint(cfg.RateLimit.Hosts), "map[sa.staging:2.5]"},
+		{"FileDatasets", strings.Join(cfg.Financials.Datasets, ","), "ratios,ratings"},
+		{"FileMaxAge", cfg.Financials.MaxAge["ratings"].String(), "12h0m0s"},
+		{"DefaultMaxAgeKept", cfg.Financials.MaxAge["overview"].String(), "24h0m0s"},
+		{"DefaultFilingLag", cfg.Financials.FilingLag.String(), config.DEFAULT_FILING_LAG.String()},
+		{"FileEODOverlap", cfg.EOD.Overlap.String(), "72h0m0s"},
+		{"FileQueuePriority", cfg.Queue.Priority, config.QUEUE_PRIORITY_MARKET_CAP},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("Load() got %s, want %s", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlags_Load(t *testing.T) {
+	file := writeTestConfig(t)
+	t.Setenv("SDC_SCHEMA", "sdc_env")
+	t.Setenv("REDISHOST", "")
+
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-config", file, "-redis_port", "6380"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if cfg.SchemaName != "sdc_env" {
+		t.Errorf("Expecting schema sdc_env from environment, got %s", cfg.SchemaName)
+	}
+	if cfg.Redis.Port != "6380" {
+		t.Errorf("Expecting redis port 6380 from flag, got %s", cfg.Redis.Port)
+	}
+	if cfg.Redis.Host != "redis.staging" {
+		t.Errorf("Expecting redis host redis.staging from file, got %s", cfg.Redis.Host)
+	}
+}
+
+func TestFlags_Load_Invalid(t *testing.T) {
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-redis_db", "abc"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := flags.Load(); err == nil {
+		t.Errorf("Expecting error for invalid redis db")
+	}
+}
+
+func TestFlags_RegisterCacheFlags(t *testing.T) {
+	t.Setenv("REDISHOST", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags := config.NewFlags(fs)
+	flags.RegisterCacheFlags()
+
+	for _, name := range []string{"config", "redis_host", "redis_port", "redis_db"} {
+		if fs.Lookup(name) == nil {
+			t.Errorf("Expecting flag -%s registered", name)
+		}
+	}
+	for _, name := range []string{"pg_host", "schema", "proxy_file", "http_read_timeout"} {
+		if fs.Lookup(name) != nil {
+			t.Errorf("Expecting flag -%s of other subsystems not registered", name)
+		}
+	}
+	if err := fs.Parse([]string{"-pg_host", "db.test"}); err == nil {
+		t.Errorf("Expecting error for flag -pg_host not registered")
+	}
+
+	if err := fs.Parse([]string{"-redis_host", "redis.test"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if cfg.Redis.Host != "redis.test" {
+		t.Errorf("Expecting redis host redis.test from flag, got %s", cfg.Redis.Host)
+	}
+}
+
+func TestFlags_Load_RetryOn(t *testing.T) {
+	t.Setenv("SDC_RETRY_ON", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-retry_on", "process, too_many_requests,", "-retry_max_delay", "1m"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.Retry.RetryOn, ","); got != "process,too_many_requests" {
+		t.Errorf("Expecting retry classes process,too_many_requests, got %s", got)
+	}
+	if cfg.Retry.MaxDelay != time.Minute {
+		t.Errorf("Expecting retry max delay 1m, got %s", cfg.Retry.MaxDelay)
+	}
+}
+
+func TestFlags_Load_Datasets(t *testing.T) {
+	t.Setenv("SDC_DATASETS", "income")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"--datasets", "ratios, ratings"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.Financials.Datasets, ","); got != "ratios,ratings" {
+		t.Errorf("Expecting datasets ratios,ratings, got %s", got)
+	}
+}
+
+func TestFlags_Load_MaxAge(t *testing.T) {
+	t.Setenv("SDC_MAX_AGE", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-max_age", "ratings=6h, income=720h"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if cfg.Financials.MaxAge["ratings"] != 6*time.Hour || cfg.Financials.MaxAge["income"] != 720*time.Hour {
+		t.Errorf("Expecting max age ratings 6h and income 720h, got %v", cfg.Financials.MaxAge)
+	}
+	if cfg.Financials.MaxAge["overview"] != 24*time.Hour {
+		t.Errorf("Expecting default max age of overview kept, got %v", cfg.Financials.MaxAge)
+	}
+
+	fs = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags = config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-max_age", "ratings"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := flags.Load(); err == nil {
+		t.Errorf("Expecting error for max age without duration")
+	}
+}
+
+func TestFlags_Load_QueuePriority(t *testing.T) {
+	t.Setenv("SDC_QUEUE_PRIORITY", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-queue_priority", "volume"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := flags.Load(); err == nil {
+		t.Errorf("Expecting error for unknown queue priority")
+	}
+}
+
+func TestFlags_Load_RateLimit(t *testing.T) {
+	t.Setenv("SDC_RATE_LIMIT", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-rate_limit", "stockanalysis.com=2, openbb=0.5", "-rate_limit_proxy", "1"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if cfg.RateLimit.Hosts["stockanalysis.com"] != 2 || cfg.RateLimit.Hosts["openbb"] != 0.5 {
+		t.Errorf("Unexpected host rate limits %v", cfg.RateLimit.Hosts)
+	}
+	if cfg.RateLimit.PerProxy != 1 {
+		t.Errorf("Expecting proxy rate limit 1, got %v", cfg.RateLimit.PerProxy)
+	}
+
+	fs = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags = config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-rate_limit", "stockanalysis.com"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := flags.Load(); err == nil {
+		t.Errorf("Expecting error for rate limit without rate")
+	}
+}
+
+func TestFlags_Load_HTTPClient(t *testing.T) {
+	t.Setenv("SDC_HTTP_USER_AGENTS", "")
+	t.Setenv("SDC_HTTP_COMPRESSION", "")
+	t.Setenv("SDC_HTTP_TIMEOUT", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	args := []string{
+		"-http_user_agents", "Mozilla/5.0 (X11; Linux x86_64, rv:125.0) | curl/8.0",
+		"-http_compression", "gzip",
+		"-http_cookies", "false",
+		"-http_read_timeout", "5s",
+		"-http_timeout", "20s",
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.HTTPClient.UserAgents, "|"); got != "Mozilla/5.0 (X11; Linux x86_64, rv:125.0)|curl/8.0" {
+		t.Errorf("Unexpected user agents %s", got)
+	}
+	if strings.Join(cfg.HTTPClient.Compression, ",") != "gzip" || cfg.HTTPClient.Cookies || cfg.HTTPClient.ReadTimeout != 5*time.Second || cfg.HTTPClient.Timeout != 20*time.Second {
+		t.Errorf("Unexpected http client profile %+v", cfg.HTTPClient)
+	}
+	if cfg.HTTPClient.Headers["Accept-Language"] == "" {
+		t.Errorf("Expecting default headers kept, got %v", cfg.HTTPClient.Headers)
+	}
+
+	fs = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags = config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-http_compression", "gzip,zstd"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if _, err := flags.Load(); err == nil {
+		t.Errorf("Expecting error for unsupported compression zstd")
+	}
+}
+
+func TestFlags_Load_ProxyCheck(t *testing.T) {
+	t.Setenv("SDC_PROXY_CHECK_TARGETS", "")
+	fs := flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	args := []string{
+		"-proxy_check_targets", "https://stockanalysis.com/stocks/, http://openbb:6900",
+		"-proxy_check_timeout", "3s",
+		"-proxy_check_workers", "5",
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatalf("Flags.Load() error = %v", err)
+	}
+	if got := strings.Join(cfg.ProxyCheck.Targets, "|"); got != "https://stockanalysis.com/stocks/|http://openbb:6900" {
+		t.Errorf("Unexpected proxy check targets %s", got)
+	}
+	if cfg.ProxyCheck.Timeout != 3*time.Second || cfg.ProxyCheck.Workers != 5 || cfg.ProxyCheck.ProbeURL != config.DEFAULT_PROXY_CHECK_URL {
+		t.Errorf("Unexpected proxy check config %+v", cfg.ProxyCheck)
+	}
+
+	for _, args := range [][]string{{"-proxy_check_workers", "0"}, {"-proxy_check_url", "api.ipify.org"}} {
+		fs = flag.NewFlagSet(t.Name(), flag.ContinueOnError)
+		flags = config.RegisterFlags(fs)
+		if err := fs.Parse(args); err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if _, err := flags.Load(); err == nil {
+			t.Errorf("Expecting error for %v", args)
+		}
+	}
+}
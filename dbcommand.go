@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+)
+
+var dbCommand = &command{
+	name:    "db",
+	summary: "Manage the database schema.",
+	subcommands: []*command{
+		dbDropCommand,
+	},
+}
+
+var dbDropCommand = &command{
+	name:    "drop",
+	summary: "Drop the schema with all the loaded data.",
+	configFlags: func(f *config.Flags) {
+		f.RegisterDatabaseFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		return func(ctx context.Context, cfg *config.Config) error {
+			if err := collector.DropSchema(cfg); err != nil {
+				return err
+			}
+			fmt.Println("Drop schema " + cfg.SchemaName + " done.")
+			return nil
+		}
+	},
+}
@@ -1,14 +1,17 @@
 package dbloader
 
-import "reflect"
+import (
+	"context"
+	"reflect"
+)
 
 type DBLoader interface {
-	Connect(host string, port string, user string, password string, dbname string)
+	Connect(host string, port string, user string, password string, dbname string) error
 	Disconnect()
-	CreateSchema(schema string)
+	CreateSchema(schema string) error
 	DropSchema(schema string) error
 	RunQuery(sql string, structType reflect.Type, args ...any) (interface{}, error)
 	Exec(sql string) error
-	LoadByJsonText(jsonText string, tableName string, jsonStructType reflect.Type) (int64, error)
+	LoadByJsonText(ctx context.Context, jsonText string, tableName string, jsonStructType reflect.Type) (int64, error)
 	CreateTableByJsonStruct(tableName string, jsonStructType reflect.Type) error
 }
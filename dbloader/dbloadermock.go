@@ -5,6 +5,7 @@
 package dbloader
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -34,9 +35,11 @@ func (m *MockDBLoader) EXPECT() *MockDBLoaderMockRecorder {
 }
 
 // Connect mocks base method.
-func (m *MockDBLoader) Connect(host, port, user, password, dbname string) {
+func (m *MockDBLoader) Connect(host, port, user, password, dbname string) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "Connect", host, port, user, password, dbname)
+	ret := m.ctrl.Call(m, "Connect", host, port, user, password, dbname)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // Connect indicates an expected call of Connect.
@@ -46,9 +49,11 @@ func (mr *MockDBLoaderMockRecorder) Connect(host, port, user, password, dbname i
 }
 
 // CreateSchema mocks base method.
-func (m *MockDBLoader) CreateSchema(schema string) {
+func (m *MockDBLoader) CreateSchema(schema string) error {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "CreateSchema", schema)
+	ret := m.ctrl.Call(m, "CreateSchema", schema)
+	ret0, _ := ret[0].(error)
+	return ret0
 }
 
 // CreateSchema indicates an expected call of CreateSchema.
@@ -112,18 +117,18 @@ func (mr *MockDBLoaderMockRecorder) Exec(sql interface{}) *gomock.Call {
 }
 
 // LoadByJsonText mocks base method.
-func (m *MockDBLoader) LoadByJsonText(jsonText, tableName string, jsonStructType reflect.Type) (int64, error) {
+func (m *MockDBLoader) LoadByJsonText(ctx context.Context, jsonText, tableName string, jsonStructType reflect.Type) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "LoadByJsonText", jsonText, tableName, jsonStructType)
+	ret := m.ctrl.Call(m, "LoadByJsonText", ctx, jsonText, tableName, jsonStructType)
 	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // LoadByJsonText indicates an expected call of LoadByJsonText.
-func (mr *MockDBLoaderMockRecorder) LoadByJsonText(jsonText, tableName, jsonStructType interface{}) *gomock.Call {
+func (mr *MockDBLoaderMockRecorder) LoadByJsonText(ctx, jsonText, tableName, jsonStructType interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadByJsonText", reflect.TypeOf((*MockDBLoader)(nil).LoadByJsonText), jsonText, tableName, jsonStructType)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LoadByJsonText", reflect.TypeOf((*MockDBLoader)(nil).LoadByJsonText), ctx, jsonText, tableName, jsonStructType)
 }
 
 // RunQuery mocks base method.
@@ -1,6 +1,7 @@
 package dbloader
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 
 	_ "github.com/lib/pq"
 	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/config"
 	"github.com/wayming/sdc/json2db"
 )
 
@@ -28,7 +30,18 @@ func NewPGLoader(dbSchema string, logger *log.Logger) *PGLoader {
 	return &loader
 }
 
-func (loader *PGLoader) Connect(host string, port string, user string, password string, dbname string) {
+// Create a loader connected to the database described by the configuration.
+func NewPGLoaderByConfig(cfg *config.Config, logger *log.Logger) (*PGLoader, error) {
+	loader := NewPGLoader(cfg.SchemaName, logger)
+	pg := cfg.Postgres
+	if err := loader.Connect(pg.Host, pg.Port, pg.User, pg.Password, pg.Database); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// Connect to the database and create the schema of the loader
+func (loader *PGLoader) Connect(host string, port string, user string, password string, dbname string) error {
 	var err error
 	connectonString := "host=" + host
 	connectonString += " port=" + port
@@ -37,28 +50,30 @@ func (loader *PGLoader) Connect(host string, port string, user string, password
 	connectonString += " dbname=" + dbname
 	connectonString += " sslmode=disable"
 	if loader.db, err = sql.Open("postgres", connectonString); err != nil {
-		loader.logger.Fatal("Failed to connect to database ", dbname, " with user ", user, ", error ", err)
-	} else {
-		loader.logger.Println("Connect to database host=", host, "port=", port, "user=", user, "dbname=", dbname)
+		return fmt.Errorf("failed to connect to database %s with user %s: %v", dbname, user, err)
 	}
+	loader.logger.Println("Connect to database host=", host, "port=", port, "user=", user, "dbname=", dbname)
 
-	loader.CreateSchema(loader.schema)
+	if err := loader.CreateSchema(loader.schema); err != nil {
+		loader.db.Close()
+		return err
+	}
+	return nil
 }
 
 func (loader *PGLoader) Disconnect() {
 	loader.db.Close()
 }
 
-func (loader *PGLoader) CreateSchema(schema string) {
+func (loader *PGLoader) CreateSchema(schema string) error {
 	loader.schema = schema
 	createSchemaSQL := loader.sqlConverter.GenCreateSchema(schema)
 	if _, err := loader.db.Exec(createSchemaSQL); err != nil {
-		loader.logger.Fatal("Failed to execute SQL ", createSchemaSQL, ". Error ", err)
-	} else {
-		loader.logger.Println("Execute SQL: ", createSchemaSQL)
+		return errors.New("Failed to execute SQL " + createSchemaSQL + ". Error: " + err.Error())
 	}
+	loader.logger.Println("Execute SQL: ", createSchemaSQL)
 
-	loader.Exec("SET search_path TO " + schema)
+	return loader.Exec("SET search_path TO " + schema)
 }
 
 func (loader *PGLoader) DropSchema(schema string) error {
@@ -74,8 +89,7 @@ func (loader *PGLoader) DropSchema(schema string) error {
 
 func (loader *PGLoader) Exec(sql string) error {
 	if _, err := loader.db.Exec(sql); err != nil {
-		loader.logger.Fatal("Failed to execute SQL ", sql, ". Error ", err)
-		return err
+		return errors.New("Failed to execute SQL " + sql + ". Error: " + err.Error())
 	} else {
 		loader.logger.Println("Execute SQL: ", sql)
 	}
@@ -152,29 +166,43 @@ func (loader *PGLoader) CreateTableByJsonStruct(tableName string, jsonStructType
 	}
 	loader.logger.Println("SQL=", tableCreateSQL)
 
-	tx, _ := loader.db.Begin()
-	if _, err := tx.Exec(tableCreateSQL); err != nil {
-		tx.Rollback()
-		return errors.New("Failed to execute SQL " + tableCreateSQL + ". Error: " + err.Error())
-	} else {
-		loader.logger.Println("Execute SQL: ", tableCreateSQL)
+	// Add the columns missing from the tables created before
+	addColumnsSQL, err := converter.GenAddColumns(tableName, jsonStructType)
+	if err != nil {
+		return err
+	}
+
+	tx, err := loader.db.Begin()
+	if err != nil {
+		return errors.New("Failed to start transaction. Error: " + err.Error())
+	}
+	for _, sql := range []string{tableCreateSQL, addColumnsSQL} {
+		if len(sql) == 0 {
+			continue
+		}
+		if _, err := tx.Exec(sql); err != nil {
+			tx.Rollback()
+			return errors.New("Failed to execute SQL " + sql + ". Error: " + err.Error())
+		} else {
+			loader.logger.Println("Execute SQL: ", sql)
+		}
 	}
 	tx.Commit()
 	return nil
 }
 
-func (loader *PGLoader) LoadByJsonText(jsonText string, tableName string, jsonStructType reflect.Type) (int64, error) {
+func (loader *PGLoader) LoadByJsonText(ctx context.Context, jsonText string, tableName string, jsonStructType reflect.Type) (int64, error) {
 	loader.logger.Println("Load JSON text:", jsonText)
 
 	if loader.schema == "" {
-		loader.logger.Fatal("Schema must be created first")
+		return 0, errors.New("schema must be created first")
 	}
 
 	// Query to get the current search_path
 	var searchPath string
-	err := loader.db.QueryRow("SHOW search_path").Scan(&searchPath)
+	err := loader.db.QueryRowContext(ctx, "SHOW search_path").Scan(&searchPath)
 	if err != nil {
-		loader.logger.Fatalf("database QueryRow failed: %v", err)
+		return 0, fmt.Errorf("database QueryRow failed: %v", err)
 	}
 	loader.logger.Printf("Current search_path: %s, loader database schema: %s\n", searchPath, loader.schema)
 
@@ -187,15 +215,14 @@ func (loader *PGLoader) LoadByJsonText(jsonText string, tableName string, jsonSt
 		return 0, err
 	}
 
-	// Start a transaction
-	tx, err := loader.db.Begin()
+	// Start a transaction. It is rolled back if the context is done before commit.
+	tx, err := loader.db.BeginTx(ctx, nil)
 	if err != nil {
-		tx.Rollback()
 		return 0, errors.New("Failed to start transaction . Error: " + err.Error())
 	}
 
 	loader.logger.Printf("Execute SQL %s", sql)
-	result, err := tx.Exec(sql)
+	result, err := tx.ExecContext(ctx, sql)
 	if err != nil {
 		tx.Rollback()
 		return 0, fmt.Errorf("failed to execute sql %s. Error: %v", sql, err)
@@ -1,6 +1,7 @@
 package dbloader_test
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"sort"
@@ -122,7 +123,7 @@ func TestPGLoader_LoadByJsonText(t *testing.T) {
 	wantInserts := int64(2)
 	wantSymbols := []string{"MSFT", "AAPL"}
 	t.Run("LoadByJsonText", func(t *testing.T) {
-		got, err := testFixture.Loader().LoadByJsonText(JSON_TEXT2, TEST_TABLE, reflect.TypeFor[Tickers]())
+		got, err := testFixture.Loader().LoadByJsonText(context.Background(), JSON_TEXT2, TEST_TABLE, reflect.TypeFor[Tickers]())
 		if err != nil {
 			t.Errorf("PGLoader.LoadByJsonText() error = %v", err)
 			return
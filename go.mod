@@ -3,9 +3,25 @@ module github.com/wayming/sdc
 go 1.22.1
 
 require (
-	github.com/go-redis/redis v6.15.9+incompatible // indirect
-	github.com/golang/mock v1.6.0 // indirect
-	github.com/lib/pq v1.10.9 // indirect
-	golang.org/x/net v0.28.0 // indirect
-	golang.org/x/time v0.5.0 // indirect
+	github.com/alicebob/miniredis/v2 v2.33.0
+	github.com/andybalholm/brotli v1.1.1
+	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/golang/mock v1.6.0
+	github.com/lib/pq v1.10.9
+	github.com/prometheus/client_golang v1.19.1
+	golang.org/x/net v0.28.0
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/alicebob/gopher-json v0.0.0-20200520072559-a9ecdc9d1d3a // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+)
+
+var historyCommand = &command{
+	name:    "history",
+	summary: "Show the runs and the outcomes of the symbols recorded in the database.",
+	configFlags: func(f *config.Flags) {
+		f.RegisterDatabaseFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		runs := fs.Bool("runs", false, "List the runs instead of the outcomes of the symbols.")
+		runID := fs.String("run", "", "Show the given run only.")
+		symbol := fs.String("symbol", "", "Show the outcomes of the symbol only.")
+		status := fs.String("status", "", "Show the outcomes with the status only, e.g. succeeded, failed, invalid, dead.")
+		since := fs.String("since", "168h", "Show the history since the date (2006-01-02) or for the duration before now (e.g. 24h).")
+		limit := fs.Int("limit", 50, "Maximum number of rows to show.")
+
+		return func(ctx context.Context, cfg *config.Config) error {
+			from, err := parseSince(*since)
+			if err != nil {
+				return err
+			}
+			if *limit < 1 {
+				return newUsageError("-limit must be at least 1, got %d", *limit)
+			}
+			if *runs && (len(*symbol) > 0 || len(*status) > 0) {
+				return newUsageError("-runs can not be used with -symbol or -status")
+			}
+			filter := collector.HistoryFilter{RunID: *runID, Symbol: *symbol, Status: *status, Since: from, Limit: *limit}
+
+			history := collector.NewRunHistoryByConfig(cfg)
+			defer history.Close()
+			if *runs {
+				rows, err := history.Runs(filter)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%-26s %-10s %-20s %-9s %8s %8s %8s %8s %8s %8s %8s\n",
+					"RUN", "KIND", "STARTED", "RESULT", "SYMBOLS", "UNITS", "DONE", "SUCCESS", "FAILED", "INVALID", "DEAD")
+				for _, r := range rows {
+					fmt.Printf("%-26s %-10s %-20s %-9s %8d %8d %8d %8d %8d %8d %8d\n",
+						r.RunID, r.Kind, r.StartTime.Format(time.DateTime), runResult(r), r.Symbols, r.Units,
+						r.Processed, r.Succeeded, r.Failed, r.Invalid, r.Dead)
+				}
+				return nil
+			}
+
+			rows, err := history.Items(filter)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-20s %-10s %-10s %-10s %4s %8s %-26s %s\n",
+				"FINISHED", "SYMBOL", "DATASET", "STATUS", "HTTP", "SECONDS", "RUN", "ERROR")
+			for _, r := range rows {
+				fmt.Printf("%-20s %-10s %-10s %-10s %4d %8.2f %-26s %s\n",
+					r.FinishedAt.Format(time.DateTime), r.Symbol, r.Dataset, r.Status, r.HTTPStatus,
+					r.DurationSeconds, r.RunID, r.ErrorText)
+			}
+			return nil
+		}
+	},
+}
+
+func runResult(r collector.RunRow) string {
+	switch {
+	case r.Cancelled:
+		return "cancelled"
+	case r.Complete:
+		return "complete"
+	}
+	return "partial"
+}
+
+// Date or duration before now
+func parseSince(text string) (time.Time, error) {
+	if d, err := time.ParseDuration(text); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.DateOnly, text); err == nil {
+		return t, nil
+	}
+	return time.Time{}, newUsageError("-since expects a date like 2006-01-02 or a duration like 24h, got %s", text)
+}
@@ -17,6 +17,9 @@ const NESTED_STRUCT_KEY = "Name"
 const TAG_DB = "db"
 const TAG_DB_PRIMARYKEY = "PrimaryKey"
 
+// Columns set when the row is inserted and kept when the row is updated
+const TAG_DB_INSERTONLY = "InsertOnly"
+
 type JsonToPGSQLConverter struct {
 }
 
@@ -71,6 +74,27 @@ func (d *JsonToPGSQLConverter) GenCreateTable(tableName string, entityStructType
 	return ddl, nil
 }
 
+// Generate SQL adding the columns missing from a table created by an earlier
+// version of the struct. Key columns can not be added. Like the other
+// generators, the column names are the lowercased field names, e.g.
+// collectedat for CollectedAt.
+func (d *JsonToPGSQLConverter) GenAddColumns(tableName string, entityStructType reflect.Type) (string, error) {
+	_, nonKeyFields := d.ExtractFieldData(entityStructType)
+	if len(nonKeyFields) == 0 {
+		return "", nil
+	}
+
+	var clauses []string
+	for _, name := range Keys(nonKeyFields) {
+		colType, err := d.deriveColType(nonKeyFields[name])
+		if err != nil {
+			return "", fmt.Errorf("failed to derive type for field %s: %v", name, err)
+		}
+		clauses = append(clauses, "ADD COLUMN IF NOT EXISTS "+strings.ToLower(name)+" "+colType)
+	}
+	return "ALTER TABLE " + tableName + " " + strings.Join(clauses, ", ") + ";", nil
+}
+
 // Unmarshals the specified JSON text that represents array of entities.
 // Returns insert SQL with slice of rows. Each row is a slice with each element represents a field value.
 func (d *JsonToPGSQLConverter) GenInsertSQL(jsonText string, tableName string, entityStructType reflect.Type) (string, [][]interface{}, error) {
@@ -89,7 +113,7 @@ func (d *JsonToPGSQLConverter) GenInsertSQL(jsonText string, tableName string, e
 		}
 		placeHolderList += "$" + strconv.Itoa(index+1)
 	}
-	onConflitsClause := d.OnConflitsSQL(tableName, Keys(keyFields), Keys(nonKeyFields))
+	onConflitsClause := d.OnConflitsSQL(tableName, Keys(keyFields), updatableFields(entityStructType, nonKeyFields))
 
 	sql = fmt.Sprintf("\nINSERT INTO %s (\n\t%s\n)\nVALUES (\n\t%s\n) %s",
 		tableName, colLists, placeHolderList, onConflitsClause)
@@ -160,7 +184,7 @@ func (d *JsonToPGSQLConverter) GenBulkInsertSQL(jsonText string, tableName strin
 	}
 	colList := strings.ToLower(strings.Join(allFields, ", "))
 	valueList := strings.Join(valuesOfRows, ",\n\t")
-	onConflictClause := d.OnConflitsSQL(tableName, Keys(keyFields), Keys(nonKeyFields))
+	onConflictClause := d.OnConflitsSQL(tableName, Keys(keyFields), updatableFields(entityStructType, nonKeyFields))
 	sql = fmt.Sprintf("\nINSERT INTO %s (\n\t%s\n)\nVALUES\n\t%s\n%s",
 		tableName, colList, valueList, onConflictClause)
 
@@ -285,6 +309,18 @@ func keyFields(rtype reflect.Type) map[string]reflect.Type {
 	return keyFields
 }
 
+// Non-key fields updated on conflicts, in the order of Keys
+func updatableFields(rtype reflect.Type, nonKeyFields map[string]reflect.Type) []string {
+	var fields []string
+	for _, name := range Keys(nonKeyFields) {
+		if field, ok := rtype.FieldByName(name); ok && field.Tag.Get(TAG_DB) == TAG_DB_INSERTONLY {
+			continue
+		}
+		fields = append(fields, name)
+	}
+	return fields
+}
+
 func NVL(val interface{}, defaultVal interface{}) interface{} {
 	if val == nil {
 		return defaultVal
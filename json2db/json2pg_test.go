@@ -183,3 +183,46 @@ ON CONFLICT (
 		}
 	})
 }
+
+type JsonEntityTimestampStruct struct {
+	Field1      string    `json:"field1" db:"PrimaryKey"`
+	Field2      int       `json:"field2"`
+	CollectedAt time.Time `json:"collected_at" db:"InsertOnly"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+func TestJsonToPGSQLConverter_GenAddColumns(t *testing.T) {
+	wantSQL := "ALTER TABLE json2pg_test " +
+		"ADD COLUMN IF NOT EXISTS collectedat timestamp, " +
+		"ADD COLUMN IF NOT EXISTS field2 integer, " +
+		"ADD COLUMN IF NOT EXISTS updatedat timestamp;"
+
+	got, err := NewJsonToPGSQLConverter().GenAddColumns(TEST_TABLE, reflect.TypeFor[JsonEntityTimestampStruct]())
+	if err != nil {
+		t.Fatalf("JsonToPGSQLConverter.GenAddColumns() error = %v", err)
+	}
+	if got != wantSQL {
+		t.Errorf("JsonToPGSQLConverter.GenAddColumns() = %v, want %v", got, wantSQL)
+	}
+}
+
+func TestJsonToPGSQLConverter_GenBulkInsertSQL_InsertOnly(t *testing.T) {
+	jsonText := `[{"field1": "strVal", "field2": 10, "collected_at": "2024-07-30T12:00:00Z", "updated_at": "2024-07-31T12:00:00Z"}]`
+	wantConflict := `ON CONFLICT (
+	field1
+) DO UPDATE SET
+	field1 = EXCLUDED.field1,
+	field2 = EXCLUDED.field2,
+	updatedat = EXCLUDED.updatedat
+`
+	gotSQL, err := NewJsonToPGSQLConverter().GenBulkInsertSQL(jsonText, TEST_TABLE, reflect.TypeFor[JsonEntityTimestampStruct]())
+	if err != nil {
+		t.Fatalf("JsonToPGSQLConverter.GenBulkInsertSQL() error = %v", err)
+	}
+	if !strings.Contains(gotSQL, "'2024-07-30T12:00:00Z'") {
+		t.Errorf("Expecting the insert-only column inserted, got %v", gotSQL)
+	}
+	if !strings.HasSuffix(gotSQL, wantConflict) {
+		t.Errorf("JsonToPGSQLConverter.GenBulkInsertSQL() gotSQL = %v, want conflict clause %v", gotSQL, wantConflict)
+	}
+}
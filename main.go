@@ -1,96 +1,214 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 
 	"github.com/wayming/sdc/collector"
 	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/metrics"
 )
 
+// Exit codes
+const (
+	EXIT_SUCCESS = 0
+	EXIT_FAILURE = 1
+	EXIT_USAGE   = 2
+	EXIT_PARTIAL = 3 // Some symbols failed or were left unprocessed, see the run report
+)
+
+// A node of the command tree. Leaf commands have a setup function, the
+// others dispatch to their subcommands.
+type command struct {
+	name        string
+	summary     string
+	subcommands []*command
+	// The command serves the metrics on its own address instead of metrics_addr
+	servesMetrics bool
+	// Register the flags of the command and return the function running it.
+	// The context is cancelled on SIGINT or SIGTERM.
+	setup func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error
+	// Register the configuration flags of the subsystems used by the command
+	configFlags func(f *config.Flags)
+}
+
+// Invalid command line. The usage of the command is printed and the process
+// exits with EXIT_USAGE.
+type usageError struct {
+	text string
+}
+
+func newUsageError(format string, a ...any) usageError {
+	return usageError{text: fmt.Sprintf(format, a...)}
+}
+
+func (e usageError) Error() string {
+	return e.text
+}
+
+var rootCommand = &command{
+	name:    "sdc",
+	summary: "Stock data collector.",
+	subcommands: []*command{
+		loadCommand,
+		workerCommand,
+		cacheCommand,
+		dbCommand,
+		statusCommand,
+		historyCommand,
+		serveCommand,
+		proxiesCommand,
+	},
+}
+
 func main() {
 
 	runtime.GOMAXPROCS(200)
 
-	loadOpt := flag.String("load", "",
-		"Load stock infomration into database. "+
-			"Supported options include:\n"+
-			"tickers: Download tickers information from YF and load them into database.\n"+
-			"EOD: Download EOD for all tickers from YF and load them into database.\n"+
-			"financials: Download financial data from SA and load them into database.")
-	tickersJSONOpt := flag.String("tickers_json", "", "Load tickers from JSON file instead of YF. The csv file name is used as the table name.")
-	symbolOpt := flag.String("symbol", "", "Load financials for the specified symbol only. Can only be used with option -load financialOverviews or financialDetails")
-	parallelOpt := flag.Int("parallel", 1, "Parallel streams of loading")
-	resetDBOpt := flag.Bool("reset_db", false, "Drop the existing data.")
-	resetCacheOpt := flag.Bool("reset_cache", false, "Reset caches.")
-	proxyOpt := flag.String("proxy", "", "File with list of proxy servers.")
-	continueOpt := flag.Bool("continue", false, "Whether or not continue with the load")
-
-	flag.Parse()
-
-	if symbolOpt == nil && proxyOpt == nil {
-		flag.Usage()
-		fmt.Println("proxy file required when loading financial for multiple symbols.")
+	os.Exit(rootCommand.execute(rootCommand.name, os.Args[1:]))
+}
+
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func (c *command) execute(path string, args []string) int {
+	if c.setup == nil {
+		if len(args) == 0 {
+			c.printUsage(path)
+			return EXIT_USAGE
+		}
+		if isHelp(args[0]) {
+			c.printUsage(path)
+			return EXIT_SUCCESS
+		}
+		for _, sub := range c.subcommands {
+			if sub.name == args[0] {
+				return sub.execute(path+" "+sub.name, args[1:])
+			}
+		}
+		fmt.Fprintf(os.Stderr, "Unknown command %s %s\n\n", path, args[0])
+		c.printUsage(path)
+		return EXIT_USAGE
 	}
-	var err error
-	if *resetDBOpt {
-		if err := collector.DropSchema(config.SchemaName); err != nil {
-			fmt.Println(err.Error())
-		} else {
-			fmt.Println("Drop schema " + config.SchemaName + " done.")
+
+	fs, configFlags, run := c.newFlagSet(path)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return EXIT_SUCCESS
 		}
+		return EXIT_USAGE
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments %v\n", fs.Args())
+		fmt.Fprintf(os.Stderr, "Run '%s -h' for usage.\n", path)
+		return EXIT_USAGE
 	}
-	if *resetCacheOpt {
-		if err := collector.ClearCache(); err != nil {
-			fmt.Println(err.Error())
-		} else {
-			fmt.Println("Reset cache done.")
+
+	cfg, err := configFlags.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return EXIT_USAGE
+	}
+
+	if len(cfg.MetricsAddr) > 0 && !c.servesMetrics {
+		srv, err := metrics.Serve(cfg.MetricsAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return EXIT_FAILURE
 		}
+		defer srv.Close()
 	}
 
-	params := collector.PCParams{
-		IsContinue:  *continueOpt,
-		TickersJSON: *tickersJSONOpt,
-		ProxyFile:   *proxyOpt,
+	// The first signal cancels the command, the second one kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := run(ctx, cfg); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		var uerr usageError
+		if errors.As(err, &uerr) {
+			fmt.Fprintf(os.Stderr, "Run '%s -h' for usage.\n", path)
+			return EXIT_USAGE
+		}
+		var ierr collector.RunIncompleteError
+		if errors.As(err, &ierr) {
+			return EXIT_PARTIAL
+		}
+		return EXIT_FAILURE
 	}
-	if len(*loadOpt) > 0 {
-		switch *loadOpt {
-		case "tickers":
-			err = collector.YFCollect(*tickersJSONOpt, true, false)
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			} else {
-				fmt.Println("Complete collecting tickers")
-			}
-		case "EOD":
-			col := collector.NewEODParallelCollector(params)
-			if err := col.Execute(*parallelOpt); err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			} else {
-				fmt.Println("Complete collecting EODs for tickers")
-			}
-		case "financials":
-			if len(*symbolOpt) > 0 {
-				err = collector.CollectFinancialsForSymbol(*symbolOpt)
-			} else {
-				pCollector := collector.NewFinancialParallelCollector(params)
-				err = pCollector.Execute(*parallelOpt)
-			}
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			} else {
-				fmt.Println("Complete collecting financials")
-			}
-		default:
-			fmt.Println("Unknown load option " + *loadOpt)
-			os.Exit(1)
+	return EXIT_SUCCESS
+}
+
+// Flag set of a leaf command with its own flags and the configuration flags of
+// its subsystems, and the function running the command.
+func (c *command) newFlagSet(path string) (*flag.FlagSet, *config.Flags, func(ctx context.Context, cfg *config.Config) error) {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	run := c.setup(fs)
+	ownFlags := make(map[string]bool)
+	fs.VisitAll(func(f *flag.Flag) {
+		ownFlags[f.Name] = true
+	})
+	configFlags := config.NewFlags(fs)
+	if c.configFlags != nil {
+		c.configFlags(configFlags)
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\n%s\n", path, c.summary)
+		if len(ownFlags) > 0 {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			printFlags(fs, func(name string) bool { return ownFlags[name] })
 		}
+		fmt.Fprintf(fs.Output(), "\nConfiguration flags:\n")
+		printFlags(fs, func(name string) bool { return !ownFlags[name] })
+	}
+	return fs, configFlags, run
+}
+
+// Configuration flags of the commands running parallel collectors, whatever
+// the datasets collected.
+func registerCollectorConfigFlags(f *config.Flags) {
+	f.RegisterDatabaseFlags()
+	f.RegisterCacheFlags()
+	f.RegisterEndpointFlags()
+	f.RegisterRunFlags()
+	f.RegisterRetryFlags()
+	f.RegisterProxyFlags()
+	f.RegisterProxyCheckFlags()
+	f.RegisterRateLimitFlags()
+	f.RegisterQueueFlags()
+	f.RegisterHTTPFlags()
+}
 
+func (c *command) printUsage(path string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\n%s\n\nCommands:\n", path, c.summary)
+	for _, sub := range c.subcommands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", sub.name, sub.summary)
 	}
-	os.Exit(0)
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", path)
+	fmt.Fprintf(os.Stderr, "Exit codes: %d success, %d failure, %d invalid command line, %d some symbols failed or left unprocessed.\n",
+		EXIT_SUCCESS, EXIT_FAILURE, EXIT_USAGE, EXIT_PARTIAL)
+}
+
+func printFlags(fs *flag.FlagSet, filter func(name string) bool) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if !filter(f.Name) {
+			return
+		}
+		fmt.Fprintf(fs.Output(), "  -%s\n    \t%s", f.Name, f.Usage)
+		if len(f.DefValue) > 0 && f.DefValue != "false" {
+			fmt.Fprintf(fs.Output(), " (default %s)", f.DefValue)
+		}
+		fmt.Fprintln(fs.Output())
+	})
 }
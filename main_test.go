@@ -0,0 +1,188 @@
+package main
+
+import (
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Leaf commands of the tree by path
+func leafCommands(path string, c *command, leaves map[string]*command) {
+	if c.setup != nil {
+		leaves[path] = c
+		return
+	}
+	for _, sub := range c.subcommands {
+		leafCommands(path+" "+sub.name, sub, leaves)
+	}
+}
+
+// Discard the usage printed by the commands
+func discardStderr(t *testing.T) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("Failed to open %s. Error: %v", os.DevNull, err)
+	}
+	stderr := os.Stderr
+	os.Stderr = devNull
+	t.Cleanup(func() {
+		os.Stderr = stderr
+		devNull.Close()
+	})
+}
+
+func TestCommandTree(t *testing.T) {
+	leaves := make(map[string]*command)
+	leafCommands(rootCommand.name, rootCommand, leaves)
+
+	want := []string{
+		"sdc cache clear", "sdc db drop", "sdc history", "sdc load eod", "sdc load financials",
+		"sdc load tickers", "sdc proxies check", "sdc serve", "sdc status", "sdc worker",
+	}
+	var got []string
+	for path := range leaves {
+		got = append(got, path)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("Expecting leaf commands %v, got %v", want, got)
+	}
+
+	var check func(path string, c *command)
+	check = func(path string, c *command) {
+		if len(c.summary) == 0 {
+			t.Errorf("Expecting summary of command %s", path)
+		}
+		if c.setup != nil && len(c.subcommands) > 0 {
+			t.Errorf("Expecting either setup or subcommands of command %s", path)
+		}
+		if c.setup == nil && c.configFlags != nil {
+			t.Errorf("Expecting configuration flags of leaf commands only, got them on %s", path)
+		}
+		names := make(map[string]bool)
+		for _, sub := range c.subcommands {
+			if names[sub.name] {
+				t.Errorf("Duplicate subcommand %s of %s", sub.name, path)
+			}
+			names[sub.name] = true
+			check(path+" "+sub.name, sub)
+		}
+	}
+	check(rootCommand.name, rootCommand)
+}
+
+func TestCommand_Flags(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []string
+		notWant []string
+	}{
+		{"sdc cache clear", []string{"config", "redis_host", "redis_db"}, []string{"pg_host", "schema", "proxy_file", "http_read_timeout", "metrics_addr"}},
+		{"sdc db drop", []string{"config", "schema", "pg_host"}, []string{"redis_host", "datasets"}},
+		{"sdc status", []string{"schema", "pg_host", "redis_host"}, []string{"proxy_file", "http_read_timeout"}},
+		{"sdc history", []string{"runs", "since", "pg_host"}, []string{"redis_host", "sa_url"}},
+		{"sdc load tickers", []string{"tickers_json", "offline", "pg_host", "http_read_timeout", "metrics_addr"}, []string{"redis_host", "datasets", "eod_overlap"}},
+		{"sdc load eod", []string{"full", "parallel", "distributed", "eod_overlap", "queue_lease_ttl", "metrics_addr"}, []string{"datasets", "max_age"}},
+		{"sdc load financials", []string{"symbol", "force", "parallel", "datasets", "max_age", "proxy_file", "retry_on"}, []string{"eod_overlap"}},
+		{"sdc worker", []string{"run", "parallel", "datasets", "eod_overlap", "redis_host"}, []string{"offline"}},
+		{"sdc serve", []string{"addr", "datasets", "eod_overlap", "serve_token", "serve_files_dir"}, []string{"metrics_addr"}},
+		{"sdc proxies check", []string{"cached", "stored", "proxy_file", "redis_host", "http_read_timeout"}, []string{"pg_host", "datasets"}},
+	}
+
+	leaves := make(map[string]*command)
+	leafCommands(rootCommand.name, rootCommand, leaves)
+	for _, tt := range tests {
+		t.Run(strings.ReplaceAll(tt.path, " ", "_"), func(t *testing.T) {
+			c, ok := leaves[tt.path]
+			if !ok {
+				t.Fatalf("Command %s not found", tt.path)
+			}
+			fs, _, _ := c.newFlagSet(tt.path)
+			for _, name := range tt.want {
+				if fs.Lookup(name) == nil {
+					t.Errorf("Expecting flag -%s of %s", name, tt.path)
+				}
+			}
+			for _, name := range tt.notWant {
+				if fs.Lookup(name) != nil {
+					t.Errorf("Expecting no flag -%s of %s", name, tt.path)
+				}
+			}
+		})
+	}
+}
+
+func TestCommand_Execute_Usage(t *testing.T) {
+	t.Setenv("SDC_CONFIG", "")
+	t.Setenv("SDC_METRICS_ADDR", "")
+	t.Setenv("SDC_SERVE_TOKEN", "")
+	discardStderr(t)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"NoCommand", nil, EXIT_USAGE},
+		{"Help", []string{"help"}, EXIT_SUCCESS},
+		{"UnknownCommand", []string{"unknown"}, EXIT_USAGE},
+		{"SubcommandHelp", []string{"load", "-h"}, EXIT_SUCCESS},
+		{"LeafHelp", []string{"load", "financials", "-h"}, EXIT_SUCCESS},
+		{"UnknownFlag", []string{"cache", "clear", "-unknown"}, EXIT_USAGE},
+		{"FlagOfOtherSubsystem", []string{"cache", "clear", "-pg_host", "db.test"}, EXIT_USAGE},
+		{"UnexpectedArguments", []string{"history", "extra"}, EXIT_USAGE},
+		{"InvalidConfigFlag", []string{"status", "-redis_db", "abc"}, EXIT_USAGE},
+		{"MissingFlag", []string{"worker"}, EXIT_USAGE},
+		{"InvalidFlag", []string{"history", "-limit", "0"}, EXIT_USAGE},
+		{"ConflictingFlags", []string{"load", "financials", "-symbol", "AAPL", "-parallel", "2"}, EXIT_USAGE},
+		{"MissingConfigFile", []string{"status", "-config", "/nonexistent/sdc.yaml"}, EXIT_USAGE},
+		{"ServeAllInterfacesWithoutToken", []string{"serve", "-addr", ":5001"}, EXIT_USAGE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rootCommand.execute(rootCommand.name, tt.args); got != tt.want {
+				t.Errorf("execute(%v) = %d, want %d", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_Usage(t *testing.T) {
+	leaves := make(map[string]*command)
+	leafCommands(rootCommand.name, rootCommand, leaves)
+	fs, _, _ := leaves["sdc cache clear"].newFlagSet("sdc cache clear")
+
+	var out strings.Builder
+	fs.SetOutput(&out)
+	fs.Usage()
+	fs.SetOutput(io.Discard)
+	usage := out.String()
+	if !strings.Contains(usage, "Configuration flags:") || !strings.Contains(usage, "-redis_host") {
+		t.Errorf("Expecting the redis flags in the usage, got %s", usage)
+	}
+	if strings.Contains(usage, "-pg_host") {
+		t.Errorf("Expecting no postgres flags in the usage, got %s", usage)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"127.0.0.1:5001", true},
+		{"localhost:5001", true},
+		{"[::1]:5001", true},
+		{":5001", false},
+		{"0.0.0.0:5001", false},
+		{"10.0.0.5:5001", false},
+		{"5001", false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.addr); got != tt.want {
+			t.Errorf("isLoopback(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path the metrics are served on
+const METRICS_PATH = "/metrics"
+
+// Registry of the metrics of the process, with the metrics of the Go runtime
+// and of the process itself
+var Default = prometheus.NewRegistry()
+
+func init() {
+	Default.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serving the metrics of the default registry in the format
+// negotiated with the scraper
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Default, promhttp.HandlerOpts{})
+}
+
+// Serve the metrics of the default registry on METRICS_PATH at the address,
+// e.g. :5001. The listener is opened before returning, so that a port in use
+// is reported to the caller.
+func Serve(addr string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s for metrics: %v", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(METRICS_PATH, Handler())
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return srv, nil
+}
@@ -0,0 +1,61 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wayming/sdc/metrics"
+)
+
+func TestHandler(t *testing.T) {
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_requests_total",
+		Help: "Requests by host.",
+	}, []string{"host", "code"})
+	metrics.Default.MustRegister(requests)
+	defer metrics.Default.Unregister(requests)
+	requests.WithLabelValues("sa.com", "200").Inc()
+	requests.WithLabelValues("sa.com", "429").Add(2)
+	requests.WithLabelValues(`a"b`, "error").Inc()
+
+	srv := httptest.NewServer(metrics.Handler())
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to get metrics. Error: %v", err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Unexpected content type %s", res.Header.Get("Content-Type"))
+	}
+
+	want := `# HELP test_requests_total Requests by host.
+# TYPE test_requests_total counter
+test_requests_total{code="200",host="sa.com"} 1
+test_requests_total{code="429",host="sa.com"} 2
+test_requests_total{code="error",host="a\"b"} 1
+`
+	if !strings.Contains(string(body), want) {
+		t.Errorf("Expecting the metrics to contain\n%s\ngot\n%s", want, body)
+	}
+	// Metrics of the Go runtime and of the process
+	if !strings.Contains(string(body), "\ngo_goroutines ") {
+		t.Errorf("Expecting the metrics of the Go runtime, got\n%s", body)
+	}
+}
+
+func TestServe(t *testing.T) {
+	srv, err := metrics.Serve("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	srv.Close()
+	if _, err := metrics.Serve("256.0.0.1:0"); err == nil || !strings.Contains(err.Error(), "metrics") {
+		t.Errorf("Expecting error listening on an invalid address, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/proxy"
+)
+
+var proxiesCommand = &command{
+	name:    "proxies",
+	summary: "Manage the proxy servers.",
+	subcommands: []*command{
+		proxiesCheckCommand,
+	},
+}
+
+var proxiesCheckCommand = &command{
+	name:    "check",
+	summary: "Check the health of the proxy servers and show the results.",
+	configFlags: func(f *config.Flags) {
+		f.RegisterCacheFlags()
+		f.RegisterEndpointFlags()
+		f.RegisterProxyFlags()
+		f.RegisterProxyCheckFlags()
+		f.RegisterHTTPFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		proxyFile := fs.String("proxy", "", "File with list of proxy servers to check. Defaults to the proxy file of the configuration.")
+		cached := fs.Bool("cached", false, "Check the proxies loaded into the cache instead of a file.")
+		stored := fs.Bool("stored", false, "Show the results of the last checks without checking again.")
+
+		return func(ctx context.Context, cfg *config.Config) error {
+			if *stored && (*cached || len(*proxyFile) > 0) {
+				return newUsageError("-stored can not be used with -proxy or -cached")
+			}
+			if *cached && len(*proxyFile) > 0 {
+				return newUsageError("-cached can not be used with -proxy")
+			}
+
+			cm := cache.NewCacheManager(cfg)
+			if err := cm.Connect(); err != nil {
+				return err
+			}
+			defer cm.Disconnect()
+
+			if *stored {
+				results, err := cache.ProxyHealth(cm, collector.CACHE_KEY_PROXY_HEALTH)
+				if err != nil {
+					return err
+				}
+				printProxyHealth(results)
+				return nil
+			}
+
+			var proxies []proxy.Proxy
+			if *cached {
+				records, err := cm.GetAllFromSet(collector.CACHE_KEY_PROXY)
+				if err != nil {
+					return err
+				}
+				for _, record := range records {
+					if p, err := proxy.Parse(record); err == nil {
+						proxies = append(proxies, p)
+					}
+				}
+			} else {
+				if len(*proxyFile) == 0 {
+					*proxyFile = cfg.ProxyFile
+				}
+				var err error
+				if proxies, err = cache.ReadProxies(*proxyFile); err != nil {
+					return err
+				}
+			}
+
+			results := cache.CheckProxies(ctx, cm, collector.CACHE_KEY_PROXY_HEALTH, proxies, proxy.NewCheckerByConfig(cfg))
+			printProxyHealth(results)
+			return nil
+		}
+	},
+}
+
+func printProxyHealth(results []proxy.CheckResult) {
+	healthy := 0
+	fmt.Printf("%-36s %-12s %10s %10s %-16s %-12s %-20s %s\n",
+		"PROXY", "STATUS", "CONNECT_MS", "PROBE_MS", "EXIT_IP", "TARGETS", "CHECKED", "ERROR")
+	for _, r := range results {
+		if r.Healthy() {
+			healthy++
+		}
+		fmt.Printf("%-36s %-12s %10d %10d %-16s %-12s %-20s %s\n",
+			r.Proxy, r.Status, r.ConnectLatency.Milliseconds(), r.Latency.Milliseconds(), r.ExitIP,
+			targetStatuses(r.Targets), r.CheckedAt.Local().Format(time.DateTime), r.Error)
+	}
+	fmt.Printf("\n%d of %d proxies healthy.\n", healthy, len(results))
+}
+
+// HTTP statuses of the targets, in the order of the targets
+func targetStatuses(targets []proxy.TargetResult) string {
+	statuses := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if t.HTTPStatus == 0 {
+			statuses = append(statuses, "-")
+		} else {
+			statuses = append(statuses, fmt.Sprint(t.HTTPStatus))
+		}
+	}
+	return strings.Join(statuses, ",")
+}
@@ -0,0 +1,260 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wayming/sdc/config"
+)
+
+// Statuses of the proxy checks
+const (
+	CHECK_OK          = "ok"
+	CHECK_UNREACHABLE = "unreachable" // Failed to connect to the proxy
+	CHECK_AUTH_FAILED = "auth_failed" // Credentials refused by the proxy
+	CHECK_TRANSPARENT = "transparent" // IP address of the caller passed on to the servers
+	CHECK_BLOCKED     = "blocked"     // Requests of the proxy refused by a target
+	CHECK_FAILED      = "failed"      // Any other failure of the requests
+)
+
+// Bytes of the responses read by the checks
+const CHECK_MAX_BODY = 64 * 1024
+
+// Longest probe answer kept as the exit IP address of a proxy
+const CHECK_MAX_EXIT_IP = 64
+
+// Errors of the proxies refusing the credentials, answered to the CONNECT of
+// https requests and in the SOCKS5 handshake. The plain http requests are
+// answered with 407 instead.
+var CHECK_AUTH_ERRORS = []string{
+	http.StatusText(http.StatusProxyAuthRequired),
+	"username/password authentication failed",
+	"no acceptable authentication methods",
+}
+
+// Request of a target through the proxy
+type TargetResult struct {
+	URL        string        `json:"url"`
+	HTTPStatus int           `json:"http_status,omitempty"`
+	Latency    time.Duration `json:"latency"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// Health of a proxy. Latencies are left zero for the steps not run.
+type CheckResult struct {
+	Proxy          string         `json:"proxy"` // Label of the proxy, without the credentials
+	Status         string         `json:"status"`
+	ConnectLatency time.Duration  `json:"connect_latency"`
+	Latency        time.Duration  `json:"latency"` // Request of the probe URL
+	ExitIP         string         `json:"exit_ip,omitempty"`
+	Targets        []TargetResult `json:"targets,omitempty"`
+	Error          string         `json:"error,omitempty"`
+	CheckedAt      time.Time      `json:"checked_at"`
+}
+
+func (r CheckResult) Healthy() bool {
+	return r.Status == CHECK_OK
+}
+
+// Checks the proxies by connecting to them, requesting the probe URL to
+// detect transparent proxies, and requesting the targets to detect the
+// proxies blocked by the upstream sources.
+type Checker struct {
+	cfg       config.ProxyCheckConfig
+	userAgent string
+
+	originOnce sync.Once
+	originIP   string // Public IP address of the caller, empty if unknown
+}
+
+func NewChecker(cfg config.ProxyCheckConfig, userAgent string) *Checker {
+	return &Checker{cfg: cfg, userAgent: userAgent}
+}
+
+// Checker of the proxy check config, targeting the stockanalysis endpoint by
+// default, with the first user agent of the http client profile.
+func NewCheckerByConfig(cfg *config.Config) *Checker {
+	checkCfg := cfg.ProxyCheck
+	if len(checkCfg.Targets) == 0 {
+		checkCfg.Targets = []string{cfg.Endpoints.StockAnalysis}
+	}
+	userAgent := ""
+	if len(cfg.HTTPClient.UserAgents) > 0 {
+		userAgent = cfg.HTTPClient.UserAgents[0]
+	}
+	return NewChecker(checkCfg, userAgent)
+}
+
+// Check the proxies with the configured number of workers. Results are in the
+// order of the proxies.
+func (c *Checker) CheckAll(ctx context.Context, proxies []Proxy) []CheckResult {
+	results := make([]CheckResult, len(proxies))
+	inChan := make(chan int, len(proxies))
+	for i := range proxies {
+		inChan <- i
+	}
+	close(inChan)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(c.cfg.Workers, 1), len(proxies)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range inChan {
+				results[i] = c.Check(ctx, proxies[i])
+			}
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (c *Checker) Check(ctx context.Context, p Proxy) CheckResult {
+	result := CheckResult{Proxy: p.Label(), CheckedAt: time.Now().UTC()}
+	fail := func(status string, err error) CheckResult {
+		result.Status = status
+		result.Error = err.Error()
+		return result
+	}
+
+	dialer := &net.Dialer{Timeout: c.cfg.Timeout}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr())
+	if err != nil {
+		return fail(CHECK_UNREACHABLE, fmt.Errorf("failed to connect: %v", err))
+	}
+	conn.Close()
+	result.ConnectLatency = time.Since(start)
+
+	client := c.newClient(http.ProxyURL(p.URL()))
+	if len(c.cfg.ProbeURL) > 0 {
+		status, body, latency, err := c.get(ctx, client, c.cfg.ProbeURL)
+		result.Latency = latency
+		if status == http.StatusProxyAuthRequired {
+			return fail(CHECK_AUTH_FAILED, fmt.Errorf("probe %s answered %d", c.cfg.ProbeURL, status))
+		}
+		if authFailed(err) {
+			return fail(CHECK_AUTH_FAILED, err)
+		}
+		if err != nil {
+			return fail(CHECK_FAILED, err)
+		}
+		if status != http.StatusOK {
+			return fail(CHECK_FAILED, fmt.Errorf("probe %s answered %d", c.cfg.ProbeURL, status))
+		}
+		if exitIP := strings.TrimSpace(body); len(exitIP) <= CHECK_MAX_EXIT_IP {
+			result.ExitIP = exitIP
+		}
+		if origin := c.origin(ctx); len(origin) > 0 && strings.Contains(body, origin) {
+			return fail(CHECK_TRANSPARENT, fmt.Errorf("probe %s answered the origin IP %s", c.cfg.ProbeURL, origin))
+		}
+	}
+
+	result.Status = CHECK_OK
+	for _, target := range c.cfg.Targets {
+		status, _, latency, err := c.get(ctx, client, target)
+		targetResult := TargetResult{URL: target, HTTPStatus: status, Latency: latency}
+		switch {
+		case status == http.StatusProxyAuthRequired:
+			err = fmt.Errorf("target %s answered %d", target, status)
+			result.Status, result.Error = CHECK_AUTH_FAILED, err.Error()
+		case authFailed(err):
+			result.Status, result.Error = CHECK_AUTH_FAILED, err.Error()
+		case err != nil:
+			result.Status, result.Error = CHECK_FAILED, err.Error()
+		case status >= http.StatusBadRequest:
+			err = fmt.Errorf("target %s answered %d", target, status)
+			result.Status, result.Error = CHECK_BLOCKED, err.Error()
+		}
+		if err != nil {
+			targetResult.Error = err.Error()
+		}
+		result.Targets = append(result.Targets, targetResult)
+		if !result.Healthy() {
+			break
+		}
+	}
+	return result
+}
+
+// Public IP address of the caller, requested once without proxy
+func (c *Checker) origin(ctx context.Context) string {
+	c.originOnce.Do(func() {
+		status, body, _, err := c.get(ctx, c.newClient(nil), c.cfg.ProbeURL)
+		if err == nil && status == http.StatusOK {
+			c.originIP = strings.TrimSpace(body)
+		}
+	})
+	return c.originIP
+}
+
+func (c *Checker) newClient(proxy func(*http.Request) (*url.URL, error)) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 proxy,
+			DialContext:           (&net.Dialer{Timeout: c.cfg.Timeout}).DialContext,
+			TLSHandshakeTimeout:   c.cfg.Timeout,
+			ResponseHeaderTimeout: c.cfg.Timeout,
+			DisableKeepAlives:     true,
+		},
+		Timeout: 2 * c.cfg.Timeout,
+		// Redirects of the targets are answers of the targets
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+// Status, body and latency of the request of the url. Network errors are
+// returned with zero status.
+func (c *Checker) get(ctx context.Context, client *http.Client, url string) (int, string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, "", 0, fmt.Errorf("failed to create request for %s: %v", url, err)
+	}
+	if len(c.userAgent) > 0 {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", time.Since(start), fmt.Errorf("failed to request %s: %v", url, unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, CHECK_MAX_BODY))
+	latency := time.Since(start)
+	if err != nil {
+		return resp.StatusCode, "", latency, fmt.Errorf("failed to read %s: %v", url, err)
+	}
+	return resp.StatusCode, string(body), latency, nil
+}
+
+// Whether the request failed as the proxy refused the credentials. The errors
+// of the transport are not typed, so they are told by their text.
+func authFailed(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, text := range CHECK_AUTH_ERRORS {
+		if strings.HasSuffix(err.Error(), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Errors of the client repeat the method and the url of the request
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
@@ -0,0 +1,301 @@
+package proxy_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/proxy"
+)
+
+const TEST_TARGET = "http://target.test/stocks/"
+
+// Forward proxy answering the requests itself. The probe is answered with the
+// exit IP address, the target with the status.
+func newTestProxy(t *testing.T, probeHost string, exitIP string, targetStatus int) proxy.Proxy {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := parseProxyAuthorization(r)
+		if !ok || user != "user" || password != "pass:word" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		switch r.URL.Host {
+		case probeHost:
+			fmt.Fprint(w, exitIP)
+		case "target.test":
+			w.WriteHeader(targetStatus)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	return proxy.Proxy{Scheme: proxy.SCHEME_HTTP, Host: u.Hostname(), Port: u.Port(), User: "user", Password: "pass:word"}
+}
+
+func parseProxyAuthorization(r *http.Request) (string, string, bool) {
+	req := &http.Request{Header: http.Header{"Authorization": r.Header["Proxy-Authorization"]}}
+	return req.BasicAuth()
+}
+
+func TestChecker_CheckAll(t *testing.T) {
+	// Direct requests of the probe are from the origin
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		fmt.Fprint(w, host)
+	}))
+	defer probe.Close()
+	probeHost := strings.TrimPrefix(probe.URL, "http://")
+
+	healthy := newTestProxy(t, probeHost, "203.0.113.7", http.StatusOK)
+	noAuth := healthy
+	noAuth.User, noAuth.Password = "", ""
+	transparent := newTestProxy(t, probeHost, "203.0.113.7, 127.0.0.1", http.StatusOK)
+	blocked := newTestProxy(t, probeHost, "203.0.113.8", http.StatusForbidden)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen. Error: %v", err)
+	}
+	closed, _ := proxy.Parse(listener.Addr().String())
+	listener.Close()
+
+	checker := proxy.NewChecker(config.ProxyCheckConfig{
+		ProbeURL: probe.URL,
+		Targets:  []string{TEST_TARGET},
+		Timeout:  5 * time.Second,
+		Workers:  2,
+	}, "sdc-test")
+	results := checker.CheckAll(context.Background(), []proxy.Proxy{healthy, noAuth, transparent, blocked, closed})
+
+	want := []string{proxy.CHECK_OK, proxy.CHECK_AUTH_FAILED, proxy.CHECK_TRANSPARENT, proxy.CHECK_BLOCKED, proxy.CHECK_UNREACHABLE}
+	if len(results) != len(want) {
+		t.Fatalf("Expecting %d results, got %d", len(want), len(results))
+	}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("Result %d of %s = %s (%s), want %s", i, result.Proxy, result.Status, result.Error, want[i])
+		}
+		if strings.Contains(result.Proxy+result.Error, "pass:word") {
+			t.Errorf("Expecting the credentials left out of the result, got %+v", result)
+		}
+	}
+
+	ok := results[0]
+	if !ok.Healthy() || ok.Proxy != healthy.Label() || ok.ExitIP != "203.0.113.7" || ok.ConnectLatency <= 0 || ok.Latency <= 0 {
+		t.Errorf("Unexpected result of the healthy proxy %+v", ok)
+	}
+	if len(ok.Targets) != 1 || ok.Targets[0].URL != TEST_TARGET || ok.Targets[0].HTTPStatus != http.StatusOK {
+		t.Errorf("Unexpected targets of the healthy proxy %+v", ok.Targets)
+	}
+	if targets := results[3].Targets; len(targets) != 1 || targets[0].HTTPStatus != http.StatusForbidden || len(targets[0].Error) == 0 {
+		t.Errorf("Unexpected targets of the blocked proxy %+v", targets)
+	}
+}
+
+// Forward proxy tunnelling the https requests with CONNECT
+func newTestConnectProxy(t *testing.T) proxy.Proxy {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := parseProxyAuthorization(r)
+		if !ok || user != "user" || password != "pass:word" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method != http.MethodConnect {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		rw.Flush()
+		pipe(conn, rw.Reader, target)
+	}))
+	t.Cleanup(server.Close)
+
+	u, _ := url.Parse(server.URL)
+	return proxy.Proxy{Scheme: proxy.SCHEME_HTTP, Host: u.Hostname(), Port: u.Port(), User: "user", Password: "pass:word"}
+}
+
+// SOCKS5 proxy requiring the username and password authentication, see RFC
+// 1928 and RFC 1929
+func newTestSOCKS5Proxy(t *testing.T) proxy.Proxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen. Error: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS5(conn)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return proxy.Proxy{Scheme: proxy.SCHEME_SOCKS5, Host: host, Port: port, User: "user", Password: "pass:word"}
+}
+
+func serveSOCKS5(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	// Methods offered by the client
+	header := readBytes(2)
+	if header == nil {
+		conn.Close()
+		return
+	}
+	methods := readBytes(int(header[1]))
+	if !strings.ContainsRune(string(methods), 0x02) {
+		conn.Write([]byte{0x05, 0xff}) // No acceptable methods
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{0x05, 0x02})
+
+	// Username and password
+	version := readBytes(2)
+	if version == nil {
+		conn.Close()
+		return
+	}
+	user := string(readBytes(int(version[1])))
+	length := readBytes(1)
+	if length == nil {
+		conn.Close()
+		return
+	}
+	password := string(readBytes(int(length[0])))
+	if user != "user" || password != "pass:word" {
+		conn.Write([]byte{0x01, 0x01})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{0x01, 0x00})
+
+	// CONNECT to an IPv4 address or a domain name
+	request := readBytes(4)
+	if request == nil {
+		conn.Close()
+		return
+	}
+	var host string
+	switch request[3] {
+	case 0x01:
+		host = net.IP(readBytes(4)).String()
+	case 0x03:
+		length := readBytes(1)
+		if length == nil {
+			conn.Close()
+			return
+		}
+		host = string(readBytes(int(length[0])))
+	default:
+		conn.Close()
+		return
+	}
+	port := binary.BigEndian.Uint16(readBytes(2))
+	target, err := net.Dial("tcp", net.JoinHostPort(host, fmt.Sprint(port)))
+	if err != nil {
+		conn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) // Connection refused
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	pipe(conn, r, target)
+}
+
+// Copy between the client and the target until either closes
+func pipe(conn net.Conn, client io.Reader, target net.Conn) {
+	go func() {
+		io.Copy(target, client)
+		target.Close()
+	}()
+	io.Copy(conn, target)
+	conn.Close()
+}
+
+func TestChecker_Check_ConnectAndSOCKS5(t *testing.T) {
+	probe := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "203.0.113.7")
+	}))
+	probe.Config.ErrorLog = log.New(io.Discard, "", 0) // Handshakes refusing the test certificate
+	probe.StartTLS()
+	defer probe.Close()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+
+	connectProxy := newTestConnectProxy(t)
+	connectWrongPassword := connectProxy
+	connectWrongPassword.Password = "wrong"
+	socks5 := newTestSOCKS5Proxy(t)
+	socks5WrongPassword := socks5
+	socks5WrongPassword.Password = "wrong"
+	socks5NoAuth := socks5
+	socks5NoAuth.User, socks5NoAuth.Password = "", ""
+
+	// Credentials refused by the proxies fail the requests instead of
+	// answering 407
+	probeChecker := proxy.NewChecker(config.ProxyCheckConfig{ProbeURL: probe.URL, Timeout: 5 * time.Second}, "sdc-test")
+	targetChecker := proxy.NewChecker(config.ProxyCheckConfig{Targets: []string{target.URL}, Timeout: 5 * time.Second}, "sdc-test")
+	tests := []struct {
+		name    string
+		checker *proxy.Checker
+		proxy   proxy.Proxy
+		want    string
+	}{
+		{"ConnectWrongPassword", probeChecker, connectWrongPassword, proxy.CHECK_AUTH_FAILED},
+		{"SOCKS5", targetChecker, socks5, proxy.CHECK_OK},
+		{"SOCKS5WrongPassword", targetChecker, socks5WrongPassword, proxy.CHECK_AUTH_FAILED},
+		{"SOCKS5NoAuth", targetChecker, socks5NoAuth, proxy.CHECK_AUTH_FAILED},
+		{"SOCKS5WrongPasswordProbe", probeChecker, socks5WrongPassword, proxy.CHECK_AUTH_FAILED},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.checker.Check(context.Background(), tt.proxy)
+			if result.Status != tt.want {
+				t.Errorf("Check() of %s = %s (%s), want %s", result.Proxy, result.Status, result.Error, tt.want)
+			}
+			if strings.Contains(result.Error, "pass:word") {
+				t.Errorf("Expecting the credentials left out of the result, got %+v", result)
+			}
+		})
+	}
+
+	// The probe is tunnelled through the proxy, the certificate of the test
+	// server is unknown to the checker
+	result := probeChecker.Check(context.Background(), connectProxy)
+	if result.Status != proxy.CHECK_FAILED || !strings.Contains(result.Error, "certificate") {
+		t.Errorf("Expecting the probe tunnelled and the test certificate refused, got %+v", result)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/wayming/sdc/cache"
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+	"github.com/wayming/sdc/metrics"
+	"github.com/wayming/sdc/sdclogger"
+)
+
+// Time allowed for the requests in flight when the server stops
+const SERVE_SHUTDOWN_TIMEOUT = 10 * time.Second
+
+var serveCommand = &command{
+	name:          "serve",
+	summary:       "Serve a REST API to start, list and cancel loads, and the metrics on /metrics.",
+	servesMetrics: true,
+	configFlags: func(f *config.Flags) {
+		registerCollectorConfigFlags(f)
+		f.RegisterFinancialsFlags()
+		f.RegisterEODFlags()
+		f.RegisterServeFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		addr := fs.String("addr", "127.0.0.1:5001", "Address to serve the API and the metrics on. Addresses other than localhost require -serve_token.")
+
+		return func(ctx context.Context, cfg *config.Config) error {
+			if len(cfg.Serve.Token) == 0 && !isLoopback(*addr) {
+				return newUsageError("serving the API on %s requires a token, set -serve_token or serve on localhost", *addr)
+			}
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			cm := cache.NewCacheManager(cfg)
+			if err := cm.Connect(); err != nil {
+				return err
+			}
+			defer cm.Disconnect()
+			history := collector.NewRunHistoryByConfig(cfg)
+			defer history.Close()
+
+			runServer := collector.NewRunServer(ctx, cfg, cm, history)
+			mux := http.NewServeMux()
+			mux.Handle("/", runServer.Handler())
+			mux.Handle(metrics.METRICS_PATH, metrics.Handler())
+
+			ln, err := net.Listen("tcp", *addr)
+			if err != nil {
+				return fmt.Errorf("failed to listen on %s: %v", *addr, err)
+			}
+			srv := &http.Server{Handler: mux}
+			served := make(chan error, 1)
+			go func() {
+				served <- srv.Serve(ln)
+			}()
+			fmt.Printf("Serving on %s\n", ln.Addr())
+			sdclogger.SDCLoggerInstance.Printf("Serving on %s", ln.Addr())
+
+			select {
+			case err = <-served:
+			case <-ctx.Done():
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), SERVE_SHUTDOWN_TIMEOUT)
+				defer cancel()
+				err = srv.Shutdown(shutdownCtx)
+			}
+			// The runs are cancelled with the context, also when the server
+			// failed
+			cancel()
+			runServer.Wait()
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+	},
+}
+
+// Whether the address is served on the loopback interface only
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
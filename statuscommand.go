@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/common"
+	"github.com/wayming/sdc/config"
+)
+
+var statusCommand = &command{
+	name:    "status",
+	summary: "Show the configuration and the number of entries in the caches.",
+	configFlags: func(f *config.Flags) {
+		f.RegisterDatabaseFlags()
+		f.RegisterCacheFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		return func(ctx context.Context, cfg *config.Config) error {
+			fmt.Printf("Schema:   %s\n", cfg.SchemaName)
+			fmt.Printf("Postgres: %s:%s/%s\n", cfg.Postgres.Host, cfg.Postgres.Port, cfg.Postgres.Database)
+			fmt.Printf("Redis:    %s:%s/%d\n", cfg.Redis.Host, cfg.Redis.Port, cfg.Redis.DB)
+
+			status, err := collector.CacheStatus(cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Cache:")
+			for _, key := range common.Keys(status) {
+				fmt.Printf("  %-20s %d\n", key, status[key])
+			}
+			return nil
+		}
+	},
+}
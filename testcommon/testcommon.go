@@ -54,14 +54,15 @@ func NewPGTestFixture(t *testing.T) *PGTestFixture {
 func (f *PGTestFixture) Setup(t *testing.T) {
 	f.logger = TestLogger(t.Name())
 	f.logger.Printf("Test setup - %s", t.Name())
+	pg := TestConfig().Postgres
 	f.loader = dbloader.NewPGLoader(f.schema, f.logger)
-	f.loader.Connect(os.Getenv("PGHOST"),
-		os.Getenv("PGPORT"),
-		os.Getenv("PGUSER"),
-		os.Getenv("PGPASSWORD"),
-		os.Getenv("PGDATABASE"))
+	if err := f.loader.Connect(pg.Host, pg.Port, pg.User, pg.Password, pg.Database); err != nil {
+		t.Fatalf("Failed to connect to the test database. Error: %v", err)
+	}
 	f.loader.DropSchema(f.schema)
-	f.loader.CreateSchema(f.schema)
+	if err := f.loader.CreateSchema(f.schema); err != nil {
+		t.Fatalf("Failed to create schema %s. Error: %v", f.schema, err)
+	}
 }
 
 func (f *PGTestFixture) Teardown(t *testing.T) {
@@ -82,8 +83,9 @@ type MockTestFixture struct {
 	dbMock    *dbloader.MockDBLoader
 	cacheMock *cache.MockICacheManager
 	logger    *log.Logger
-	reader    collector.IHttpReader
+	reader    *VCRReader
 	exporter  collector.IDataExporter
+	cfg       *config.Config
 }
 
 func NewMockTestFixture(t *testing.T) *MockTestFixture {
@@ -97,23 +99,31 @@ func (f *MockTestFixture) Setup(t *testing.T) {
 	f.logger.Printf("setup test %s", t.Name())
 	f.mockCtl = gomock.NewController(t)
 	f.dbMock = dbloader.NewMockDBLoader(f.mockCtl)
+	f.cfg = config.NewConfig()
 
-	f.dbMock.EXPECT().CreateSchema(config.SchemaName).AnyTimes()
+	f.dbMock.EXPECT().CreateSchema(f.cfg.SchemaName).AnyTimes()
 	f.dbMock.EXPECT().
-		Exec(NewStringPatternMatcher(strings.ToLower("SET search_path TO " + config.SchemaName))).
+		Exec(NewStringPatternMatcher(strings.ToLower("SET search_path TO " + f.cfg.SchemaName))).
 		AnyTimes()
 	f.dbMock.EXPECT().Disconnect().AnyTimes()
 
 	f.cacheMock = cache.NewMockICacheManager(f.mockCtl)
 	f.cacheMock.EXPECT().Connect().AnyTimes()
 	f.cacheMock.EXPECT().Disconnect().AnyTimes()
+	f.cacheMock.EXPECT().DeleteFromHash(gomock.Any(), gomock.Any()).AnyTimes()
+	f.cacheMock.EXPECT().ReapExpired(gomock.Any()).AnyTimes()
+	f.cacheMock.EXPECT().Ack(gomock.Any(), gomock.Any()).AnyTimes()
 
-	f.reader = collector.NewHttpReader(collector.NewLocalClient())
+	// Replays the cassette of the test, unless recording
+	f.reader = NewVCRReader(t, collector.NewHttpReader(collector.NewLocalClient(collector.NewClientProfileByConfig(f.cfg))))
 
-	f.exporter = collector.NewDBExporter(f.dbMock, config.SchemaName)
+	f.exporter = collector.NewDBExporter(f.dbMock, f.cfg.SchemaName)
 }
 func (f *MockTestFixture) Teardown(t *testing.T) {
 	f.logger.Printf("teardown test %s", t.Name())
+	if err := f.reader.Save(); err != nil {
+		t.Error(err)
+	}
 	f.mockCtl.Finish()
 }
 func (f *MockTestFixture) DBExpect() *dbloader.MockDBLoaderMockRecorder {
@@ -137,10 +147,23 @@ func (m *MockTestFixture) Reader() collector.IHttpReader {
 func (m *MockTestFixture) Exporter() collector.IDataExporter {
 	return m.exporter
 }
+func (m *MockTestFixture) Config() *config.Config {
+	return m.cfg
+}
 
 func SetupTest(testName string) {
 }
 
+// Configuration from the environment of the test containers
+func TestConfig() *config.Config {
+	cfg, err := config.Load("")
+	if err != nil {
+		sdclogger.SDCLoggerInstance.Printf("Failed to load test config. Error: %s", err.Error())
+		return config.NewConfig()
+	}
+	return cfg
+}
+
 func TeardownTest() {
 }
 
@@ -0,0 +1,188 @@
+package testcommon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wayming/sdc/collector"
+)
+
+// Environment variable selecting the mode of the VCR readers
+const VCR_MODE_ENV = "SDC_VCR"
+
+// Modes of the VCR readers
+const (
+	VCR_REPLAY = "replay" // Default. Replay the cassette without network access.
+	VCR_RECORD = "record" // Send the requests upstream and save them in the cassette.
+)
+
+// Directory of the cassettes, relative to the package of the test
+const VCR_CASSETTE_DIR = "testdata/vcr"
+
+// Kinds of the interactions recorded
+const (
+	VCR_READ     = "read"
+	VCR_REDIRECT = "redirect"
+)
+
+// Request and response recorded in a cassette
+type VCRInteraction struct {
+	Kind   string              `json:"kind"`
+	URL    string              `json:"url"`
+	Params map[string]string   `json:"params,omitempty"`
+	Body   string              `json:"body,omitempty"`   // Redirected url of redirect requests
+	Status int                 `json:"status,omitempty"` // Status of the http server errors
+	Header map[string][]string `json:"header,omitempty"` // Response headers of the http server errors
+	Error  string              `json:"error,omitempty"`
+}
+
+// IHttpReader recording the interactions of a test with the upstream sources
+// in a cassette, and replaying them without network access.
+//
+// Replayed requests are matched by the kind, the url and the parameters.
+// Requests sent several times replay their responses in the recorded order,
+// and the last response once the others are used.
+type VCRReader struct {
+	mode     string
+	file     string
+	reader   collector.IHttpReader // Records the interactions. Not called in replay mode.
+	mu       sync.Mutex
+	recorded []VCRInteraction
+	replayed map[string]int
+}
+
+// VCR reader of the cassette of the test, in the mode of VCR_MODE_ENV. Tests
+// without a cassette replay no interaction.
+func NewVCRReader(t testing.TB, reader collector.IHttpReader) *VCRReader {
+	t.Helper()
+	mode := os.Getenv(VCR_MODE_ENV)
+	if len(mode) == 0 {
+		mode = VCR_REPLAY
+	}
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	file := filepath.Join(VCR_CASSETTE_DIR, name+".json")
+	if _, err := os.Stat(file); mode == VCR_REPLAY && os.IsNotExist(err) {
+		return &VCRReader{mode: mode, file: file, reader: reader, replayed: make(map[string]int)}
+	}
+	return NewVCRReaderByFile(t, file, mode, reader)
+}
+
+// VCR reader of the cassette file. The cassette is loaded in replay mode, and
+// the test fails if it is missing or malformed.
+func NewVCRReaderByFile(t testing.TB, file string, mode string, reader collector.IHttpReader) *VCRReader {
+	t.Helper()
+	r := &VCRReader{mode: mode, file: file, reader: reader, replayed: make(map[string]int)}
+	if mode == VCR_REPLAY {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read cassette %s, record it with %s=%s. Error: %v", file, VCR_MODE_ENV, VCR_RECORD, err)
+		}
+		if err := json.Unmarshal(text, &r.recorded); err != nil {
+			t.Fatalf("Failed to parse cassette %s. Error: %v", file, err)
+		}
+	}
+	return r
+}
+
+func (r *VCRReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	return r.interact(VCR_READ, url, params, func() (string, error) {
+		return r.reader.Read(ctx, url, params)
+	})
+}
+
+func (r *VCRReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	return r.interact(VCR_REDIRECT, url, nil, func() (string, error) {
+		return r.reader.RedirectedUrl(ctx, url)
+	})
+}
+
+func (r *VCRReader) interact(kind string, url string, params map[string]string, send func() (string, error)) (string, error) {
+	if r.mode != VCR_RECORD {
+		return r.replay(kind, url, params)
+	}
+
+	body, err := send()
+	interaction := VCRInteraction{Kind: kind, URL: url, Params: params, Body: body}
+	if err != nil {
+		interaction.Error = err.Error()
+		var serverErr collector.HttpServerError
+		if errors.As(err, &serverErr) {
+			interaction.Status = serverErr.StatusCode()
+			interaction.Header = serverErr.ResponseHeader()
+		}
+	}
+	r.mu.Lock()
+	r.recorded = append(r.recorded, interaction)
+	r.mu.Unlock()
+	return body, err
+}
+
+func (r *VCRReader) replay(kind string, url string, params map[string]string) (string, error) {
+	key := vcrKey(kind, url, params)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var matches []VCRInteraction
+	for _, interaction := range r.recorded {
+		if vcrKey(interaction.Kind, interaction.URL, interaction.Params) == key {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no interaction of %s %s %v in cassette %s, record it with %s=%s",
+			kind, url, params, r.file, VCR_MODE_ENV, VCR_RECORD)
+	}
+	n := min(r.replayed[key], len(matches)-1)
+	r.replayed[key]++
+
+	interaction := matches[n]
+	if interaction.Status != 0 {
+		return "", collector.NewHttpServerError(interaction.Status, interaction.Header, interaction.Error)
+	}
+	if len(interaction.Error) > 0 {
+		return "", errors.New(interaction.Error)
+	}
+	return interaction.Body, nil
+}
+
+// Save the interactions recorded. Nothing is saved in replay mode.
+func (r *VCRReader) Save() error {
+	if r.mode != VCR_RECORD {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	text, err := json.MarshalIndent(r.recorded, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette %s: %v", r.file, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.file), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %v", err)
+	}
+	if err := os.WriteFile(r.file, append(text, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save cassette %s: %v", r.file, err)
+	}
+	return nil
+}
+
+func vcrKey(kind string, url string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n", kind, url)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s=%s\n", key, params[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package testcommon_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/testcommon"
+)
+
+// Upstream answering each request with its count, failing the missing pages
+type upstreamReader struct {
+	reads int
+}
+
+func (r *upstreamReader) Read(ctx context.Context, url string, params map[string]string) (string, error) {
+	r.reads++
+	if strings.HasSuffix(url, "/missing") {
+		return "", collector.NewHttpServerError(http.StatusNotFound, http.Header{"Retry-After": {"120"}}, "Received non-succes status 404 Not Found")
+	}
+	return url + "?" + params["p"] + "#" + string(rune('0'+r.reads)), nil
+}
+
+func (r *upstreamReader) RedirectedUrl(ctx context.Context, url string) (string, error) {
+	r.reads++
+	return strings.Replace(url, "/fb/", "/meta/", 1), nil
+}
+
+func TestVCRReader_RecordReplay(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "vcr", "cassette.json")
+	upstream := &upstreamReader{}
+
+	recorder := testcommon.NewVCRReaderByFile(t, file, testcommon.VCR_RECORD, upstream)
+	recorder.Read(ctx, "https://sa/stocks/msft", map[string]string{"p": "quarterly"})
+	recorder.Read(ctx, "https://sa/stocks/msft", map[string]string{"p": "quarterly"})
+	recorder.RedirectedUrl(ctx, "https://sa/stocks/fb/")
+	recorder.Read(ctx, "https://sa/stocks/missing", nil)
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("VCRReader.Save() error = %v", err)
+	}
+
+	player := testcommon.NewVCRReaderByFile(t, file, testcommon.VCR_REPLAY, nil)
+	for _, want := range []string{"https://sa/stocks/msft?quarterly#1", "https://sa/stocks/msft?quarterly#2", "https://sa/stocks/msft?quarterly#2"} {
+		if got, err := player.Read(ctx, "https://sa/stocks/msft", map[string]string{"p": "quarterly"}); err != nil || got != want {
+			t.Errorf("VCRReader.Read() = %s, %v, want %s", got, err, want)
+		}
+	}
+	if got, _ := player.RedirectedUrl(ctx, "https://sa/stocks/fb/"); got != "https://sa/stocks/meta/" {
+		t.Errorf("VCRReader.RedirectedUrl() = %s", got)
+	}
+
+	// Server errors are replayed with their status and headers
+	var serverErr collector.HttpServerError
+	if _, err := player.Read(ctx, "https://sa/stocks/missing", nil); !errors.As(err, &serverErr) || serverErr.StatusCode() != http.StatusNotFound {
+		t.Errorf("Expecting server error 404 replayed, got %v", err)
+	} else if got := http.Header(serverErr.ResponseHeader()).Get("Retry-After"); got != "120" {
+		t.Errorf("Expecting the headers of the server error replayed, got %v", serverErr.ResponseHeader())
+	}
+
+	// Requests not recorded fail instead of reaching the network
+	if _, err := player.Read(ctx, "https://sa/stocks/msft", map[string]string{"p": "annual"}); err == nil ||
+		!strings.Contains(err.Error(), testcommon.VCR_MODE_ENV) {
+		t.Errorf("Expecting error suggesting to record the request, got %v", err)
+	}
+	if upstream.reads != 4 {
+		t.Errorf("Expecting the upstream requested while recording only, got %d requests", upstream.reads)
+	}
+}
+
+// Test failing on Fatalf without stopping the test running it
+type fatalRecorder struct {
+	testing.TB
+	fatal string
+}
+
+func (r *fatalRecorder) Helper() {}
+func (r *fatalRecorder) Fatalf(format string, args ...any) {
+	r.fatal = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestVCRReader_InvalidCassette(t *testing.T) {
+	dir := t.TempDir()
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte("[{"), 0644); err != nil {
+		t.Fatalf("Failed to write cassette. Error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"Missing", filepath.Join(dir, "missing.json"), testcommon.VCR_MODE_ENV},
+		{"Malformed", malformed, "Failed to parse cassette"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &fatalRecorder{TB: t}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				testcommon.NewVCRReaderByFile(recorder, tt.file, testcommon.VCR_REPLAY, nil)
+			}()
+			<-done
+			if !strings.Contains(recorder.fatal, tt.want) {
+				t.Errorf("Expecting the test failed with %s, got %q", tt.want, recorder.fatal)
+			}
+		})
+	}
+}
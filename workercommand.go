@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+
+	"github.com/wayming/sdc/collector"
+	"github.com/wayming/sdc/config"
+)
+
+var workerCommand = &command{
+	name:    "worker",
+	summary: "Join a distributed load started with -distributed and process its symbols.",
+	configFlags: func(f *config.Flags) {
+		registerCollectorConfigFlags(f)
+		f.RegisterFinancialsFlags()
+		f.RegisterEODFlags()
+		f.RegisterMetricsFlags()
+	},
+	setup: func(fs *flag.FlagSet) func(ctx context.Context, cfg *config.Config) error {
+		runID := fs.String("run", "", "ID of the run to join, printed by the coordinator.")
+		parallel := fs.Int("parallel", 1, "Parallel streams of loading.")
+		report := fs.String("report", "", "Write the report of the symbols processed by this worker in JSON to the file. Use - for stdout.")
+
+		return func(ctx context.Context, cfg *config.Config) error {
+			if len(*runID) == 0 {
+				return newUsageError("-run required")
+			}
+			if *parallel < 1 {
+				return newUsageError("-parallel must be at least 1, got %d", *parallel)
+			}
+			p := collector.PCParams{Output: os.Stdout}
+			if *report == "-" {
+				p.Output = os.Stderr
+			}
+			runReport, err := collector.JoinRun(ctx, cfg, *runID, *parallel, p)
+			if err != nil {
+				return err
+			}
+			if len(*report) > 0 {
+				return writeReport(*report, runReport)
+			}
+			return nil
+		}
+	},
+}